@@ -0,0 +1,98 @@
+package main
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Usage strings
+const USAGE_STRING = "./run-membership.sh -d -f config_file.txt [-n new_config_file.txt]"
+
+const DEBUG_NAME = "d"
+const DEBUG_USAGE = "Output log statements"
+
+const CONFIG_NAME = "f config_file.txt"
+const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
+
+const NEW_CONFIG_NAME = "n new_config_file.txt"
+const NEW_CONFIG_USAGE = "Config file whose BlockAddrs, VirtualNodes, Placement and ReplicationFactor the cluster should move to (default = print the membership)"
+
+// Exit codes
+const EX_USAGE int = 64
+
+// Add or remove BlockStores while the cluster is online. The Raft servers copy
+// the blocks whose owner changes in the background and cut over once done;
+// run without -n to see whether the migration has finished. Restart the Raft
+// servers with the new config afterwards.
+func main() {
+	// Custom flag Usage message
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
+		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", NEW_CONFIG_NAME, NEW_CONFIG_USAGE)
+	}
+
+	// Parse command-line arguments and flags
+	debug := flag.Bool("d", false, DEBUG_USAGE)
+	configFile := flag.String("f", "", "(required) Config file")
+	newConfigFile := flag.String("n", "", NEW_CONFIG_USAGE)
+	flag.Parse()
+
+	if *configFile == "" || len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+
+	// Disable log outputs if debug flag is missing
+	if !(*debug) {
+		log.SetFlags(0)
+		log.SetOutput(ioutil.Discard)
+	}
+
+	addrs := surfstore.LoadRaftConfigFile(*configFile)
+	rpcClient := surfstore.NewSurfstoreRPCClient(addrs.RaftAddrs, "", 0)
+
+	if *newConfigFile != "" {
+		newConfig := surfstore.LoadRaftConfigFile(*newConfigFile)
+		succ := false
+		if err := rpcClient.ChangeBlockMembership(newConfig.GetBlockMembership(), &succ); err != nil || !succ {
+			fmt.Fprintf(os.Stderr, "Could not change the membership: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	var membership surfstore.BlockMembership
+	if err := rpcClient.GetBlockMembership(&membership); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not fetch the membership: %v\n", err)
+		os.Exit(1)
+	}
+	if membership.Migrating {
+		fmt.Println("Migrating blocks to:")
+	} else {
+		fmt.Println("BlockStores:")
+	}
+	for _, server := range membership.Servers {
+		fmt.Printf("  %s (weight %d", server.Addr, server.Weight)
+		if server.Zone != "" {
+			fmt.Printf(", zone %s", server.Zone)
+		}
+		if server.Rack != "" {
+			fmt.Printf(", rack %s", server.Rack)
+		}
+		fmt.Println(")")
+	}
+	if membership.Placement == surfstore.PLACEMENT_RENDEZVOUS {
+		fmt.Println("Placement: rendezvous")
+	} else {
+		fmt.Printf("Placement: ring, %d virtual nodes per unit of weight\n", membership.VirtualNodes)
+	}
+	if membership.ReplicationFactor > 1 {
+		fmt.Printf("Copies of each block: %d\n", membership.ReplicationFactor)
+	}
+}
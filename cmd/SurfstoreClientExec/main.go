@@ -7,14 +7,18 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strconv"
+	"strings"
+	"syscall"
 )
 
 // Arguments
 const ARG_COUNT int = 2
 
 // Usage strings
-const USAGE_STRING = "./run-client.sh -d -f config_file.txt baseDir blockSize"
+const USAGE_STRING = "./run-client.sh -d -f config_file.txt -c codec -k key_file -p passphrase -cache cache_dir -chunker chunker -conflict policy -ignore ignore_file -select dirs -dry-run -plan format -full-rescan -watch -poll interval baseDir blockSize"
 
 const DEBUG_NAME = "d"
 const DEBUG_USAGE = "Output log statements"
@@ -22,6 +26,45 @@ const DEBUG_USAGE = "Output log statements"
 const CONFIG_NAME = "f config_file.txt"
 const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
 
+const CODEC_NAME = "c codec"
+const CODEC_USAGE = "Compression codec for uploaded blocks: none, gzip, zstd, snappy (default none)"
+
+const KEYFILE_NAME = "k key_file"
+const KEYFILE_USAGE = "Key file to encrypt blocks end to end with"
+
+const PASSPHRASE_NAME = "p passphrase"
+const PASSPHRASE_USAGE = "Passphrase to derive the block encryption key from (instead of -k)"
+
+const CACHE_NAME = "cache cache_dir"
+const CACHE_USAGE = "Directory to cache downloaded blocks in, safe to clear at any time"
+
+const CHUNKER_NAME = "chunker chunker"
+const CHUNKER_USAGE = "How new files are split into blocks: fixed, fastcdc or fastcdc:min:avg:max (default fixed). Synced files keep the chunker they were uploaded with"
+
+const CONFLICT_NAME = "conflict policy"
+const CONFLICT_USAGE = "For files changed both locally and on the server: overwrite takes the server's version, copy also keeps the local edits as a conflicted copy, merge merges text files line by line and makes a conflicted copy when that fails (default overwrite)"
+
+const IGNORE_NAME = "ignore ignore_file"
+const IGNORE_USAGE = "Global ignore file, with the same patterns as .surfignore files (default ~/.surfignore)"
+
+const SELECT_NAME = "select dirs"
+const SELECT_USAGE = "Comma-separated directories to sync, saved in index.db for later syncs; an empty list syncs everything (default the saved directories)"
+
+const DRYRUN_NAME = "dry-run"
+const DRYRUN_USAGE = "Print what a sync would upload, download, delete and conflict on, without changing anything"
+
+const PLAN_NAME = "plan format"
+const PLAN_USAGE = "Format of the -dry-run plan: table or json (default table)"
+
+const FULLRESCAN_NAME = "full-rescan"
+const FULLRESCAN_USAGE = "Hash every file, instead of only those whose size, modification time or inode changed since the last sync"
+
+const WATCH_NAME = "watch"
+const WATCH_USAGE = "Keep syncing local and remote changes until interrupted"
+
+const POLL_NAME = "poll interval"
+const POLL_USAGE = "With -watch, scan for local changes every interval (e.g. 2s) instead of using inotify"
+
 const BASEDIR_NAME = "baseDir"
 const BASEDIR_USAGE = "Base directory of the client"
 
@@ -38,6 +81,19 @@ func main() {
 		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
 		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
 		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CODEC_NAME, CODEC_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", KEYFILE_NAME, KEYFILE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", PASSPHRASE_NAME, PASSPHRASE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CACHE_NAME, CACHE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CHUNKER_NAME, CHUNKER_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CONFLICT_NAME, CONFLICT_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", IGNORE_NAME, IGNORE_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", SELECT_NAME, SELECT_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", DRYRUN_NAME, DRYRUN_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", PLAN_NAME, PLAN_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", FULLRESCAN_NAME, FULLRESCAN_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", WATCH_NAME, WATCH_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", POLL_NAME, POLL_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BASEDIR_NAME, BASEDIR_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BLOCK_NAME, BLOCK_USAGE)
 	}
@@ -45,6 +101,19 @@ func main() {
 	// Parse command-line arguments and flags
 	debug := flag.Bool("d", false, DEBUG_USAGE)
 	configFile := flag.String("f", "", "(required) Config file")
+	codecName := flag.String("c", "none", CODEC_USAGE)
+	keyFile := flag.String("k", "", KEYFILE_USAGE)
+	passphrase := flag.String("p", "", PASSPHRASE_USAGE)
+	cacheDir := flag.String("cache", "", CACHE_USAGE)
+	chunkerSpec := flag.String("chunker", "fixed", CHUNKER_USAGE)
+	conflictPolicy := flag.String("conflict", surfstore.CONFLICT_OVERWRITE, CONFLICT_USAGE)
+	ignoreFile := flag.String("ignore", "", IGNORE_USAGE)
+	selectDirs := flag.String("select", "", SELECT_USAGE)
+	dryRun := flag.Bool("dry-run", false, DRYRUN_USAGE)
+	planFormat := flag.String("plan", surfstore.PLAN_FORMAT_TABLE, PLAN_USAGE)
+	fullRescan := flag.Bool("full-rescan", false, FULLRESCAN_USAGE)
+	watch := flag.Bool("watch", false, WATCH_USAGE)
+	pollInterval := flag.Duration("poll", 0, POLL_USAGE)
 	flag.Parse()
 
 	// Use tail arguments to hold non-flag arguments
@@ -62,6 +131,66 @@ func main() {
 		flag.Usage()
 		os.Exit(EX_USAGE)
 	}
+	codec, err := surfstore.ParseCodec(*codecName)
+	if err != nil {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	chunker, err := surfstore.ParseChunker(*chunkerSpec, blockSize)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if !surfstore.IsConflictPolicy(*conflictPolicy) {
+		fmt.Fprintf(os.Stderr, "Error: unknown conflict policy %q\n", *conflictPolicy)
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	// the saved selection is kept unless -select is given
+	var selection []string
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == "select" {
+			selection = []string{}
+			if *selectDirs != "" {
+				selection = strings.Split(*selectDirs, ",")
+			}
+		}
+	})
+	if _, err := surfstore.ParseSelection(selection); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err.Error())
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if !surfstore.IsPlanFormat(*planFormat) || (*dryRun && *watch) {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+	if *keyFile != "" && *passphrase != "" {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+
+	var cipher *surfstore.BlockCipher
+	if *keyFile != "" {
+		cipher, err = surfstore.NewBlockCipherFromKeyFile(*keyFile)
+	} else if *passphrase != "" {
+		cipher, err = surfstore.NewBlockCipherFromPassphrase(*passphrase)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading encryption key: %s\n", err.Error())
+		os.Exit(EX_USAGE)
+	}
+
+	var blockCache surfstore.BlockStorage
+	if *cacheDir != "" {
+		diskStorage, err := surfstore.NewDiskBlockStorage(*cacheDir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening block cache: %s\n", err.Error())
+			os.Exit(EX_USAGE)
+		}
+		blockCache = surfstore.NewCachedBlockStorage(diskStorage, surfstore.DEFAULT_CLIENT_CACHE_BUDGET)
+	}
 
 	log.Println("Client syncing with ", addrs, baseDir, blockSize)
 
@@ -72,5 +201,34 @@ func main() {
 	}
 
 	rpcClient := surfstore.NewSurfstoreRPCClient(addrs.RaftAddrs, baseDir, blockSize)
-	surfstore.ClientSync(rpcClient)
+	rpcClient.Codec = codec
+	rpcClient.Cipher = cipher
+	rpcClient.BlockCache = blockCache
+	rpcClient.Chunker = chunker.Spec()
+	rpcClient.ConflictPolicy = *conflictPolicy
+	rpcClient.IgnoreFile = *ignoreFile
+	rpcClient.Selection = selection
+	rpcClient.DryRun = *dryRun
+	rpcClient.PlanFormat = *planFormat
+	rpcClient.FullRescan = *fullRescan
+	if *ignoreFile == "" {
+		if home, err := os.UserHomeDir(); err == nil {
+			rpcClient.IgnoreFile = filepath.Join(home, surfstore.IGNORE_FILENAME)
+		}
+	}
+
+	if !(*watch) {
+		surfstore.ClientSync(rpcClient)
+		return
+	}
+
+	// stop after the sync in progress, leaving index.db up to date
+	stop := make(chan struct{})
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-signals
+		close(stop)
+	}()
+	surfstore.ClientWatch(rpcClient, *pollInterval, stop)
 }
@@ -14,11 +14,14 @@ import (
 const ARG_COUNT int = 2
 
 // Usage strings
-const USAGE_STRING = "./run-client.sh -d -f config_file.txt baseDir blockSize"
+const USAGE_STRING = "./run-client.sh -d -r -f config_file.txt baseDir blockSize"
 
 const DEBUG_NAME = "d"
 const DEBUG_USAGE = "Output log statements"
 
+const REPORT_NAME = "r"
+const REPORT_USAGE = "Also report how blocks and placement ownership are distributed across BlockStores"
+
 const CONFIG_NAME = "f config_file.txt"
 const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
 
@@ -37,6 +40,7 @@ func main() {
 		w := flag.CommandLine.Output()
 		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
 		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", REPORT_NAME, REPORT_USAGE)
 		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BASEDIR_NAME, BASEDIR_USAGE)
 		fmt.Fprintf(w, "  %s: %v\n", BLOCK_NAME, BLOCK_USAGE)
@@ -44,6 +48,7 @@ func main() {
 
 	// Parse command-line arguments and flags
 	debug := flag.Bool("d", false, DEBUG_USAGE)
+	report := flag.Bool("r", false, REPORT_USAGE)
 	configFile := flag.String("f", "", "(required) Config file")
 	flag.Parse()
 
@@ -71,6 +76,9 @@ func main() {
 
 	rpcClient := surfstore.NewSurfstoreRPCClient(addrs.RaftAddrs, baseDir, blockSize)
 	PrintBlocksOnEachServer(rpcClient)
+	if *report {
+		PrintDistributionReport(rpcClient, addrs)
+	}
 }
 
 func PrintBlocksOnEachServer(client surfstore.RPCClient) {
@@ -99,3 +107,46 @@ func PrintBlocksOnEachServer(client surfstore.RPCClient) {
 	}
 	fmt.Println(result)
 }
+
+// Compare each BlockStore's share of the stored blocks with the share the
+// placement strategy gives it and the share its weight entitles it to
+func PrintDistributionReport(client surfstore.RPCClient, config surfstore.RaftConfig) {
+	servers := config.GetBlockServers()
+	ownedShares := config.GetPlacementStrategy().GetOwnershipShares()
+
+	totalWeight := 0
+	for _, server := range servers {
+		totalWeight += server.Weight
+	}
+
+	blockCounts := make(map[string]int)
+	totalBlocks := 0
+	for _, server := range servers {
+		hashes := []string{}
+		if err := client.GetBlockHashes(server.Addr, &hashes); err != nil {
+			log.Fatal("[Surfstore RPCClient]:", "Error During Fetching Blocks on Block Server ", err)
+		}
+		blockCounts[server.Addr] = len(hashes)
+		totalBlocks += len(hashes)
+	}
+
+	if config.Placement == surfstore.PLACEMENT_RENDEZVOUS {
+		fmt.Printf("%d BlockStores, rendezvous placement, %d blocks\n", len(servers), totalBlocks)
+	} else {
+		virtualNodes := config.VirtualNodes
+		if virtualNodes < 1 {
+			virtualNodes = 1
+		}
+		fmt.Printf("%d BlockStores, %d virtual nodes per unit of weight, %d blocks\n", len(servers), virtualNodes, totalBlocks)
+	}
+	fmt.Printf("%-24s %6s %8s %10s %8s %8s\n", "BlockStore", "Weight", "Expected", "Owned", "Blocks", "Share")
+	for _, server := range servers {
+		blockShare := 0.0
+		if totalBlocks > 0 {
+			blockShare = float64(blockCounts[server.Addr]) / float64(totalBlocks)
+		}
+		fmt.Printf("%-24s %6d %7.1f%% %9.1f%% %8d %7.1f%%\n", server.Addr, server.Weight,
+			100*float64(server.Weight)/float64(totalWeight), 100*ownedShares[server.Addr],
+			blockCounts[server.Addr], 100*blockShare)
+	}
+}
@@ -5,12 +5,17 @@ import (
 	"flag"
 	"io/ioutil"
 	"log"
+	"time"
 )
 
 func main() {
 	serverId := flag.Int64("i", -1, "(required) Server ID")
 	configFile := flag.String("f", "", "(required) Config file, absolute path")
 	debug := flag.Bool("d", false, "Output log statements")
+	gcInterval := flag.Duration("gc", 0, "(default = off) Interval between block garbage collections while leader, e.g. 1h")
+	gcGracePeriod := flag.Duration("gc-grace", surfstore.DEFAULT_GC_GRACE_PERIOD, "Minimum age of a block before garbage collection may delete it")
+	statsInterval := flag.Duration("stats", surfstore.DEFAULT_STATS_INTERVAL, "Interval between refreshes of BlockStore space stats while leader")
+	migrationInterval := flag.Duration("migrate", surfstore.DEFAULT_MIGRATION_INTERVAL, "Interval between attempts to finish an interrupted block migration while leader")
 	flag.Parse()
 
 	config := surfstore.LoadRaftConfigFile(*configFile)
@@ -21,14 +26,25 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	log.Fatal(startServer(*serverId, config))
+	log.Fatal(startServer(*serverId, config, *gcInterval, *gcGracePeriod, *statsInterval, *migrationInterval))
 }
 
-func startServer(id int64, config surfstore.RaftConfig) error {
+func startServer(id int64, config surfstore.RaftConfig, gcInterval time.Duration, gcGracePeriod time.Duration, statsInterval time.Duration, migrationInterval time.Duration) error {
 	raftServer, err := surfstore.NewRaftServer(id, config)
 	if err != nil {
 		log.Fatal("Error creating servers")
 	}
 
+	raftServer.SetGCGracePeriod(gcGracePeriod)
+	if gcInterval > 0 {
+		raftServer.StartGarbageCollector(gcInterval)
+	}
+	if statsInterval > 0 {
+		raftServer.StartStatsPoller(statsInterval)
+	}
+	if migrationInterval > 0 {
+		raftServer.StartMigrator(migrationInterval)
+	}
+
 	return surfstore.ServeRaftServer(raftServer)
 }
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+)
+
+// Usage strings
+const USAGE_STRING = "./run-reencrypt.sh -d -f config_file.txt (blockStoreAddr*)"
+
+const DEBUG_NAME = "d"
+const DEBUG_USAGE = "Output log statements"
+
+const CONFIG_NAME = "f config_file.txt"
+const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
+
+const ADDRS_NAME = "(blockStoreAddr*)"
+const ADDRS_USAGE = "BlockStores to re-encrypt (default = every BlockStore the Raft servers know)"
+
+// Exit codes
+const EX_USAGE int = 64
+
+// Rotate the at-rest key of BlockStores started with -k: after the new key is
+// added to each key file and made current, every BlockStore reloads its key
+// file and rewrites the blocks still sealed under an old key.
+func main() {
+	// Custom flag Usage message
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
+		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
+		fmt.Fprintf(w, "  %s: %v\n", ADDRS_NAME, ADDRS_USAGE)
+	}
+
+	// Parse command-line arguments and flags
+	debug := flag.Bool("d", false, DEBUG_USAGE)
+	configFile := flag.String("f", "", "(required) Config file")
+	flag.Parse()
+
+	if *configFile == "" {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+
+	// Disable log outputs if debug flag is missing
+	if !(*debug) {
+		log.SetFlags(0)
+		log.SetOutput(ioutil.Discard)
+	}
+
+	addrs := surfstore.LoadRaftConfigFile(*configFile)
+	rpcClient := surfstore.NewSurfstoreRPCClient(addrs.RaftAddrs, "", 0)
+
+	// Use tail arguments to hold BlockStore addresses
+	blockStoreAddrs := flag.Args()
+	if len(blockStoreAddrs) == 0 {
+		if err := rpcClient.GetBlockStoreAddrs(&blockStoreAddrs); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not fetch BlockStore addresses: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	failed := false
+	for _, addr := range blockStoreAddrs {
+		rewritten := []string{}
+		if err := rpcClient.ReencryptBlocks(addr, &rewritten); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", addr, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: re-encrypted %d blocks\n", addr, len(rewritten))
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
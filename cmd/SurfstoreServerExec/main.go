@@ -10,12 +10,13 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	"google.golang.org/grpc"
 )
 
 // Usage String
-const USAGE_STRING = "./run-server.sh -s <service_type> -p <port> -l -d (blockStoreAddr*)"
+const USAGE_STRING = "./run-server.sh -s <service_type> -p <port> -l -d -k <key_file> -capacity <bytes> -dir <block_dir> -cache <bytes> (blockStoreAddr*)"
 
 // Set of valid services
 var SERVICE_TYPES = map[string]bool{"meta": true, "block": true, "both": true}
@@ -32,6 +33,7 @@ func main() {
 			fmt.Fprintf(w, "  -%s: %v\n", f.Name, f.Usage)
 		})
 		fmt.Fprintf(w, "  (blockStoreAddr*): BlockStore Address (include self if service type is both)\n")
+		fmt.Fprintf(w, "                    For a block service, peer BlockStores to repair corrupt blocks from\n")
 	}
 
 	// Parse command-line argument flags
@@ -39,6 +41,11 @@ func main() {
 	port := flag.Int("p", 8080, "(default = 8080) Port to accept connections")
 	localOnly := flag.Bool("l", false, "Only listen on localhost")
 	debug := flag.Bool("d", false, "Output log statements")
+	scrubInterval := flag.Duration("scrub", 0, "(default = off) Interval between scrubs of stored blocks, e.g. 10m")
+	keyFile := flag.String("k", "", "(default = off) Key file to encrypt stored blocks at rest with")
+	capacity := flag.Int64("capacity", 0, "(default = unlimited) Bytes of block data this BlockStore may hold")
+	blockDir := flag.String("dir", "", "(default = in memory) Directory to store blocks in")
+	cacheBudget := flag.Int64("cache", 0, "(default = off) Bytes of hot blocks to keep in memory in front of -dir")
 	flag.Parse()
 
 	// Use tail arguments to hold BlockStore address
@@ -67,7 +74,7 @@ func main() {
 		log.SetOutput(ioutil.Discard)
 	}
 
-	log.Fatal(startServer(addr, strings.ToLower(*service), blockStoreAddrs))
+	log.Fatal(startServer(addr, strings.ToLower(*service), blockStoreAddrs, *scrubInterval, *keyFile, *capacity, *blockDir, *cacheBudget))
 }
 
 func startBlockStoreServer(block *surfstore.BlockStore, hostAddr string) error {
@@ -125,7 +132,7 @@ func startServer_deprecated(hostAddr string, serviceType string, blockStoreAddrs
 	return nil
 }
 
-func startServer(hostAddr string, serviceType string, blockStoreAddrs []string) error {
+func startServer(hostAddr string, serviceType string, blockStoreAddrs []string, scrubInterval time.Duration, keyFile string, capacity int64, blockDir string, cacheBudget int64) error {
 	//	fmt.Printf("serviceType: %s\n", serviceType)
 
 	listener, err := net.Listen("tcp", hostAddr)
@@ -146,7 +153,31 @@ func startServer(hostAddr string, serviceType string, blockStoreAddrs []string)
 			log.Printf("failed to serve: %v", err)
 		}
 	} else if serviceType == "block" {
-		surfstore.RegisterBlockStoreServer(server, surfstore.NewBlockStore())
+		var storage surfstore.BlockStorage = surfstore.NewMemoryBlockStorage()
+		if blockDir != "" {
+			storage, err = surfstore.NewDiskBlockStorage(blockDir)
+			if err != nil {
+				log.Printf("Error opening block directory: %s\n", err.Error())
+				return err
+			}
+		}
+		if keyFile != "" {
+			storage, err = surfstore.NewEncryptedBlockStorage(storage, keyFile)
+			if err != nil {
+				log.Printf("Error loading key file: %s\n", err.Error())
+				return err
+			}
+		}
+		if cacheBudget > 0 {
+			storage = surfstore.NewCachedBlockStorage(storage, cacheBudget)
+		}
+		blockStore := surfstore.NewBlockStoreWithStorage(storage)
+		blockStore.PeerAddrs = blockStoreAddrs
+		blockStore.Capacity = capacity
+		if scrubInterval > 0 {
+			blockStore.StartScrubber(scrubInterval)
+		}
+		surfstore.RegisterBlockStoreServer(server, blockStore)
 		if err := server.Serve(listener); err != nil {
 			log.Printf("failed to serve: %v", err)
 		}
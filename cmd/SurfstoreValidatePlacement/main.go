@@ -0,0 +1,111 @@
+package main
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// Usage strings
+const USAGE_STRING = "./run-validate.sh -d -f config_file.txt"
+
+const DEBUG_NAME = "d"
+const DEBUG_USAGE = "Output log statements"
+
+const CONFIG_NAME = "f config_file.txt"
+const CONFIG_USAGE = "Path to config file that specifies addresses for all Raft nodes"
+
+// Exit codes
+const EX_USAGE int = 64
+
+// Check every stored block against the cluster's failure domains: report the
+// blocks whose copies share a zone or rack, and the blocks with fewer copies
+// than the replication factor. Exits with 1 if any are found.
+func main() {
+	// Custom flag Usage message
+	flag.Usage = func() {
+		w := flag.CommandLine.Output()
+		fmt.Fprintf(w, "Usage of %s:\n", USAGE_STRING)
+		fmt.Fprintf(w, "  -%s: %v\n", DEBUG_NAME, DEBUG_USAGE)
+		fmt.Fprintf(w, "  -%s: %v\n", CONFIG_NAME, CONFIG_USAGE)
+	}
+
+	// Parse command-line arguments and flags
+	debug := flag.Bool("d", false, DEBUG_USAGE)
+	configFile := flag.String("f", "", "(required) Config file")
+	flag.Parse()
+
+	if *configFile == "" || len(flag.Args()) != 0 {
+		flag.Usage()
+		os.Exit(EX_USAGE)
+	}
+
+	// Disable log outputs if debug flag is missing
+	if !(*debug) {
+		log.SetFlags(0)
+		log.SetOutput(ioutil.Discard)
+	}
+
+	addrs := surfstore.LoadRaftConfigFile(*configFile)
+	rpcClient := surfstore.NewSurfstoreRPCClient(addrs.RaftAddrs, "", 0)
+
+	// the cluster's membership has the labels, the config may be out of date
+	var membership surfstore.BlockMembership
+	if err := rpcClient.GetBlockMembership(&membership); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not fetch the membership: %v\n", err)
+		os.Exit(1)
+	}
+	servers := make([]surfstore.BlockServerConfig, 0, len(membership.Servers))
+	for _, server := range membership.Servers {
+		servers = append(servers, surfstore.BlockServerConfig{Addr: server.Addr, Weight: int(server.Weight), Zone: server.Zone, Rack: server.Rack})
+	}
+	replicationFactor := int(membership.ReplicationFactor)
+	if replicationFactor < 1 {
+		replicationFactor = 1
+	}
+
+	blockStoreAddrs := []string{}
+	if err := rpcClient.GetBlockStoreAddrs(&blockStoreAddrs); err != nil {
+		fmt.Fprintf(os.Stderr, "Could not fetch BlockStore addresses: %v\n", err)
+		os.Exit(1)
+	}
+	holders := make(map[string][]string) // hash -> BlockStores storing it
+	for _, addr := range blockStoreAddrs {
+		hashes := []string{}
+		if err := rpcClient.GetBlockHashes(addr, &hashes); err != nil {
+			fmt.Fprintf(os.Stderr, "Could not list blocks on %s: %v\n", addr, err)
+			os.Exit(1)
+		}
+		for _, hash := range hashes {
+			holders[hash] = append(holders[hash], addr)
+		}
+	}
+
+	hashes := make([]string, 0, len(holders))
+	for hash := range holders {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+
+	sharedCount, underReplicatedCount := 0, 0
+	for _, hash := range hashes {
+		for _, pair := range surfstore.FindSharedFailureDomains(holders[hash], servers) {
+			fmt.Printf("%s: copies on %s and %s share a failure domain\n", hash, pair[0], pair[1])
+			sharedCount++
+		}
+		if len(holders[hash]) < replicationFactor {
+			fmt.Printf("%s: %d of %d copies, on %s\n", hash, len(holders[hash]), replicationFactor, strings.Join(holders[hash], ", "))
+			underReplicatedCount++
+		}
+	}
+
+	fmt.Printf("%d blocks, %d copy pairs sharing a failure domain, %d blocks under-replicated\n", len(hashes), sharedCount, underReplicatedCount)
+	if sharedCount > 0 || underReplicatedCount > 0 {
+		os.Exit(1)
+	}
+}
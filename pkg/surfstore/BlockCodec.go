@@ -0,0 +1,94 @@
+package surfstore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/klauspost/compress/s2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Codecs every BlockStore and client in this build can encode and decode
+var SUPPORTED_CODECS = []Codec{Codec_NONE, Codec_GZIP, Codec_ZSTD, Codec_SNAPPY}
+
+// Encoder and decoder are safe for concurrent use and expensive to create
+var zstdEncoder, _ = zstd.NewWriter(nil)
+var zstdDecoder, _ = zstd.NewReader(nil)
+
+// ParseCodec maps a codec name such as "gzip" to its Codec
+func ParseCodec(name string) (Codec, error) {
+	codec, found := Codec_value[strings.ToUpper(name)]
+	if !found {
+		return Codec_NONE, fmt.Errorf("unknown codec %q", name)
+	}
+	return Codec(codec), nil
+}
+
+// CompressBlockData encodes uncompressed block data with the codec
+func CompressBlockData(data []byte, codec Codec) ([]byte, error) {
+	switch codec {
+	case Codec_NONE:
+		return data, nil
+	case Codec_GZIP:
+		var buf bytes.Buffer
+		writer := gzip.NewWriter(&buf)
+		if _, err := writer.Write(data); err != nil {
+			return nil, err
+		}
+		if err := writer.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case Codec_ZSTD:
+		return zstdEncoder.EncodeAll(data, nil), nil
+	case Codec_SNAPPY:
+		return s2.EncodeSnappy(nil, data), nil
+	}
+	return nil, fmt.Errorf("unsupported codec %s", codec)
+}
+
+// DecompressBlockData decodes block data that was compressed with the codec
+func DecompressBlockData(data []byte, codec Codec) ([]byte, error) {
+	switch codec {
+	case Codec_NONE:
+		return data, nil
+	case Codec_GZIP:
+		reader, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		defer reader.Close()
+		return ioutil.ReadAll(reader)
+	case Codec_ZSTD:
+		return zstdDecoder.DecodeAll(data, nil)
+	case Codec_SNAPPY:
+		return s2.Decode(nil, data)
+	}
+	return nil, fmt.Errorf("unsupported codec %s", codec)
+}
+
+// CompressBlock returns a copy of an uncompressed block encoded with the codec.
+// Data that does not shrink is sent as is.
+func CompressBlock(block *Block, codec Codec) (*Block, error) {
+	compressed, err := CompressBlockData(block.BlockData, codec)
+	if err != nil {
+		return nil, err
+	}
+	if len(compressed) >= len(block.BlockData) {
+		return &Block{BlockData: block.BlockData, BlockSize: block.BlockSize, Codec: Codec_NONE}, nil
+	}
+	return &Block{BlockData: compressed, BlockSize: block.BlockSize, Codec: codec}, nil
+}
+
+// GetBlockContentHash returns the hash of the block's uncompressed content,
+// which is the key the block is stored under
+func GetBlockContentHash(block *Block) (string, error) {
+	data, err := DecompressBlockData(block.BlockData, block.Codec)
+	if err != nil {
+		return "", err
+	}
+	return GetBlockHashString(data), nil
+}
@@ -0,0 +1,102 @@
+package surfstore
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+// Fixed so every client holding the same passphrase derives the same key
+const PASSPHRASE_SALT string = "surfstore-block-encryption"
+
+// BlockCipher encrypts blocks on the client before they reach a BlockStore.
+// Encryption is convergent: the nonce is derived from the plaintext, so equal
+// blocks encrypt to equal ciphertexts and still deduplicate, while block
+// hashes are HMACs so the servers cannot link them to known content.
+type BlockCipher struct {
+	hashKey  []byte
+	nonceKey []byte
+	aead     cipher.AEAD
+}
+
+// NewBlockCipher derives the hashing, nonce and encryption keys from key material
+func NewBlockCipher(masterKey []byte) (*BlockCipher, error) {
+	if len(masterKey) == 0 {
+		return nil, fmt.Errorf("empty encryption key")
+	}
+
+	subKeys := make([][]byte, 3)
+	for idx, info := range []string{"surfstore block hash", "surfstore block nonce", "surfstore block encryption"} {
+		subKeys[idx] = make([]byte, 32)
+		if _, err := io.ReadFull(hkdf.New(sha256.New, masterKey, nil, []byte(info)), subKeys[idx]); err != nil {
+			return nil, err
+		}
+	}
+
+	aesCipher, err := aes.NewCipher(subKeys[2])
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(aesCipher)
+	if err != nil {
+		return nil, err
+	}
+	return &BlockCipher{hashKey: subKeys[0], nonceKey: subKeys[1], aead: aead}, nil
+}
+
+// NewBlockCipherFromPassphrase stretches a passphrase into the key material
+func NewBlockCipherFromPassphrase(passphrase string) (*BlockCipher, error) {
+	masterKey, err := scrypt.Key([]byte(passphrase), []byte(PASSPHRASE_SALT), 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockCipher(masterKey)
+}
+
+// NewBlockCipherFromKeyFile uses the contents of a key file as the key material
+func NewBlockCipherFromKeyFile(path string) (*BlockCipher, error) {
+	masterKey, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlockCipher(bytes.TrimSpace(masterKey))
+}
+
+// HashBlock returns the keyed hash a plaintext block is stored under
+func (c *BlockCipher) HashBlock(blockData []byte) string {
+	mac := hmac.New(sha256.New, c.hashKey)
+	mac.Write(blockData)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Seal encrypts a (possibly compressed) block stored under hash. The nonce is
+// derived from the keyed hash of the plaintext and the codec, so clients
+// compressing differently still seal equal blocks the same way per codec, and
+// is prepended to the ciphertext.
+func (c *BlockCipher) Seal(block *Block, hash string) *Block {
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(hash))
+	mac.Write([]byte{byte(block.Codec)})
+	nonce := mac.Sum(nil)[:c.aead.NonceSize()]
+
+	sealed := c.aead.Seal(nonce, nonce, block.BlockData, []byte(hash))
+	return &Block{BlockData: sealed, BlockSize: block.BlockSize, Codec: block.Codec, Encrypted: true, Hash: hash}
+}
+
+// Open decrypts a block sealed under hash, returning the (possibly compressed) data
+func (c *BlockCipher) Open(block *Block, hash string) ([]byte, error) {
+	nonceSize := c.aead.NonceSize()
+	if len(block.BlockData) < nonceSize {
+		return nil, fmt.Errorf("encrypted block %s is too short", hash)
+	}
+	return c.aead.Open(nil, block.BlockData[:nonceSize], block.BlockData[nonceSize:], []byte(hash))
+}
@@ -0,0 +1,233 @@
+package surfstore
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// MemoryBlockStorage keeps blocks in a map. It is the default BlockStorage.
+type MemoryBlockStorage struct {
+	BlockMap map[string]*Block
+}
+
+func (m *MemoryBlockStorage) Get(hash string) (*Block, bool, error) {
+	block, found := m.BlockMap[hash]
+	return block, found, nil
+}
+
+func (m *MemoryBlockStorage) Has(hash string) (bool, error) {
+	_, found := m.BlockMap[hash]
+	return found, nil
+}
+
+func (m *MemoryBlockStorage) Put(hash string, block *Block) error {
+	m.BlockMap[hash] = block
+	return nil
+}
+
+func (m *MemoryBlockStorage) Delete(hash string) error {
+	delete(m.BlockMap, hash)
+	return nil
+}
+
+func (m *MemoryBlockStorage) Hashes() ([]string, error) {
+	hashes := make([]string, 0, len(m.BlockMap))
+	for hash := range m.BlockMap {
+		hashes = append(hashes, hash)
+	}
+	return hashes, nil
+}
+
+func (m *MemoryBlockStorage) Size(hash string) (int64, bool, error) {
+	block, found := m.BlockMap[hash]
+	if !found {
+		return 0, false, nil
+	}
+	return int64(len(block.BlockData)), true, nil
+}
+
+// This line guarantees all method for MemoryBlockStorage are implemented
+var _ BlockStorage = new(MemoryBlockStorage)
+
+func NewMemoryBlockStorage() *MemoryBlockStorage {
+	return &MemoryBlockStorage{
+		BlockMap: map[string]*Block{},
+	}
+}
+
+// DiskBlockStorage keeps each block in its own file under Dir, named after its
+// hash and sharded by the first two hex digits, so blocks survive restarts
+type DiskBlockStorage struct {
+	Dir string
+}
+
+func (d *DiskBlockStorage) Get(hash string) (*Block, bool, error) {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return nil, false, nil
+	}
+	contents, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	} else if err != nil {
+		return nil, false, err
+	}
+
+	var block Block
+	if err := proto.Unmarshal(contents, &block); err != nil {
+		return nil, true, fmt.Errorf("block %s: %v", hash, err)
+	}
+	return &block, true, nil
+}
+
+func (d *DiskBlockStorage) Has(hash string) (bool, error) {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return false, nil
+	}
+	_, err = os.Stat(path)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+func (d *DiskBlockStorage) Put(hash string, block *Block) error {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return err
+	}
+	contents, err := proto.Marshal(block)
+	if err != nil {
+		return err
+	}
+	_, err = os.Stat(filepath.Dir(path))
+	newShard := os.IsNotExist(err)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	// write to a temporary file synced to disk first, then rename it, so a
+	// crash never leaves a torn block
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), BLOCK_TEMP_PREFIX+hash)
+	if err != nil {
+		return err
+	}
+	_, err = tmpFile.Write(contents)
+	if err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return err
+	}
+	syncDirectory(filepath.Dir(path))
+	if newShard {
+		syncDirectory(d.Dir)
+	}
+	return nil
+}
+
+func (d *DiskBlockStorage) Delete(hash string) error {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return nil
+	}
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (d *DiskBlockStorage) Hashes() ([]string, error) {
+	shards, err := os.ReadDir(d.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make([]string, 0)
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(d.Dir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if _, err := d.blockPath(entry.Name()); err == nil && !entry.IsDir() {
+				hashes = append(hashes, entry.Name())
+			}
+		}
+	}
+	return hashes, nil
+}
+
+// The size of the block's file, which holds the block and its fields
+func (d *DiskBlockStorage) Size(hash string) (int64, bool, error) {
+	path, err := d.blockPath(hash)
+	if err != nil {
+		return 0, false, nil
+	}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	return info.Size(), true, nil
+}
+
+// Hashes come from clients, only hex ones are turned into file names
+func (d *DiskBlockStorage) blockPath(hash string) (string, error) {
+	if len(hash) < 2 {
+		return "", fmt.Errorf("invalid block hash %q", hash)
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", fmt.Errorf("invalid block hash %q", hash)
+	}
+	return filepath.Join(d.Dir, hash[:2], hash), nil
+}
+
+// This line guarantees all method for DiskBlockStorage are implemented
+var _ BlockStorage = new(DiskBlockStorage)
+
+// NewDiskBlockStorage stores blocks under dir, creating it if needed. Temporary
+// files a crash left behind in the middle of a Put are removed.
+func NewDiskBlockStorage(dir string) (*DiskBlockStorage, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	shards, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, shard := range shards {
+		if !shard.IsDir() {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(dir, shard.Name()))
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if strings.HasPrefix(entry.Name(), BLOCK_TEMP_PREFIX) {
+				if err := os.Remove(filepath.Join(dir, shard.Name(), entry.Name())); err != nil {
+					return nil, err
+				}
+			}
+		}
+	}
+	return &DiskBlockStorage{Dir: dir}, nil
+}
@@ -0,0 +1,190 @@
+package surfstore
+
+import (
+	"container/list"
+	"sync"
+)
+
+// A block is only cached once it has been accessed this often, so one-off
+// reads such as a full download do not flush the hot blocks
+const CACHE_ADMISSION_FREQUENCY int = 2
+
+// Access counts are halved after this many accesses so old popularity fades
+const CACHE_FREQUENCY_WINDOW int = 10000
+
+// Memory budget of the client-local block cache
+const DEFAULT_CLIENT_CACHE_BUDGET int64 = 64 * 1024 * 1024
+
+type CacheStats struct {
+	Hits      int64
+	Misses    int64
+	Evictions int64
+	Bytes     int64 // block data held in memory
+}
+
+// CachedBlockStorage keeps recently used blocks in memory in front of a slower
+// backend such as a DiskBlockStorage. Writes go through to the backend. Blocks
+// are admitted once accessed CACHE_ADMISSION_FREQUENCY times and evicted least
+// recently used first when the budget is exceeded. Unlike the other storages
+// it is safe for concurrent use, so clients can share one across goroutines.
+type CachedBlockStorage struct {
+	Backend BlockStorage
+	Budget  int64 // bytes of block data kept in memory
+
+	entries   map[string]*list.Element
+	lru       *list.List // of *cacheEntry, most recently used at the front
+	frequency map[string]int
+	accesses  int
+	stats     CacheStats
+	mutex     *sync.Mutex
+}
+
+type cacheEntry struct {
+	hash  string
+	block *Block
+}
+
+func (c *CachedBlockStorage) Get(hash string) (*Block, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.recordAccess(hash)
+	if element, found := c.entries[hash]; found {
+		c.stats.Hits++
+		c.lru.MoveToFront(element)
+		return element.Value.(*cacheEntry).block, true, nil
+	}
+
+	c.stats.Misses++
+	block, found, err := c.Backend.Get(hash)
+	if err != nil || !found {
+		return block, found, err
+	}
+	c.admit(hash, block)
+	return block, true, nil
+}
+
+func (c *CachedBlockStorage) Has(hash string) (bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if _, found := c.entries[hash]; found {
+		return true, nil
+	}
+	return c.Backend.Has(hash)
+}
+
+func (c *CachedBlockStorage) Put(hash string, block *Block) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if err := c.Backend.Put(hash, block); err != nil {
+		return err
+	}
+	c.remove(hash)
+	c.recordAccess(hash)
+	c.admit(hash, block)
+	return nil
+}
+
+func (c *CachedBlockStorage) Delete(hash string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	c.remove(hash)
+	delete(c.frequency, hash)
+	return c.Backend.Delete(hash)
+}
+
+func (c *CachedBlockStorage) Hashes() ([]string, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Backend.Hashes()
+}
+
+func (c *CachedBlockStorage) Size(hash string) (int64, bool, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.Backend.Size(hash)
+}
+
+// Stats returns the hit, miss and eviction counters
+func (c *CachedBlockStorage) Stats() CacheStats {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	return c.stats
+}
+
+// Count an access towards admission, aging all counts once the window is full.
+// Callers must hold mutex.
+func (c *CachedBlockStorage) recordAccess(hash string) {
+	c.frequency[hash]++
+	c.accesses++
+	if c.accesses < CACHE_FREQUENCY_WINDOW {
+		return
+	}
+
+	c.accesses = 0
+	for h, count := range c.frequency {
+		if count/2 == 0 {
+			delete(c.frequency, h)
+		} else {
+			c.frequency[h] = count / 2
+		}
+	}
+}
+
+// Cache the block if it is accessed often enough and fits in the budget,
+// evicting the least recently used blocks to make room. Callers must hold mutex.
+func (c *CachedBlockStorage) admit(hash string, block *Block) {
+	size := int64(len(block.BlockData))
+	if c.frequency[hash] < CACHE_ADMISSION_FREQUENCY || size > c.Budget {
+		return
+	}
+
+	for c.stats.Bytes+size > c.Budget {
+		oldest := c.lru.Back()
+		c.remove(oldest.Value.(*cacheEntry).hash)
+		c.stats.Evictions++
+	}
+	c.entries[hash] = c.lru.PushFront(&cacheEntry{hash: hash, block: block})
+	c.stats.Bytes += size
+}
+
+// Drop the block from memory. Callers must hold mutex.
+func (c *CachedBlockStorage) remove(hash string) {
+	element, found := c.entries[hash]
+	if !found {
+		return
+	}
+	c.lru.Remove(element)
+	delete(c.entries, hash)
+	c.stats.Bytes -= int64(len(element.Value.(*cacheEntry).block.BlockData))
+}
+
+// The storage underneath any caches, for full scans that should neither be
+// served from memory nor count as accesses
+func uncachedStorage(storage BlockStorage) BlockStorage {
+	if cached, ok := storage.(*CachedBlockStorage); ok {
+		return uncachedStorage(cached.Backend)
+	}
+	return storage
+}
+
+// This line guarantees all method for CachedBlockStorage are implemented
+var _ BlockStorage = new(CachedBlockStorage)
+
+// NewCachedBlockStorage caches up to budget bytes of the backend's blocks in memory
+func NewCachedBlockStorage(backend BlockStorage, budget int64) *CachedBlockStorage {
+	return &CachedBlockStorage{
+		Backend:   backend,
+		Budget:    budget,
+		entries:   map[string]*list.Element{},
+		lru:       list.New(),
+		frequency: map[string]int{},
+		mutex:     &sync.Mutex{},
+	}
+}
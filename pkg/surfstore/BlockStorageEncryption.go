@@ -0,0 +1,240 @@
+package surfstore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Keyring holds the AES keys blocks are encrypted at rest with. Blocks are
+// always sealed with the current key; older keys stay in the file until
+// ReencryptBlocks has moved every block off them.
+//
+// Key file format:
+//
+//	{"CurrentKeyId": "2024-06", "Keys": {"2024-01": "<hex key>", "2024-06": "<hex key>"}}
+//
+// Keys are hex encoded 16, 24 or 32 byte AES keys.
+type Keyring struct {
+	CurrentKeyId string
+	Keys         map[string]string
+	aeads        map[string]cipher.AEAD
+}
+
+// LoadKeyringFile reads and validates a key file
+func LoadKeyringFile(filename string) (*Keyring, error) {
+	contents, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyring Keyring
+	if err := json.Unmarshal(contents, &keyring); err != nil {
+		return nil, fmt.Errorf("key file %s: %v", filename, err)
+	}
+	if _, found := keyring.Keys[keyring.CurrentKeyId]; !found {
+		return nil, fmt.Errorf("key file %s: current key %q is not in Keys", filename, keyring.CurrentKeyId)
+	}
+
+	keyring.aeads = make(map[string]cipher.AEAD)
+	for keyId, hexKey := range keyring.Keys {
+		if len(keyId) == 0 || len(keyId) > 255 {
+			return nil, fmt.Errorf("key file %s: key ids must be 1 to 255 bytes long", filename)
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: key %q: %v", filename, keyId, err)
+		}
+		aesCipher, err := aes.NewCipher(key)
+		if err != nil {
+			return nil, fmt.Errorf("key file %s: key %q: %v", filename, keyId, err)
+		}
+		aead, err := cipher.NewGCM(aesCipher)
+		if err != nil {
+			return nil, err
+		}
+		keyring.aeads[keyId] = aead
+	}
+	return &keyring, nil
+}
+
+// EncryptedBlockStorage encrypts blocks with AES-GCM before handing them to
+// the storage underneath, so a stolen disk or backup reveals nothing. Each
+// stored block records the id of the key that sealed it:
+//
+//	len(keyId) | keyId | nonce | ciphertext
+//
+// The hash is used as additional data, so a block moved to another hash fails
+// to decrypt.
+type EncryptedBlockStorage struct {
+	Storage      BlockStorage // where the sealed blocks are kept
+	KeyFile      string
+	keyring      *Keyring
+	keyringMutex *sync.RWMutex
+}
+
+func (e *EncryptedBlockStorage) Get(hash string) (*Block, bool, error) {
+	sealed, found, err := e.Storage.Get(hash)
+	if err != nil || !found {
+		return nil, found, err
+	}
+	block, err := e.open(hash, sealed)
+	return block, true, err
+}
+
+func (e *EncryptedBlockStorage) Has(hash string) (bool, error) {
+	return e.Storage.Has(hash)
+}
+
+func (e *EncryptedBlockStorage) Put(hash string, block *Block) error {
+	sealed, err := e.seal(hash, block)
+	if err != nil {
+		return err
+	}
+	return e.Storage.Put(hash, sealed)
+}
+
+func (e *EncryptedBlockStorage) Delete(hash string) error {
+	return e.Storage.Delete(hash)
+}
+
+func (e *EncryptedBlockStorage) Hashes() ([]string, error) {
+	return e.Storage.Hashes()
+}
+
+// The size of the sealed block
+func (e *EncryptedBlockStorage) Size(hash string) (int64, bool, error) {
+	return e.Storage.Size(hash)
+}
+
+// ReloadKeyring re-reads the key file, picking up a new current key
+func (e *EncryptedBlockStorage) ReloadKeyring() error {
+	keyring, err := LoadKeyringFile(e.KeyFile)
+	if err != nil {
+		return err
+	}
+	e.keyringMutex.Lock()
+	e.keyring = keyring
+	e.keyringMutex.Unlock()
+	return nil
+}
+
+// Reencrypt rewrites every block that is not sealed under the current key and
+// returns their hashes
+func (e *EncryptedBlockStorage) Reencrypt() ([]string, error) {
+	hashes, err := e.Storage.Hashes()
+	if err != nil {
+		return nil, err
+	}
+
+	e.keyringMutex.RLock()
+	currentKeyId := e.keyring.CurrentKeyId
+	e.keyringMutex.RUnlock()
+
+	rewritten := make([]string, 0)
+	for _, hash := range hashes {
+		sealed, found, err := e.Storage.Get(hash)
+		if err != nil {
+			return rewritten, err
+		}
+		if !found {
+			continue
+		}
+		if keyId, _, err := splitSealedBlock(sealed.BlockData); err == nil && keyId == currentKeyId {
+			continue
+		}
+
+		block, err := e.open(hash, sealed)
+		if err != nil {
+			return rewritten, fmt.Errorf("block %s: %v", hash, err)
+		}
+		if err := e.Put(hash, block); err != nil {
+			return rewritten, err
+		}
+		rewritten = append(rewritten, hash)
+	}
+	return rewritten, nil
+}
+
+func (e *EncryptedBlockStorage) seal(hash string, block *Block) (*Block, error) {
+	plaintext, err := proto.Marshal(block)
+	if err != nil {
+		return nil, err
+	}
+
+	e.keyringMutex.RLock()
+	keyId := e.keyring.CurrentKeyId
+	aead := e.keyring.aeads[keyId]
+	e.keyringMutex.RUnlock()
+
+	sealed := make([]byte, 0, 1+len(keyId)+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	sealed = append(sealed, byte(len(keyId)))
+	sealed = append(sealed, keyId...)
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	sealed = append(sealed, nonce...)
+	sealed = aead.Seal(sealed, nonce, plaintext, []byte(hash))
+	return &Block{BlockData: sealed}, nil
+}
+
+func (e *EncryptedBlockStorage) open(hash string, sealed *Block) (*Block, error) {
+	keyId, rest, err := splitSealedBlock(sealed.BlockData)
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %v", hash, err)
+	}
+
+	e.keyringMutex.RLock()
+	aead, found := e.keyring.aeads[keyId]
+	e.keyringMutex.RUnlock()
+	if !found {
+		return nil, fmt.Errorf("block %s is sealed with unknown key %q", hash, keyId)
+	}
+	if len(rest) < aead.NonceSize() {
+		return nil, fmt.Errorf("block %s is too short", hash)
+	}
+
+	plaintext, err := aead.Open(nil, rest[:aead.NonceSize()], rest[aead.NonceSize():], []byte(hash))
+	if err != nil {
+		return nil, fmt.Errorf("block %s: %v", hash, err)
+	}
+	var block Block
+	if err := proto.Unmarshal(plaintext, &block); err != nil {
+		return nil, fmt.Errorf("block %s: %v", hash, err)
+	}
+	return &block, nil
+}
+
+// Split stored data into the id of the key that sealed it and nonce|ciphertext
+func splitSealedBlock(data []byte) (string, []byte, error) {
+	if len(data) == 0 || len(data) < 1+int(data[0]) {
+		return "", nil, fmt.Errorf("sealed block is too short")
+	}
+	return string(data[1 : 1+int(data[0])]), data[1+int(data[0]):], nil
+}
+
+// This line guarantees all method for EncryptedBlockStorage are implemented
+var _ BlockStorage = new(EncryptedBlockStorage)
+
+// NewEncryptedBlockStorage wraps storage, sealing blocks with the keys in keyFile
+func NewEncryptedBlockStorage(storage BlockStorage, keyFile string) (*EncryptedBlockStorage, error) {
+	keyring, err := LoadKeyringFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+	return &EncryptedBlockStorage{
+		Storage:      storage,
+		KeyFile:      keyFile,
+		keyring:      keyring,
+		keyringMutex: &sync.RWMutex{},
+	}, nil
+}
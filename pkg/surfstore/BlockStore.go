@@ -2,17 +2,38 @@ package surfstore
 
 import (
 	context "context"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
 
+	grpc "google.golang.org/grpc"
 	emptypb "google.golang.org/protobuf/types/known/emptypb"
 )
 
+var ERR_BLOCKSTORE_FULL = fmt.Errorf("BlockStore is full")
+
 type BlockStore struct {
-	BlockMap map[string]*Block
+	Storage       BlockStorage
+	Capacity      int64             // bytes the stored blocks may take up, 0 for unlimited
+	QuarantineMap map[string]*Block // blocks whose content no longer matches their hash
+	unreadable    map[string]bool   // quarantined blocks that could not be read at all
+	PeerAddrs     []string          // other block servers to repair quarantined blocks from
+	putTimes      map[string]time.Time
+	blockSizes    map[string]int64
+	bytesUsed     int64
+	storageMutex  *sync.RWMutex
 	UnimplementedBlockStoreServer
 }
 
 func (bs *BlockStore) GetBlock(ctx context.Context, blockHash *BlockHash) (*Block, error) {
-	blockVal, errorHash := bs.BlockMap[blockHash.Hash]
+	bs.storageMutex.RLock()
+	blockVal, errorHash, err := bs.Storage.Get(blockHash.Hash)
+	bs.storageMutex.RUnlock()
+	if err != nil {
+		return nil, err
+	}
 
 	// Hash not in map
 	if !errorHash {
@@ -25,21 +46,45 @@ func (bs *BlockStore) GetBlock(ctx context.Context, blockHash *BlockHash) (*Bloc
 
 // Return a list containing all blockHashes on this block server
 func (bs *BlockStore) GetBlockHashes(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error) {
-	blockHashList := make([]string, 0)
-	for blockHash, _ := range bs.BlockMap {
-		blockHashList = append(blockHashList, blockHash)
+	bs.storageMutex.RLock()
+	defer bs.storageMutex.RUnlock()
+
+	blockHashList, err := bs.Storage.Hashes()
+	if err != nil {
+		return nil, err
 	}
 	return &BlockHashes{Hashes: blockHashList}, ctx.Err()
 }
 
 // How would this function fail?
 func (bs *BlockStore) PutBlock(ctx context.Context, block *Block) (*Success, error) {
-	if bs.BlockMap == nil {
-		bs.BlockMap = make(map[string]*Block)
+	bs.storageMutex.Lock()
+	hash, err := getBlockKey(block)
+	if err != nil {
+		bs.storageMutex.Unlock()
+		return &Success{Flag: false}, err
 	}
-
-	hash := GetBlockHashString(block.BlockData)
-	bs.BlockMap[hash] = block
+	if block.Encrypted {
+		// the server cannot check an encrypted block against its key, but the
+		// keyed hash already binds the plaintext, so any block stored under it
+		// holds the same content (perhaps under another codec) and is kept
+		var found bool
+		_, found, err = bs.Storage.Size(hash)
+		if err == nil && !found {
+			err = bs.storeBlock(hash, &Block{BlockData: block.BlockData, BlockSize: block.BlockSize, Codec: block.Codec,
+				Encrypted: true, Hash: hash, Checksum: GetBlockHashString(block.BlockData)})
+		}
+	} else {
+		err = bs.storeBlock(hash, block)
+	}
+	if err != nil {
+		bs.storageMutex.Unlock()
+		return &Success{Flag: false}, err
+	}
+	bs.putTimes[hash] = time.Now()
+	delete(bs.QuarantineMap, hash) // a good copy replaces a corrupt one
+	delete(bs.unreadable, hash)
+	bs.storageMutex.Unlock()
 
 	//fmt.Printf("computed hash: %s = len: %d\n", hash, block.BlockSize)
 
@@ -53,13 +98,51 @@ func (bs *BlockStore) PutBlock(ctx context.Context, block *Block) (*Success, err
 	return &success, ctx.Err()
 }
 
+// Stream the blocks for the given hashes back in the same order, sending an
+// empty block for any hash that is not stored
+func (bs *BlockStore) GetBlocks(blockHashesIn *BlockHashes, stream BlockStore_GetBlocksServer) error {
+	for _, hash := range blockHashesIn.Hashes {
+		block, err := bs.GetBlock(stream.Context(), &BlockHash{Hash: hash})
+		if err != nil {
+			return err
+		}
+		if err := stream.Send(block); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Store every block sent on the stream
+func (bs *BlockStore) PutBlocks(stream BlockStore_PutBlocksServer) error {
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&Success{Flag: true})
+		}
+		if err != nil {
+			return err
+		}
+
+		if _, err := bs.PutBlock(stream.Context(), block); err != nil {
+			return err
+		}
+	}
+}
+
 // Given a list of hashes “in”, returns a list containing the
 // subset of in that are stored in the key-value store
 func (bs *BlockStore) HasBlocks(ctx context.Context, blockHashesIn *BlockHashes) (*BlockHashes, error) {
+	bs.storageMutex.RLock()
+	defer bs.storageMutex.RUnlock()
+
 	stored := make([]string, 0)
 
 	for _, hash := range blockHashesIn.Hashes {
-		_, hashFound := bs.BlockMap[hash]
+		hashFound, err := bs.Storage.Has(hash)
+		if err != nil {
+			return nil, err
+		}
 		if hashFound {
 			stored = append(stored, hash)
 		}
@@ -69,11 +152,336 @@ func (bs *BlockStore) HasBlocks(ctx context.Context, blockHashesIn *BlockHashes)
 	return &storedBlockHashes, ctx.Err()
 }
 
+// Delete the given blocks, skipping any that were put within the grace period
+// so uploads whose metadata is not committed yet are not lost. Returns the
+// hashes that were actually deleted.
+func (bs *BlockStore) DeleteBlocks(ctx context.Context, blockDeletion *BlockDeletion) (*BlockHashes, error) {
+	bs.storageMutex.Lock()
+	defer bs.storageMutex.Unlock()
+
+	gracePeriod := time.Duration(blockDeletion.GracePeriodSeconds) * time.Second
+	deleted := make([]string, 0)
+	for _, hash := range blockDeletion.Hashes {
+		if hashFound, err := bs.Storage.Has(hash); err != nil || !hashFound {
+			continue
+		}
+		if time.Since(bs.putTimes[hash]) < gracePeriod {
+			continue
+		}
+		if err := bs.removeBlock(hash); err != nil {
+			return &BlockHashes{Hashes: deleted}, err
+		}
+		delete(bs.putTimes, hash)
+		deleted = append(deleted, hash)
+	}
+	return &BlockHashes{Hashes: deleted}, ctx.Err()
+}
+
+// Return the codecs this server accepts blocks in
+func (bs *BlockStore) GetCodecs(ctx context.Context, _ *emptypb.Empty) (*Codecs, error) {
+	return &Codecs{Codecs: SUPPORTED_CODECS}, ctx.Err()
+}
+
+// Return how many bytes the stored blocks hold uncompressed and as stored
+func (bs *BlockStore) GetCompressionStats(ctx context.Context, _ *emptypb.Empty) (*CompressionStats, error) {
+	bs.storageMutex.RLock()
+	defer bs.storageMutex.RUnlock()
+
+	storage := uncachedStorage(bs.Storage)
+	hashes, err := storage.Hashes()
+	if err != nil {
+		return nil, err
+	}
+	var stats CompressionStats
+	for _, hash := range hashes {
+		block, found, err := storage.Get(hash)
+		if err != nil || !found {
+			continue
+		}
+		stats.UncompressedBytes += int64(block.BlockSize)
+		stats.StoredBytes += int64(len(block.BlockData))
+	}
+	return &stats, ctx.Err()
+}
+
+// Return how many blocks are stored and how much of the capacity they use
+func (bs *BlockStore) GetStats(ctx context.Context, _ *emptypb.Empty) (*BlockStoreStats, error) {
+	bs.storageMutex.RLock()
+	defer bs.storageMutex.RUnlock()
+
+	stats := BlockStoreStats{
+		BlockCount:    int64(len(bs.blockSizes)),
+		BytesUsed:     bs.bytesUsed,
+		CapacityBytes: bs.Capacity,
+	}
+	if bs.Capacity > 0 && bs.bytesUsed < bs.Capacity {
+		stats.FreeBytes = bs.Capacity - bs.bytesUsed
+	}
+	if cached, ok := bs.Storage.(*CachedBlockStorage); ok {
+		cacheStats := cached.Stats()
+		stats.CacheHits = cacheStats.Hits
+		stats.CacheMisses = cacheStats.Misses
+	}
+	return &stats, ctx.Err()
+}
+
+// Return the hashes of blocks the scrubber found corrupt and could not repair yet
+func (bs *BlockStore) GetQuarantinedBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error) {
+	bs.storageMutex.RLock()
+	defer bs.storageMutex.RUnlock()
+
+	quarantined := make([]string, 0)
+	for blockHash := range bs.QuarantineMap {
+		quarantined = append(quarantined, blockHash)
+	}
+	for blockHash := range bs.unreadable {
+		quarantined = append(quarantined, blockHash)
+	}
+	return &BlockHashes{Hashes: quarantined}, ctx.Err()
+}
+
+// Reload the at-rest key file and rewrite every block that is not sealed under
+// the current key. Returns the hashes of the rewritten blocks.
+func (bs *BlockStore) ReencryptBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error) {
+	encrypted, ok := uncachedStorage(bs.Storage).(*EncryptedBlockStorage)
+	if !ok {
+		return nil, fmt.Errorf("block storage is not encrypted at rest")
+	}
+
+	bs.storageMutex.Lock()
+	defer bs.storageMutex.Unlock()
+
+	if err := encrypted.ReloadKeyring(); err != nil {
+		return nil, err
+	}
+	rewritten, err := encrypted.Reencrypt()
+	if err != nil {
+		return nil, err
+	}
+	return &BlockHashes{Hashes: rewritten}, ctx.Err()
+}
+
+// Scrub re-hashes every stored block and quarantines those whose content no
+// longer matches their key, then tries to repair every quarantined block from
+// a peer. Encrypted blocks stored without a checksum cannot be checked and are
+// left alone. Blocks are read and hashed one at a time under the read lock, so
+// requests are still served during a pass. Returns the hashes that are still
+// quarantined afterwards.
+func (bs *BlockStore) Scrub() []string {
+	storage := uncachedStorage(bs.Storage)
+	bs.storageMutex.RLock()
+	hashes, err := storage.Hashes()
+	bs.storageMutex.RUnlock()
+	if err != nil {
+		log.Printf("Scrub: could not list blocks: %v\n", err)
+	}
+	for _, hash := range hashes {
+		bs.storageMutex.RLock()
+		block, found, err := storage.Get(hash)
+		bs.storageMutex.RUnlock()
+		if !blockCorrupt(hash, block, found, err) {
+			continue
+		}
+
+		// check again, the block may have been replaced or deleted since
+		bs.storageMutex.Lock()
+		block, found, err = storage.Get(hash)
+		if blockCorrupt(hash, block, found, err) {
+			log.Printf("Scrub: block %s is corrupt, quarantining\n", hash)
+			if err != nil {
+				bs.unreadable[hash] = true
+			} else {
+				bs.QuarantineMap[hash] = block
+			}
+			bs.removeBlock(hash)
+		}
+		bs.storageMutex.Unlock()
+	}
+
+	bs.storageMutex.RLock()
+	quarantined := make([]string, 0, len(bs.QuarantineMap)+len(bs.unreadable))
+	for hash := range bs.QuarantineMap {
+		quarantined = append(quarantined, hash)
+	}
+	for hash := range bs.unreadable {
+		quarantined = append(quarantined, hash)
+	}
+	bs.storageMutex.RUnlock()
+
+	// fetch good copies without holding the lock
+	remaining := make([]string, 0)
+	for _, hash := range quarantined {
+		block, repaired := bs.fetchFromPeers(hash)
+		if !repaired {
+			remaining = append(remaining, hash)
+			continue
+		}
+
+		bs.storageMutex.Lock()
+		if err := bs.storeBlock(hash, block); err != nil {
+			bs.storageMutex.Unlock()
+			log.Printf("Scrub: could not store repaired block %s: %v\n", hash, err)
+			remaining = append(remaining, hash)
+			continue
+		}
+		delete(bs.QuarantineMap, hash)
+		delete(bs.unreadable, hash)
+		bs.storageMutex.Unlock()
+		log.Printf("Scrub: repaired block %s from a peer\n", hash)
+	}
+	return remaining
+}
+
+// StartScrubber runs Scrub every interval in the background
+func (bs *BlockStore) StartScrubber(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if remaining := bs.Scrub(); len(remaining) > 0 {
+				log.Printf("Scrub: %d blocks remain quarantined\n", len(remaining))
+			}
+		}
+	}()
+}
+
+// Ask the peers for a copy of the block, starting with the one the ring
+// assigns the hash to, and only accept a copy whose content matches the hash
+func (bs *BlockStore) fetchFromPeers(hash string) (*Block, bool) {
+	if len(bs.PeerAddrs) == 0 {
+		return nil, false
+	}
+
+	first := NewConsistentHashRing(bs.PeerAddrs).GetResponsibleServer(hash)
+	peers := []string{first}
+	for _, addr := range bs.PeerAddrs {
+		if addr != first {
+			peers = append(peers, addr)
+		}
+	}
+
+	for _, addr := range peers {
+		conn, err := grpc.Dial(addr, grpc.WithInsecure())
+		if err != nil {
+			continue
+		}
+		c := NewBlockStoreClient(conn)
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		block, err := c.GetBlock(ctx, &BlockHash{Hash: hash})
+		cancel()
+		conn.Close()
+		if err != nil {
+			continue
+		}
+
+		if len(block.BlockData) == 0 {
+			continue
+		}
+		if blockIntact(hash, block) {
+			return &Block{BlockData: block.BlockData, BlockSize: block.BlockSize, Codec: block.Codec, Encrypted: block.Encrypted, Hash: block.Hash, Checksum: block.Checksum}, true
+		}
+	}
+	return nil, false
+}
+
+// Store a block, keeping track of the space used. The block data has to fit in
+// the capacity, what the storage adds to it is accounted for once it is stored.
+// Callers must hold storageMutex.
+func (bs *BlockStore) storeBlock(hash string, block *Block) error {
+	size := int64(len(block.BlockData))
+	if bs.Capacity > 0 && bs.bytesUsed-bs.blockSizes[hash]+size > bs.Capacity {
+		return ERR_BLOCKSTORE_FULL
+	}
+	if err := bs.Storage.Put(hash, block); err != nil {
+		return err
+	}
+	if storedSize, found, err := bs.Storage.Size(hash); err == nil && found {
+		size = storedSize
+	}
+	bs.bytesUsed += size - bs.blockSizes[hash]
+	bs.blockSizes[hash] = size
+	return nil
+}
+
+// Remove a block, keeping track of the space used. Callers must hold storageMutex.
+func (bs *BlockStore) removeBlock(hash string) error {
+	if err := bs.Storage.Delete(hash); err != nil {
+		return err
+	}
+	bs.bytesUsed -= bs.blockSizes[hash]
+	delete(bs.blockSizes, hash)
+	return nil
+}
+
+// The key a block is stored under. The server can only hash the content of
+// blocks that are not encrypted, encrypted ones carry their key.
+func getBlockKey(block *Block) (string, error) {
+	if block.Encrypted {
+		if block.Hash == "" {
+			return "", fmt.Errorf("encrypted block without a hash")
+		}
+		return block.Hash, nil
+	}
+	return GetBlockContentHash(block)
+}
+
+// Whether the scrubber should quarantine a block it read from storage. A block
+// deleted since it was listed is not corrupt, and an encrypted block stored
+// without a checksum cannot be checked.
+func blockCorrupt(hash string, block *Block, found bool, err error) bool {
+	if err != nil {
+		return true
+	}
+	if !found || (block.Encrypted && block.Checksum == "") {
+		return false
+	}
+	return !blockIntact(hash, block)
+}
+
+// Check a block still matches its key, or for encrypted blocks the checksum
+// stored with it when it was put
+func blockIntact(hash string, block *Block) bool {
+	if block.Encrypted {
+		return block.Hash == hash && block.Checksum != "" && GetBlockHashString(block.BlockData) == block.Checksum
+	}
+	contentHash, err := GetBlockContentHash(block)
+	return err == nil && contentHash == hash
+}
+
 // This line guarantees all method for BlockStore are implemented
 var _ BlockStoreInterface = new(BlockStore)
 
 func NewBlockStore() *BlockStore {
-	return &BlockStore{
-		BlockMap: map[string]*Block{},
+	return NewBlockStoreWithStorage(NewMemoryBlockStorage())
+}
+
+// NewBlockStoreWithStorage serves the blocks already in storage and stores new ones there
+func NewBlockStoreWithStorage(storage BlockStorage) *BlockStore {
+	blockStore := BlockStore{
+		Storage:       storage,
+		QuarantineMap: map[string]*Block{},
+		unreadable:    map[string]bool{},
+		PeerAddrs:     []string{},
+		putTimes:      map[string]time.Time{},
+		blockSizes:    map[string]int64{},
+		storageMutex:  &sync.RWMutex{},
+	}
+
+	// account for blocks a persistent storage already holds, without reading
+	// them. When they were put is not known, so they get a full grace period
+	// from now in case their metadata is not committed yet.
+	hashes, err := uncachedStorage(storage).Hashes()
+	if err != nil {
+		log.Printf("Could not list stored blocks: %v\n", err)
+	}
+	openedAt := time.Now()
+	for _, hash := range hashes {
+		blockStore.putTimes[hash] = openedAt
+		if size, found, err := uncachedStorage(storage).Size(hash); err == nil && found {
+			blockStore.blockSizes[hash] = size
+			blockStore.bytesUsed += size
+		}
 	}
+	return &blockStore
 }
@@ -0,0 +1,208 @@
+package surfstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+	"strconv"
+	"strings"
+)
+
+// Files are split with the chunker recorded in their FileMetaData, so every
+// client hashes a file the same way whatever chunker it prefers for new files.
+// An empty spec is fixed chunks of the client's BlockSize, which is how files
+// were always split.
+const FASTCDC_CHUNKER string = "fastcdc"
+
+// Split data into chunks, the concatenation of which is data
+type Chunker interface {
+	Split(data []byte) [][]byte
+
+	// Length of the first chunk of data, which only depends on its first
+	// MaxChunkSize bytes, so files can be split as they are read
+	Cut(data []byte) int
+	MaxChunkSize() int
+
+	// The spec recorded in FileMetaData.Chunker for files split by this chunker
+	Spec() string
+}
+
+// FixedChunker splits data into BlockSize chunks, the last one may be shorter
+type FixedChunker struct {
+	BlockSize int
+}
+
+func (c FixedChunker) Split(data []byte) [][]byte {
+	chunks := make([][]byte, 0, len(data)/c.BlockSize+1)
+	for start := 0; start < len(data); start += c.BlockSize {
+		end := start + c.BlockSize
+		if end > len(data) {
+			end = len(data)
+		}
+		chunks = append(chunks, data[start:end])
+	}
+	return chunks
+}
+
+func (c FixedChunker) Cut(data []byte) int {
+	if len(data) < c.BlockSize {
+		return len(data)
+	}
+	return c.BlockSize
+}
+
+func (c FixedChunker) MaxChunkSize() int {
+	return c.BlockSize
+}
+
+func (c FixedChunker) Spec() string {
+	return ""
+}
+
+// FastCDCChunker cuts chunks where a rolling gear hash of the content matches
+// a mask, so an insert or delete only changes the chunks around it. Chunks are
+// between MinSize and MaxSize bytes, AvgSize on average; a stricter mask below
+// AvgSize and a looser one above it keep sizes close to AvgSize.
+type FastCDCChunker struct {
+	MinSize int
+	AvgSize int
+	MaxSize int
+}
+
+// Random values for each byte, derived from the byte so all clients agree
+var gearTable = func() [256]uint64 {
+	var table [256]uint64
+	for i := range table {
+		h := sha256.Sum256([]byte{byte(i)})
+		table[i] = binary.BigEndian.Uint64(h[:8])
+	}
+	return table
+}()
+
+func (c FastCDCChunker) Split(data []byte) [][]byte {
+	chunks := make([][]byte, 0, len(data)/c.AvgSize+1)
+	for len(data) > 0 {
+		cut := c.Cut(data)
+		chunks = append(chunks, data[:cut])
+		data = data[cut:]
+	}
+	return chunks
+}
+
+func (c FastCDCChunker) Cut(data []byte) int {
+	if len(data) <= c.MinSize {
+		return len(data)
+	}
+	end := len(data)
+	if end > c.MaxSize {
+		end = c.MaxSize
+	}
+	normal := c.AvgSize
+	if normal > end {
+		normal = end
+	}
+
+	// the top bits of the hash depend on the last 64 bytes
+	avgBits := bits.Len(uint(c.AvgSize)) - 1
+	strictMask := ^uint64(0) << (64 - (avgBits + 1))
+	looseMask := ^uint64(0) << (64 - (avgBits - 1))
+
+	var hash uint64
+	i := c.MinSize
+	for ; i < normal; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&strictMask == 0 {
+			return i + 1
+		}
+	}
+	for ; i < end; i++ {
+		hash = (hash << 1) + gearTable[data[i]]
+		if hash&looseMask == 0 {
+			return i + 1
+		}
+	}
+	return end
+}
+
+func (c FastCDCChunker) MaxChunkSize() int {
+	return c.MaxSize
+}
+
+func (c FastCDCChunker) Spec() string {
+	return fmt.Sprintf("%s:%d:%d:%d", FASTCDC_CHUNKER, c.MinSize, c.AvgSize, c.MaxSize)
+}
+
+// ReadChunks splits what r holds as chunker would, visiting the chunks in
+// order while holding at most twice MaxChunkSize bytes. A chunk is only valid
+// until visit returns.
+func ReadChunks(r io.Reader, chunker Chunker, visit func(chunk []byte) error) error {
+	maxSize := chunker.MaxChunkSize()
+	buf := make([]byte, 0, 2*maxSize)
+	eof := false
+	for {
+		for !eof && len(buf) < maxSize {
+			n, err := r.Read(buf[len(buf):cap(buf)])
+			buf = buf[:len(buf)+n]
+			if err == io.EOF {
+				eof = true
+			} else if err != nil {
+				return err
+			}
+		}
+		if len(buf) == 0 {
+			return nil
+		}
+
+		window := buf
+		if len(window) > maxSize {
+			window = window[:maxSize]
+		}
+		cut := chunker.Cut(window)
+		if err := visit(buf[:cut]); err != nil {
+			return err
+		}
+		buf = buf[:copy(buf, buf[cut:])]
+	}
+}
+
+// ParseChunker builds the chunker for a spec. "" is fixed BlockSize chunks,
+// "fastcdc" averages BlockSize rounded down to a power of two with chunks from
+// a quarter to four times that, and "fastcdc:min:avg:max" sets the sizes,
+// where avg is a power of two.
+func ParseChunker(spec string, blockSize int) (Chunker, error) {
+	if spec == "" || spec == "fixed" {
+		if blockSize < 1 {
+			return nil, fmt.Errorf("invalid block size %d", blockSize)
+		}
+		return FixedChunker{BlockSize: blockSize}, nil
+	}
+
+	parts := strings.Split(spec, ":")
+	if parts[0] != FASTCDC_CHUNKER || (len(parts) != 1 && len(parts) != 4) {
+		return nil, fmt.Errorf("unknown chunker %q", spec)
+	}
+	avgSize := 0
+	if blockSize > 0 {
+		avgSize = 1 << (bits.Len(uint(blockSize)) - 1)
+	}
+	chunker := FastCDCChunker{MinSize: avgSize / 4, AvgSize: avgSize, MaxSize: avgSize * 4}
+	if len(parts) == 4 {
+		sizes := make([]int, 3)
+		for i, part := range parts[1:] {
+			size, err := strconv.Atoi(part)
+			if err != nil {
+				return nil, fmt.Errorf("invalid chunk size %q in %q", part, spec)
+			}
+			sizes[i] = size
+		}
+		chunker = FastCDCChunker{MinSize: sizes[0], AvgSize: sizes[1], MaxSize: sizes[2]}
+	}
+
+	if chunker.MinSize < 1 || chunker.AvgSize < 4 || chunker.AvgSize&(chunker.AvgSize-1) != 0 ||
+		chunker.MinSize > chunker.AvgSize || chunker.AvgSize > chunker.MaxSize {
+		return nil, fmt.Errorf("invalid chunk sizes in %q, need 1 <= min <= avg <= max with avg a power of two of at least 4", spec)
+	}
+	return chunker, nil
+}
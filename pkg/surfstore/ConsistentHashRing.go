@@ -2,69 +2,114 @@ package surfstore
 
 import (
 	"crypto/sha256"
+	"encoding/binary"
 	"encoding/hex"
 	"sort"
+	"strconv"
 )
 
+// ConsistentHashRing is immutable once built: a membership change builds a new
+// ring. The sorted token table is computed once in the constructor so lookups
+// are a binary search.
 type ConsistentHashRing struct {
-	ServerMap map[string]string
+	ServerMap map[string]string // token -> server address, do not modify
+	tokens    []string          // sorted tokens of ServerMap
+	owners    []string          // server address of each token
+	servers   map[string]BlockServerConfig
 }
 
+// GetResponsibleServer returns the server owning the first token after the block
 func (c ConsistentHashRing) GetResponsibleServer(blockId string) string {
-	//	fmt.Printf("blockId: %s\n", blockId)
-	//	blockHash := c.Hash(blockId)
-	responsibleServerHash := ""
-	serverHashes := make([]string, 0)
-
-	//	fmt.Printf("blockHash: %s\n", blockId)
-	//	fmt.Printf("c.ServerMap: %v\n", c.ServerMap)
+	if len(c.tokens) == 0 {
+		return ""
+	}
+	return c.owners[c.successor(blockId)]
+}
 
-	for k, _ := range c.ServerMap {
-		serverHashes = append(serverHashes, k)
+// LookupMany returns the responsible server of each block, in input order.
+// Sorting the blocks to sweep the token table once costs more than a binary
+// search per block, so each block is searched on its own.
+func (c ConsistentHashRing) LookupMany(blockIds []string) []string {
+	servers := make([]string, len(blockIds))
+	if len(c.tokens) == 0 {
+		return servers
 	}
-	//	fmt.Printf("serverHashes: %s\n", serverHashes)
+	for i, blockId := range blockIds {
+		servers[i] = c.owners[c.successor(blockId)]
+	}
+	return servers
+}
 
-	sort.Strings(serverHashes)
+// GetResponsibleServers returns every server in ring order, starting with the
+// one responsible for the block
+func (c ConsistentHashRing) GetResponsibleServers(blockId string) []string {
+	if len(c.tokens) == 0 {
+		return []string{}
+	}
 
-	for _, serverHash := range serverHashes {
-		if serverHash > blockId {
-			responsibleServerHash = serverHash
-			//			fmt.Printf("responsable server: %s\n", responsibleServerHash)
-			break
+	// servers with virtual nodes appear once, at their first point after the block
+	start := c.successor(blockId)
+	servers := make([]string, 0)
+	seen := make(map[string]bool)
+	for i := range c.tokens {
+		addr := c.owners[(start+i)%len(c.tokens)]
+		if !seen[addr] {
+			seen[addr] = true
+			servers = append(servers, addr)
 		}
 	}
-	if responsibleServerHash == "" && len(serverHashes) > 0 {
-		responsibleServerHash = serverHashes[0]
+	return servers
+}
+
+// GetTopServers returns the first n distinct servers along the ring from the block
+func (c ConsistentHashRing) GetTopServers(blockId string, n int) []string {
+	servers := c.GetResponsibleServers(blockId)
+	if n > 0 && n < len(servers) {
+		return servers[:n]
 	}
-	//	fmt.Printf("responsibleServerHash == %s\n", responsibleServerHash)
-	//	fmt.Printf("\n")
-
-	/*minServerHash := ""
-	minServerAddr := ""
-	serverHashDirectAfterBlock := ""
-	serverAddrDirectAfterBlock := ""
-
-	for serverHash, addr := range c.ServerMap {
-		if serverHash > blockHash {
-			if serverHashDirectAfterBlock == "" || serverHash < serverHashDirectAfterBlock {
-				// found new server hash directly after the block hash
-				serverHashDirectAfterBlock = serverHash
-				serverAddrDirectAfterBlock = addr
-			}
-		}
+	return servers
+}
 
-		if minServerHash == "" || serverHash < minServerHash {
-			minServerHash = serverHash
-			minServerAddr = addr
-		}
+// GetReplicaServers returns n servers for the copies of the block, following
+// the ring from the block but skipping servers in failure domains already used
+func (c ConsistentHashRing) GetReplicaServers(blockId string, n int) []string {
+	return spreadOverFailureDomains(c.GetResponsibleServers(blockId), c.servers, n)
+}
+
+// GetOwnershipShares returns the fraction of the hash space each server is
+// responsible for, which is the share of blocks it should expect to hold
+func (c ConsistentHashRing) GetOwnershipShares() map[string]float64 {
+	shares := make(map[string]float64)
+	if len(c.tokens) == 1 {
+		shares[c.owners[0]] = 1
+		return shares
 	}
 
-	if serverAddrDirectAfterBlock == "" {
-		responsibleServerAddr = minServerAddr
-	} else {
-		responsibleServerAddr = serverAddrDirectAfterBlock
-	}*/
-	return c.ServerMap[responsibleServerHash]
+	// a point owns the arc back to the previous point. The top 64 bits of the
+	// hashes are precise enough, and unsigned subtraction wraps around the ring.
+	for i, token := range c.tokens {
+		prevToken := c.tokens[(i+len(c.tokens)-1)%len(c.tokens)]
+		arc := hashPrefix(token) - hashPrefix(prevToken)
+		shares[c.owners[i]] += float64(arc) / (1 << 64)
+	}
+	return shares
+}
+
+// Index of the first token strictly greater than the block, wrapping to 0
+func (c ConsistentHashRing) successor(blockId string) int {
+	idx := sort.Search(len(c.tokens), func(i int) bool { return c.tokens[i] > blockId })
+	if idx == len(c.tokens) {
+		return 0
+	}
+	return idx
+}
+
+func hashPrefix(hash string) uint64 {
+	prefix, err := hex.DecodeString(hash[:16])
+	if err != nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(prefix)
 }
 
 func (c ConsistentHashRing) Hash(addr string) string {
@@ -73,11 +118,50 @@ func (c ConsistentHashRing) Hash(addr string) string {
 	return hex.EncodeToString(h.Sum(nil))
 }
 
+// This line guarantees all method for ConsistentHashRing are implemented
+var _ PlacementStrategy = new(ConsistentHashRing)
+
 func NewConsistentHashRing(serverAddrs []string) *ConsistentHashRing {
-	var consistentHashRing = ConsistentHashRing{ServerMap: make(map[string]string)}
+	servers := make([]BlockServerConfig, 0, len(serverAddrs))
 	for _, serverAddr := range serverAddrs {
-		serverHash := consistentHashRing.Hash("blockstore" + serverAddr)
-		consistentHashRing.ServerMap[serverHash] = serverAddr
+		servers = append(servers, BlockServerConfig{Addr: serverAddr, Weight: 1})
+	}
+	return NewWeightedConsistentHashRing(servers, 1)
+}
+
+// NewWeightedConsistentHashRing places virtualNodes points on the ring for
+// every unit of a server's weight, so each server owns a share of the blocks
+// proportional to its weight. A server's first point is where an unweighted
+// ring places it, so going from one to more virtual nodes only moves blocks
+// onto the new points.
+func NewWeightedConsistentHashRing(servers []BlockServerConfig, virtualNodes int) *ConsistentHashRing {
+	if virtualNodes < 1 {
+		virtualNodes = 1
+	}
+
+	var consistentHashRing = ConsistentHashRing{ServerMap: make(map[string]string), servers: getServerLabels(servers)}
+	for _, server := range servers {
+		weight := server.Weight
+		if weight < 1 {
+			weight = 1
+		}
+		for i := 0; i < weight*virtualNodes; i++ {
+			serverHash := consistentHashRing.Hash("blockstore" + server.Addr)
+			if i > 0 {
+				serverHash = consistentHashRing.Hash("blockstore" + server.Addr + "#" + strconv.Itoa(i))
+			}
+			consistentHashRing.ServerMap[serverHash] = server.Addr
+		}
+	}
+
+	consistentHashRing.tokens = make([]string, 0, len(consistentHashRing.ServerMap))
+	for token := range consistentHashRing.ServerMap {
+		consistentHashRing.tokens = append(consistentHashRing.tokens, token)
+	}
+	sort.Strings(consistentHashRing.tokens)
+	consistentHashRing.owners = make([]string, len(consistentHashRing.tokens))
+	for i, token := range consistentHashRing.tokens {
+		consistentHashRing.owners[i] = consistentHashRing.ServerMap[token]
 	}
 	return &consistentHashRing
 }
@@ -0,0 +1,91 @@
+package surfstore
+
+// Two BlockStores share a failure domain if they are in the same zone, or in
+// the same rack of the same (or no) zone. A BlockStore without labels shares
+// no domain with any other.
+
+func zoneKey(server BlockServerConfig) string {
+	if server.Zone == "" {
+		return "addr:" + server.Addr
+	}
+	return server.Zone
+}
+
+func rackKey(server BlockServerConfig) string {
+	if server.Rack == "" {
+		return "addr:" + server.Addr
+	}
+	return server.Zone + "/" + server.Rack
+}
+
+// ShareFailureDomain checks whether two BlockStores can fail together
+func ShareFailureDomain(a BlockServerConfig, b BlockServerConfig) bool {
+	return zoneKey(a) == zoneKey(b) || rackKey(a) == rackKey(b)
+}
+
+func getServerLabels(servers []BlockServerConfig) map[string]BlockServerConfig {
+	labels := make(map[string]BlockServerConfig, len(servers))
+	for _, server := range servers {
+		labels[server.Addr] = server
+	}
+	return labels
+}
+
+// Pick n of the candidates, which are in order of preference, for the copies
+// of a block. Candidates in a zone and rack not used yet come first, then
+// those in an unused rack, and only then any that are left, so copies share a
+// failure domain only if there are not enough domains.
+func spreadOverFailureDomains(candidates []string, servers map[string]BlockServerConfig, n int) []string {
+	if n < 1 || n > len(candidates) {
+		n = len(candidates)
+	}
+
+	chosen := make([]string, 0, n)
+	picked := make(map[string]bool)
+	usedZones := make(map[string]bool)
+	usedRacks := make(map[string]bool)
+	pick := func(addr string) {
+		server := servers[addr]
+		server.Addr = addr
+		chosen = append(chosen, addr)
+		picked[addr] = true
+		usedZones[zoneKey(server)] = true
+		usedRacks[rackKey(server)] = true
+	}
+
+	passes := []func(server BlockServerConfig) bool{
+		func(server BlockServerConfig) bool { return !usedZones[zoneKey(server)] && !usedRacks[rackKey(server)] },
+		func(server BlockServerConfig) bool { return !usedRacks[rackKey(server)] },
+		func(server BlockServerConfig) bool { return true },
+	}
+	for _, allowed := range passes {
+		for _, addr := range candidates {
+			if len(chosen) == n {
+				return chosen
+			}
+			server := servers[addr]
+			server.Addr = addr
+			if !picked[addr] && allowed(server) {
+				pick(addr)
+			}
+		}
+	}
+	return chosen
+}
+
+// FindSharedFailureDomains returns the pairs of holders of a block that share a
+// failure domain. Holders missing from servers have no labels.
+func FindSharedFailureDomains(holders []string, servers []BlockServerConfig) [][2]string {
+	labels := getServerLabels(servers)
+	shared := make([][2]string, 0)
+	for i := 0; i < len(holders); i++ {
+		for j := i + 1; j < len(holders); j++ {
+			a, b := labels[holders[i]], labels[holders[j]]
+			a.Addr, b.Addr = holders[i], holders[j]
+			if ShareFailureDomain(a, b) {
+				shared = append(shared, [2]string{holders[i], holders[j]})
+			}
+		}
+	}
+	return shared
+}
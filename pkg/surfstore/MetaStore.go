@@ -8,9 +8,14 @@ import (
 )
 
 type MetaStore struct {
-	FileMetaMap        map[string]*FileMetaData
-	BlockStoreAddrs    []string
-	ConsistentHashRing *ConsistentHashRing
+	FileMetaMap     map[string]*FileMetaData
+	BlockStoreAddrs []string
+	Placement       PlacementStrategy
+	Membership      *BlockMembership // BlockStoreAddrs with their weights
+
+	// while BlockStores join or leave, the membership blocks are migrating to
+	NextMembership *BlockMembership
+	NextPlacement  PlacementStrategy
 	UnimplementedMetaStoreServer
 }
 
@@ -19,7 +24,12 @@ func (m *MetaStore) GetFileInfoMap(ctx context.Context, _ *emptypb.Empty) (*File
 		m.FileMetaMap = make(map[string]*FileMetaData)
 	}
 
-	var fileInfoMap = FileInfoMap{FileInfoMap: m.FileMetaMap}
+	// a copy, so the map can be sent after the caller's lock is released while
+	// later updates change the live one
+	var fileInfoMap = FileInfoMap{FileInfoMap: make(map[string]*FileMetaData, len(m.FileMetaMap))}
+	for filename, fileMetaData := range m.FileMetaMap {
+		fileInfoMap.FileInfoMap[filename] = fileMetaData
+	}
 	return &fileInfoMap, ctx.Err()
 }
 
@@ -44,8 +54,7 @@ func (m *MetaStore) UpdateFile(ctx context.Context, fileMetaData *FileMetaData)
 
 func (m *MetaStore) GetBlockStoreMap(ctx context.Context, blockHashesIn *BlockHashes) (*BlockStoreMap, error) {
 	var blockStoreMap = BlockStoreMap{BlockStoreMap: make(map[string]*BlockHashes)}
-	for _, blockHash := range blockHashesIn.Hashes {
-		responsibleServer := m.ConsistentHashRing.GetResponsibleServer(blockHash)
+	addHash := func(responsibleServer string, blockHash string) {
 		responsibleServer = strings.Replace(responsibleServer, "blockstore", "", -1)
 
 		blockHashesForServer := blockStoreMap.BlockStoreMap[responsibleServer]
@@ -57,20 +66,120 @@ func (m *MetaStore) GetBlockStoreMap(ctx context.Context, blockHashesIn *BlockHa
 		blockStoreMap.BlockStoreMap[responsibleServer] = blockHashesForServer
 	}
 
+	// with replication every copy is listed, so clients put the block on each
+	if replicationFactor := m.GetReplicationFactor(); replicationFactor > 1 {
+		for _, blockHash := range blockHashesIn.Hashes {
+			for _, replicaServer := range m.GetPlacement().GetReplicaServers(blockHash, replicationFactor) {
+				addHash(replicaServer, blockHash)
+			}
+		}
+		return &blockStoreMap, ctx.Err()
+	}
+
+	responsibleServers := m.GetPlacement().LookupMany(blockHashesIn.Hashes)
+	for idx, blockHash := range blockHashesIn.Hashes {
+		addHash(responsibleServers[idx], blockHash)
+	}
+
 	return &blockStoreMap, ctx.Err()
 }
 
 func (m *MetaStore) GetBlockStoreAddrs(ctx context.Context, _ *emptypb.Empty) (*BlockStoreAddrs, error) {
-	return &BlockStoreAddrs{BlockStoreAddrs: m.BlockStoreAddrs}, ctx.Err()
+	return &BlockStoreAddrs{BlockStoreAddrs: m.GetAllBlockStoreAddrs()}, ctx.Err()
+}
+
+// Apply a committed membership change. A migrating membership becomes where
+// new blocks are placed, a settled one replaces the current BlockStores.
+func (m *MetaStore) ApplyBlockMembership(membership *BlockMembership) {
+	placement := NewPlacementStrategy(membership.Placement, getMembershipServers(membership), int(membership.VirtualNodes))
+	if membership.Migrating {
+		m.NextMembership = membership
+		m.NextPlacement = placement
+		return
+	}
+
+	m.BlockStoreAddrs = make([]string, 0, len(membership.Servers))
+	for _, server := range membership.Servers {
+		m.BlockStoreAddrs = append(m.BlockStoreAddrs, server.Addr)
+	}
+	m.Placement = placement
+	m.Membership = membership
+	m.NextMembership = nil
+	m.NextPlacement = nil
+}
+
+// The strategy new blocks are placed by, the target one during a migration
+func (m *MetaStore) GetPlacement() PlacementStrategy {
+	if m.NextPlacement != nil {
+		return m.NextPlacement
+	}
+	return m.Placement
+}
+
+// The number of copies kept of each block, the target one during a migration
+func (m *MetaStore) GetReplicationFactor() int {
+	membership := m.Membership
+	if m.NextMembership != nil {
+		membership = m.NextMembership
+	}
+	if membership == nil || membership.ReplicationFactor < 1 {
+		return 1
+	}
+	return int(membership.ReplicationFactor)
+}
+
+// Every BlockStore that may hold blocks, including those joining or leaving
+func (m *MetaStore) GetAllBlockStoreAddrs() []string {
+	if m.NextMembership == nil {
+		return m.BlockStoreAddrs
+	}
+
+	addrs := append([]string{}, m.BlockStoreAddrs...)
+	for _, server := range m.NextMembership.Servers {
+		if !hashInHashList(addrs, server.Addr) {
+			addrs = append(addrs, server.Addr)
+		}
+	}
+	return addrs
+}
+
+// Return the set of block hashes referenced by any file in the FileMetaMap or
+// in the retained history, i.e. the blocks garbage collection must keep
+func (m *MetaStore) GetLiveBlockHashes(history []*FileMetaData) map[string]bool {
+	liveHashes := make(map[string]bool)
+	addHashes := func(fileMetaData *FileMetaData) {
+		if fileMetaData == nil {
+			return
+		}
+		for _, hash := range fileMetaData.BlockHashList {
+			if hash != TOMBSTONE_HASHVALUE && hash != EMPTYFILE_HASHVALUE && hash != DIRECTORY_HASHVALUE {
+				liveHashes[hash] = true
+			}
+		}
+	}
+
+	for _, fileMetaData := range m.FileMetaMap {
+		addHashes(fileMetaData)
+	}
+	for _, fileMetaData := range history {
+		addHashes(fileMetaData)
+	}
+	return liveHashes
 }
 
 // This line guarantees all method for MetaStore are implemented
 var _ MetaStoreInterface = new(MetaStore)
 
-func NewMetaStore(blockStoreAddrs []string, consistentHashRing *ConsistentHashRing) *MetaStore {
+func NewMetaStore(blockStoreAddrs []string, placement PlacementStrategy) *MetaStore {
+	membership := BlockMembership{Servers: make([]*BlockServer, 0, len(blockStoreAddrs)), VirtualNodes: 1}
+	for _, addr := range blockStoreAddrs {
+		membership.Servers = append(membership.Servers, &BlockServer{Addr: addr, Weight: 1})
+	}
+
 	return &MetaStore{
-		FileMetaMap:        map[string]*FileMetaData{},
-		BlockStoreAddrs:    blockStoreAddrs,
-		ConsistentHashRing: consistentHashRing,
+		FileMetaMap:     map[string]*FileMetaData{},
+		BlockStoreAddrs: blockStoreAddrs,
+		Placement:       placement,
+		Membership:      &membership,
 	}
 }
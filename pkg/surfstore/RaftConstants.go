@@ -2,7 +2,28 @@ package surfstore
 
 import (
 	"fmt"
+	"time"
 )
 
 var ERR_SERVER_CRASHED = fmt.Errorf("Server is crashed.")
 var ERR_NOT_LEADER = fmt.Errorf("Server is not the leader")
+
+// Blocks put more recently than this are never garbage collected, so uploads
+// whose UpdateFile has not been committed yet survive a concurrent sweep
+const DEFAULT_GC_GRACE_PERIOD time.Duration = 10 * time.Minute
+
+// How often the leader refreshes the space stats of the BlockStores
+const DEFAULT_STATS_INTERVAL time.Duration = 5 * time.Second
+
+// BlockStores with more of their capacity used than this get no new blocks
+const NEAR_CAPACITY_FRACTION float64 = 0.9
+
+// How often the leader resumes an interrupted block migration
+const DEFAULT_MIGRATION_INTERVAL time.Duration = 30 * time.Second
+
+// How long WaitForChanges waits before returning an unchanged cursor
+const DEFAULT_WATCH_TIMEOUT time.Duration = 30 * time.Second
+
+// Placement strategies a RaftConfig or membership can select
+const PLACEMENT_RING string = "ring"
+const PLACEMENT_RENDEZVOUS string = "rendezvous"
@@ -17,8 +17,28 @@ type RaftTestingInterface interface {
 	Restore(ctx context.Context, _ *emptypb.Empty) (*Success, error)
 }
 
+type RaftGarbageCollectionInterface interface {
+	// Delete blocks no longer referenced by any current or logged file from the BlockStores
+	CollectGarbage(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error)
+}
+
+type RaftMembershipInterface interface {
+	// Move to a new set of BlockStores, migrating blocks to their new owners
+	ChangeBlockMembership(ctx context.Context, membership *BlockMembership) (*Success, error)
+	// Get the BlockStores, or the ones being migrated to
+	GetBlockMembership(ctx context.Context, _ *emptypb.Empty) (*BlockMembership, error)
+}
+
+type RaftWatchInterface interface {
+	// Wait until the leader applies a change past the cursor, or a timeout
+	WaitForChanges(ctx context.Context, cursor *ChangeCursor) (*ChangeCursor, error)
+}
+
 type RaftSurfstoreInterface interface {
 	MetaStoreInterface
 	RaftInterface
 	RaftTestingInterface
+	RaftGarbageCollectionInterface
+	RaftMembershipInterface
+	RaftWatchInterface
 }
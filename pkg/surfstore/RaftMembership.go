@@ -0,0 +1,345 @@
+package surfstore
+
+import (
+	context "context"
+	"fmt"
+	"io"
+	"log"
+	"time"
+
+	grpc "google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Adding or removing BlockStores happens in two log entries. The first commits
+// the new membership as migrating: new blocks are placed by its strategy at once,
+// clients are given every old and new BlockStore so reads fall back to the old
+// owner of a block that has not been copied yet, and the leader copies every
+// block whose owner changes in the background. Once all blocks are copied the
+// second entry settles the membership, which cuts placement over and drops the
+// BlockStores that left.
+
+// Start moving to a new set of BlockStores
+func (s *RaftSurfstore) ChangeBlockMembership(ctx context.Context, membership *BlockMembership) (*Success, error) {
+	if s.isLeader {
+		if !s.isCrashed {
+			s.stateMutex.Lock()
+			defer s.stateMutex.Unlock()
+			succ, err := s.sendHeartbeat(ctx)
+			checkError(err)
+			if !succ.Flag {
+				fmt.Printf("SendHeartbeat failed\n")
+				return nil, ERR_SERVER_CRASHED
+			}
+
+			if len(membership.Servers) == 0 {
+				return nil, fmt.Errorf("a membership needs at least one BlockStore")
+			}
+			if !IsPlacementStrategy(membership.Placement) {
+				return nil, fmt.Errorf("unknown placement %q", membership.Placement)
+			}
+			if s.metaStore.NextMembership != nil {
+				return nil, fmt.Errorf("a membership change is already in progress")
+			}
+
+			target := &BlockMembership{Servers: membership.Servers, VirtualNodes: membership.VirtualNodes, Placement: membership.Placement, Migrating: true}
+			target.ReplicationFactor = membership.ReplicationFactor
+			if err := s.commitEntry(&UpdateOperation{Term: s.term, Membership: target}); err != nil {
+				return nil, err
+			}
+
+			go func() {
+				if err := s.MigrateBlocks(); err != nil {
+					log.Printf("Migration: %s\n", err.Error())
+				}
+			}()
+			return &Success{Flag: true}, ctx.Err()
+		} else { // leader is crashed
+			return nil, ERR_SERVER_CRASHED
+		}
+	}
+	return nil, ERR_NOT_LEADER
+}
+
+// Return the membership being migrated to, or the current one if there is no migration
+func (s *RaftSurfstore) GetBlockMembership(ctx context.Context, empty *emptypb.Empty) (*BlockMembership, error) {
+	if s.isLeader {
+		if !s.isCrashed {
+			s.stateMutex.RLock()
+			defer s.stateMutex.RUnlock()
+			succ, err := s.sendHeartbeat(ctx)
+			checkError(err)
+			if !succ.Flag {
+				fmt.Printf("SendHeartbeat failed\n")
+				return nil, ERR_SERVER_CRASHED
+			}
+
+			if s.metaStore.NextMembership != nil {
+				return s.metaStore.NextMembership, ctx.Err()
+			}
+			return s.metaStore.Membership, ctx.Err()
+		} else { // leader is crashed
+			return nil, ERR_SERVER_CRASHED
+		}
+	}
+	return nil, ERR_NOT_LEADER
+}
+
+// MigrateBlocks copies every block to each of its new replicas that lacks it,
+// then commits the settled membership and deletes the copied blocks from the
+// BlockStores that stay but are no longer among their replicas. A failed migration is resumed by calling it again.
+func (s *RaftSurfstore) MigrateBlocks() error {
+	s.migrationMutex.Lock()
+	defer s.migrationMutex.Unlock()
+
+	if !s.isLeader || s.isCrashed {
+		return ERR_NOT_LEADER
+	}
+	// the membership does not change until this migration settles it, but
+	// clients update files meanwhile
+	s.stateMutex.RLock()
+	target := s.metaStore.NextMembership
+	placement := s.metaStore.NextPlacement
+	replicationFactor := s.metaStore.GetReplicationFactor()
+	blockStoreAddrs := s.metaStore.GetAllBlockStoreAddrs()
+	s.stateMutex.RUnlock()
+	if target == nil {
+		return nil
+	}
+
+	// blocks put by clients that looked up the old placement just before the change
+	// can land after a pass, so copy until a pass finds nothing left to copy
+	moved := make(map[string]map[string]bool) // source BlockStore -> hashes moved off it
+	for {
+		copiedInPass := 0
+		for _, sourceAddr := range blockStoreAddrs {
+			storedHashes, err := getBlockHashes(sourceAddr)
+			if err != nil {
+				return fmt.Errorf("could not list blocks on %s: %v", sourceAddr, err)
+			}
+
+			if moved[sourceAddr] == nil {
+				moved[sourceAddr] = make(map[string]bool)
+			}
+			byOwner := make(map[string][]string)
+			for idx, replicaServers := range getReplicaServers(placement, storedHashes, replicationFactor) {
+				if !hashInHashList(replicaServers, sourceAddr) {
+					moved[sourceAddr][storedHashes[idx]] = true
+				}
+				for _, owner := range replicaServers {
+					if owner != sourceAddr {
+						byOwner[owner] = append(byOwner[owner], storedHashes[idx])
+					}
+				}
+			}
+			for ownerAddr, hashes := range byOwner {
+				copiedHashes, err := copyBlocks(sourceAddr, ownerAddr, hashes)
+				if err != nil {
+					return fmt.Errorf("could not copy blocks from %s to %s: %v", sourceAddr, ownerAddr, err)
+				}
+				copiedInPass += len(copiedHashes)
+			}
+		}
+		log.Printf("Migration: copied %d blocks\n", copiedInPass)
+		if copiedInPass == 0 {
+			break
+		}
+	}
+
+	settled := &BlockMembership{Servers: target.Servers, VirtualNodes: target.VirtualNodes, Placement: target.Placement, Migrating: false}
+	settled.ReplicationFactor = target.ReplicationFactor
+	s.stateMutex.Lock()
+	err := s.commitEntry(&UpdateOperation{Term: s.term, Membership: settled})
+	remainingAddrs := s.metaStore.BlockStoreAddrs
+	s.stateMutex.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// BlockStores that left are no longer read from, the others give up the moved blocks
+	for sourceAddr, movedHashes := range moved {
+		if len(movedHashes) == 0 || !hashInHashList(remainingAddrs, sourceAddr) {
+			continue
+		}
+		hashes := make([]string, 0, len(movedHashes))
+		for hash := range movedHashes {
+			hashes = append(hashes, hash)
+		}
+		if err := deleteBlocks(sourceAddr, hashes); err != nil {
+			log.Printf("Migration: could not delete moved blocks from %s: %s\n", sourceAddr, err.Error())
+		}
+	}
+	return nil
+}
+
+// StartMigrator resumes an interrupted migration every interval while this server is the leader
+func (s *RaftSurfstore) StartMigrator(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.stateMutex.RLock()
+			migrating := s.metaStore.NextMembership != nil
+			s.stateMutex.RUnlock()
+			if !s.isLeader || s.isCrashed || !migrating {
+				continue
+			}
+			if err := s.MigrateBlocks(); err != nil {
+				log.Printf("Migration: %s\n", err.Error())
+			}
+		}
+	}()
+}
+
+// Append an entry to the log, replicate it to a majority and apply it.
+// Callers must hold stateMutex.
+func (s *RaftSurfstore) commitEntry(entry *UpdateOperation) error {
+	s.log = append(s.log, entry)
+	s.commitIndex = int64(len(s.log) - 1)
+
+	if err := s.replicateLog(); err != nil {
+		return err
+	}
+	s.applyEntry(entry)
+	s.lastApplied = int64(len(s.log) - 1)
+	return nil
+}
+
+// Apply a committed log entry to the state machine
+func (s *RaftSurfstore) applyEntry(entry *UpdateOperation) {
+	if entry.Membership != nil {
+		s.metaStore.ApplyBlockMembership(entry.Membership)
+		s.blockAddrs = s.metaStore.BlockStoreAddrs
+		return
+	}
+	s.metaStore.FileMetaMap[entry.FileMetaData.Filename] = entry.FileMetaData
+	s.notifyChanges()
+}
+
+// The replicas of each block, in input order. A single copy is a plain lookup.
+func getReplicaServers(placement PlacementStrategy, hashes []string, replicationFactor int) [][]string {
+	replicaServers := make([][]string, len(hashes))
+	if replicationFactor <= 1 {
+		for idx, owner := range placement.LookupMany(hashes) {
+			replicaServers[idx] = []string{owner}
+		}
+		return replicaServers
+	}
+	for idx, hash := range hashes {
+		replicaServers[idx] = placement.GetReplicaServers(hash, replicationFactor)
+	}
+	return replicaServers
+}
+
+func getMembershipServers(membership *BlockMembership) []BlockServerConfig {
+	servers := make([]BlockServerConfig, 0, len(membership.Servers))
+	for _, server := range membership.Servers {
+		servers = append(servers, BlockServerConfig{Addr: server.Addr, Weight: int(server.Weight), Zone: server.Zone, Rack: server.Rack})
+	}
+	return servers
+}
+
+func getBlockHashes(blockStoreAddr string) ([]string, error) {
+	conn, err := grpc.Dial(blockStoreAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	storedHashes, err := c.GetBlockHashes(ctx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+	return storedHashes.Hashes, nil
+}
+
+// Stream the blocks the destination does not have yet from the source to it,
+// returning the hashes that were copied
+func copyBlocks(sourceAddr string, destAddr string, hashes []string) ([]string, error) {
+	sourceConn, err := grpc.Dial(sourceAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer sourceConn.Close()
+	destConn, err := grpc.Dial(destAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer destConn.Close()
+	source := NewBlockStoreClient(sourceConn)
+	dest := NewBlockStoreClient(destConn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	present, err := dest.HasBlocks(ctx, &BlockHashes{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+	skip := make(map[string]bool)
+	for _, hash := range present.Hashes {
+		skip[hash] = true
+	}
+	missing := make([]string, 0)
+	for _, hash := range hashes {
+		if !skip[hash] {
+			missing = append(missing, hash)
+			skip[hash] = true
+		}
+	}
+	if len(missing) == 0 {
+		return missing, nil
+	}
+
+	getStream, err := source.GetBlocks(ctx, &BlockHashes{Hashes: missing})
+	if err != nil {
+		return nil, err
+	}
+	putStream, err := dest.PutBlocks(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := make([]string, 0, len(missing))
+	for _, hash := range missing {
+		block, err := getStream.Recv()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		if len(block.BlockData) == 0 { // deleted or quarantined since it was listed
+			continue
+		}
+		if err := putStream.Send(block); err != nil {
+			return nil, err
+		}
+		copied = append(copied, hash)
+	}
+
+	succ, err := putStream.CloseAndRecv()
+	if err != nil {
+		return nil, err
+	}
+	if !succ.Flag {
+		return nil, fmt.Errorf("%s did not store the blocks", destAddr)
+	}
+	return copied, nil
+}
+
+func deleteBlocks(blockStoreAddr string, hashes []string) error {
+	conn, err := grpc.Dial(blockStoreAddr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	_, err = c.DeleteBlocks(ctx, &BlockDeletion{Hashes: hashes, GracePeriodSeconds: 0})
+	return err
+}
@@ -3,6 +3,7 @@ package surfstore
 import (
 	context "context"
 	"fmt"
+	"log"
 	"math"
 	"strings"
 	"sync"
@@ -29,6 +30,21 @@ type RaftSurfstore struct {
 	nextIndex   []int64
 	matchIndex  []int64
 
+	gcGracePeriod time.Duration
+
+	blockStoreStats      map[string]*BlockStoreStats // last known stats of each BlockStore
+	blockStoreStatsMutex *sync.RWMutex
+
+	migrationMutex *sync.Mutex // held while blocks are copied between BlockStores
+
+	// guards the log and the metadata. Handlers that append to the log hold it
+	// until the entry is replicated and applied, so entries go in one at a time.
+	stateMutex *sync.RWMutex
+
+	changeIndex int64         // changes applied, the cursor of WaitForChanges
+	changed     chan struct{} // closed and replaced when a change is applied
+	changeMutex *sync.Mutex
+
 	/*--------------- Chaos Monkey --------------*/
 	isCrashed      bool
 	isCrashedMutex *sync.RWMutex
@@ -38,7 +54,9 @@ type RaftSurfstore struct {
 func (s *RaftSurfstore) GetFileInfoMap(ctx context.Context, empty *emptypb.Empty) (*FileInfoMap, error) {
 	if s.isLeader {
 		if !s.isCrashed {
-			succ, err := s.SendHeartbeat(ctx, empty)
+			s.stateMutex.RLock()
+			defer s.stateMutex.RUnlock()
+			succ, err := s.sendHeartbeat(ctx)
 			checkError(err)
 			if !succ.Flag {
 				fmt.Printf("SendHeartbeat failed\n")
@@ -62,7 +80,6 @@ func (s *RaftSurfstore) GetFileInfoMap(ctx context.Context, empty *emptypb.Empty
 func (s *RaftSurfstore) GetBlockStoreMap(ctx context.Context, hashes *BlockHashes) (*BlockStoreMap, error) {
 	if s.isLeader {
 		if !s.isCrashed {
-			var empty *emptypb.Empty
 			/*for {
 				succ, err := s.SendHeartbeat(ctx, empty)
 				checkError(err)
@@ -71,26 +88,16 @@ func (s *RaftSurfstore) GetBlockStoreMap(ctx context.Context, hashes *BlockHashe
 				}
 			}*/
 
-			succ, err := s.SendHeartbeat(ctx, empty)
+			s.stateMutex.RLock()
+			defer s.stateMutex.RUnlock()
+			succ, err := s.sendHeartbeat(ctx)
 			checkError(err)
 			if !succ.Flag {
 				fmt.Printf("SendHeartbeat failed\n")
 				return nil, ERR_SERVER_CRASHED
 			}
 
-			var blockStoreMap = BlockStoreMap{BlockStoreMap: make(map[string]*BlockHashes)}
-			for _, blockHash := range hashes.Hashes {
-				responsibleServer := s.metaStore.ConsistentHashRing.GetResponsibleServer(blockHash)
-				responsibleServer = strings.Replace(responsibleServer, "blockstore", "", -1)
-
-				blockHashesForServer := blockStoreMap.BlockStoreMap[responsibleServer]
-				if blockHashesForServer == nil {
-					var temp = BlockHashes{Hashes: make([]string, 0)}
-					blockHashesForServer = &temp
-				}
-				blockHashesForServer.Hashes = append(blockHashesForServer.Hashes, blockHash)
-				blockStoreMap.BlockStoreMap[responsibleServer] = blockHashesForServer
-			}
+			var blockStoreMap = BlockStoreMap{BlockStoreMap: s.placeBlocks(hashes.Hashes)}
 			return &blockStoreMap, ctx.Err()
 		} else { // leader is crashed
 			return nil, ERR_SERVER_CRASHED
@@ -102,14 +109,16 @@ func (s *RaftSurfstore) GetBlockStoreMap(ctx context.Context, hashes *BlockHashe
 func (s *RaftSurfstore) GetBlockStoreAddrs(ctx context.Context, empty *emptypb.Empty) (*BlockStoreAddrs, error) {
 	if s.isLeader {
 		if !s.isCrashed {
-			succ, err := s.SendHeartbeat(ctx, empty)
+			s.stateMutex.RLock()
+			defer s.stateMutex.RUnlock()
+			succ, err := s.sendHeartbeat(ctx)
 			checkError(err)
 			if !succ.Flag {
 				fmt.Printf("SendHeartbeat failed\n")
 				return nil, ERR_SERVER_CRASHED
 			}
 
-			var blockStoreAddrs = BlockStoreAddrs{BlockStoreAddrs: s.metaStore.BlockStoreAddrs}
+			var blockStoreAddrs = BlockStoreAddrs{BlockStoreAddrs: s.metaStore.GetAllBlockStoreAddrs()}
 			return &blockStoreAddrs, ctx.Err()
 		} else { // leader is crashed
 			return nil, ERR_SERVER_CRASHED
@@ -118,6 +127,253 @@ func (s *RaftSurfstore) GetBlockStoreAddrs(ctx context.Context, empty *emptypb.E
 	return nil, ERR_NOT_LEADER
 }
 
+// Mark every block referenced by the FileMetaMap or the log as live and sweep
+// all other blocks from the BlockStores, leaving recently put ones alone
+func (s *RaftSurfstore) CollectGarbage(ctx context.Context, empty *emptypb.Empty) (*BlockHashes, error) {
+	if s.isLeader {
+		if !s.isCrashed {
+			// the live blocks are marked under the lock, the sweep runs without it
+			s.stateMutex.RLock()
+			succ, err := s.sendHeartbeat(ctx)
+			checkError(err)
+			if !succ.Flag {
+				s.stateMutex.RUnlock()
+				fmt.Printf("SendHeartbeat failed\n")
+				return nil, ERR_SERVER_CRASHED
+			}
+
+			history := make([]*FileMetaData, 0, len(s.log))
+			for _, entry := range s.log {
+				history = append(history, entry.FileMetaData)
+			}
+			liveHashes := s.metaStore.GetLiveBlockHashes(history)
+			blockStoreAddrs := s.metaStore.GetAllBlockStoreAddrs()
+			s.stateMutex.RUnlock()
+
+			deleted := make([]string, 0)
+			for _, blockStoreAddr := range blockStoreAddrs {
+				sweptHashes, err := sweepBlockStore(blockStoreAddr, liveHashes, s.gcGracePeriod)
+				if err != nil {
+					log.Printf("GC: could not sweep %s: %s\n", blockStoreAddr, err.Error())
+					continue
+				}
+				deleted = append(deleted, sweptHashes...)
+			}
+			return &BlockHashes{Hashes: deleted}, ctx.Err()
+		} else { // leader is crashed
+			return nil, ERR_SERVER_CRASHED
+		}
+	}
+	return nil, ERR_NOT_LEADER
+}
+
+// Delete every block on the BlockStore that is not live
+func sweepBlockStore(blockStoreAddr string, liveHashes map[string]bool, gracePeriod time.Duration) ([]string, error) {
+	conn, err := grpc.Dial(blockStoreAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	// listing and deleting take longer the more blocks are stored, and each
+	// gets its own deadline
+	listCtx, cancelList := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelList()
+	storedHashes, err := c.GetBlockHashes(listCtx, &emptypb.Empty{})
+	if err != nil {
+		return nil, err
+	}
+
+	garbage := make([]string, 0)
+	for _, hash := range storedHashes.Hashes {
+		if !liveHashes[hash] {
+			garbage = append(garbage, hash)
+		}
+	}
+	if len(garbage) == 0 {
+		return garbage, nil
+	}
+
+	deleteCtx, cancelDelete := context.WithTimeout(context.Background(), time.Minute)
+	defer cancelDelete()
+	deleted, err := c.DeleteBlocks(deleteCtx, &BlockDeletion{Hashes: garbage, GracePeriodSeconds: int64(gracePeriod.Seconds())})
+	if err != nil {
+		return nil, err
+	}
+	return deleted.Hashes, nil
+}
+
+// Set how long a block must have been stored before garbage collection may delete it
+func (s *RaftSurfstore) SetGCGracePeriod(gracePeriod time.Duration) {
+	s.gcGracePeriod = gracePeriod
+}
+
+// StartGarbageCollector runs CollectGarbage every interval while this server is the leader
+func (s *RaftSurfstore) StartGarbageCollector(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !s.isLeader || s.isCrashed {
+				continue
+			}
+			deleted, err := s.CollectGarbage(context.Background(), &emptypb.Empty{})
+			if err != nil {
+				log.Printf("GC: %s\n", err.Error())
+				continue
+			}
+			log.Printf("GC: deleted %d blocks\n", len(deleted.Hashes))
+		}
+	}()
+}
+
+// Assign each hash to the BlockStore the placement strategy makes responsible
+// for it. A BlockStore near capacity only keeps the hashes it already stores,
+// the others go to the next BlockStore in order of preference that has room.
+// With replication the other copies go to the rest of the hash's replicas.
+func (s *RaftSurfstore) placeBlocks(hashes []string) map[string]*BlockHashes {
+	placement := make(map[string]*BlockHashes)
+	placed := make(map[string]bool) // hash + addr already in placement
+	addHash := func(addr string, hash string) {
+		addr = strings.Replace(addr, "blockstore", "", -1)
+		if placed[hash+addr] {
+			return
+		}
+		placed[hash+addr] = true
+		if placement[addr] == nil {
+			placement[addr] = &BlockHashes{Hashes: make([]string, 0)}
+		}
+		placement[addr].Hashes = append(placement[addr].Hashes, hash)
+	}
+
+	onFullServer := make(map[string][]string)
+	responsibleServers := s.metaStore.GetPlacement().LookupMany(hashes)
+	for idx, hash := range hashes {
+		responsibleServer := responsibleServers[idx]
+		if s.nearCapacity(responsibleServer) {
+			onFullServer[responsibleServer] = append(onFullServer[responsibleServer], hash)
+		} else {
+			addHash(responsibleServer, hash)
+		}
+	}
+
+	for fullServer, fullHashes := range onFullServer {
+		stored, err := hasBlocks(fullServer, fullHashes)
+		for _, hash := range fullHashes {
+			// if we cannot tell, leave the hash where readers will look first
+			if err != nil || stored[hash] {
+				addHash(fullServer, hash)
+				continue
+			}
+			addHash(s.serverWithRoom(hash), hash)
+		}
+	}
+
+	if replicationFactor := s.metaStore.GetReplicationFactor(); replicationFactor > 1 {
+		for _, hash := range hashes {
+			replicaServers := s.metaStore.GetPlacement().GetReplicaServers(hash, replicationFactor)
+			for idx := 1; idx < len(replicaServers); idx++ {
+				addHash(replicaServers[idx], hash)
+			}
+		}
+	}
+	return placement
+}
+
+// The most preferred BlockStore for the hash that is not near capacity, or the
+// responsible one if all of them are
+func (s *RaftSurfstore) serverWithRoom(hash string) string {
+	servers := s.metaStore.GetPlacement().GetTopServers(hash, 0)
+	for _, addr := range servers {
+		if !s.nearCapacity(addr) {
+			return addr
+		}
+	}
+	return s.metaStore.GetPlacement().GetResponsibleServer(hash)
+}
+
+// Whether the BlockStore last reported less free space than it should keep.
+// BlockStores without a capacity or without stats yet have room.
+func (s *RaftSurfstore) nearCapacity(blockStoreAddr string) bool {
+	s.blockStoreStatsMutex.RLock()
+	stats, found := s.blockStoreStats[blockStoreAddr]
+	s.blockStoreStatsMutex.RUnlock()
+
+	if !found || stats.CapacityBytes == 0 {
+		return false
+	}
+	return float64(stats.BytesUsed) > NEAR_CAPACITY_FRACTION*float64(stats.CapacityBytes)
+}
+
+// Return which of the hashes the BlockStore stores
+func hasBlocks(blockStoreAddr string, hashes []string) (map[string]bool, error) {
+	conn, err := grpc.Dial(blockStoreAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	storedHashes, err := c.HasBlocks(ctx, &BlockHashes{Hashes: hashes})
+	if err != nil {
+		return nil, err
+	}
+
+	stored := make(map[string]bool)
+	for _, hash := range storedHashes.Hashes {
+		stored[hash] = true
+	}
+	return stored, nil
+}
+
+// Fetch the stats of every BlockStore, keeping the last known stats of those
+// that do not answer
+func (s *RaftSurfstore) RefreshBlockStoreStats() {
+	s.stateMutex.RLock()
+	blockStoreAddrs := s.metaStore.GetAllBlockStoreAddrs()
+	s.stateMutex.RUnlock()
+	for _, blockStoreAddr := range blockStoreAddrs {
+		stats, err := getBlockStoreStats(blockStoreAddr)
+		if err != nil {
+			log.Printf("Stats: could not reach %s: %s\n", blockStoreAddr, err.Error())
+			continue
+		}
+		s.blockStoreStatsMutex.Lock()
+		s.blockStoreStats[blockStoreAddr] = stats
+		s.blockStoreStatsMutex.Unlock()
+	}
+}
+
+func getBlockStoreStats(blockStoreAddr string) (*BlockStoreStats, error) {
+	conn, err := grpc.Dial(blockStoreAddr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	c := NewBlockStoreClient(conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	return c.GetStats(ctx, &emptypb.Empty{})
+}
+
+// StartStatsPoller runs RefreshBlockStoreStats every interval while this server is the leader
+func (s *RaftSurfstore) StartStatsPoller(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for range ticker.C {
+			if !s.isLeader || s.isCrashed {
+				continue
+			}
+			s.RefreshBlockStoreStats()
+		}
+	}()
+}
+
 func print_state(s *RaftSurfstore) {
 	fmt.Printf("id: %d, isLeader: %t, term: %d, log len: %d,\n raftAddrs len: %d, blockAddrs len: %d, commit index: %d, last applied idx: %d,\nnext index: %v, match index: %v\n", s.id, s.isLeader, s.term, len(s.log), len(s.raftAddrs), len(s.blockAddrs), s.commitIndex, s.lastApplied, s.nextIndex, s.matchIndex)
 	meta, exist := s.metaStore.FileMetaMap["multi_file1.txt"]
@@ -134,6 +390,8 @@ func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData)
 	if s.isLeader {
 		if !s.isCrashed {
 			fmt.Printf("%d. Recieved update meta: %v\n", s.id, filemeta)
+			s.stateMutex.Lock()
+			defer s.stateMutex.Unlock()
 
 			//fmt.Printf("%d. RaftServer UpdateFile() finished heartbeat\n", s.id)
 
@@ -153,9 +411,7 @@ func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData)
 				return &version, ctx.Err()
 			}
 
-			var empty *emptypb.Empty
-
-			succ, err := s.SendHeartbeat(ctx, empty)
+			succ, err := s.sendHeartbeat(ctx)
 			checkError(err)
 			if !succ.Flag {
 				fmt.Printf("SendHeartbeat failed\n")
@@ -167,72 +423,82 @@ func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData)
 			}
 
 			// issue 2-phase commit to followers
+			if err := s.replicateLog(); err != nil {
+				return nil, err
+			}
+
+			// log update in local log
+			s.metaStore.FileMetaMap[filemeta.Filename] = filemeta
+			version.Version = filemeta.Version
+			s.lastApplied = int64(len(s.log) - 1)
+			s.notifyChanges()
+			//s.SendHeartbeat(ctx, empty)
+			return &version, ctx.Err()
+		} else { // leader is crashed
+			return nil, ERR_SERVER_CRASHED
+		}
+	}
+	return nil, ERR_NOT_LEADER
+}
+
+// Send the log to the followers until a majority has appended it
+func (s *RaftSurfstore) replicateLog() error {
+	for {
+		appendSuccesses := 1
+		for idx, raftServerIp := range s.raftAddrs {
+			if raftServerIp == s.raftAddrs[s.id] {
+				continue
+			}
+
+			// connect to the other raft server
+			conn, err := grpc.Dial(raftServerIp, grpc.WithInsecure())
+			if err != nil {
+				return err
+			}
+			c := NewRaftSurfstoreClient(conn)
+
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			prevLogIndex := s.lastApplied
+			prevLogTerm := s.log[s.lastApplied].Term
+			//fmt.Printf("%d. RaftServer UpdateFile() start 2-phase commit to %d.\n", s.id, idx)
+
+			//print_state(s)
+
 			for {
-				appendSuccesses := 1
-				for idx, raftServerIp := range s.raftAddrs {
-					if raftServerIp == s.raftAddrs[s.id] {
-						continue
-					}
+				var appendEntryInput = AppendEntryInput{Term: s.term, PrevLogIndex: prevLogIndex, PrevLogTerm: prevLogTerm,
+					Entries: s.log, LeaderCommit: s.commitIndex}
 
-					// connect to the other raft server
-					conn, err := grpc.Dial(raftServerIp, grpc.WithInsecure())
-					if err != nil {
-						return nil, err
-					}
-					c := NewRaftSurfstoreClient(conn)
-
-					ctx, cancel := context.WithTimeout(context.Background(), time.Second)
-					defer cancel()
-
-					prevLogIndex := s.lastApplied
-					prevLogTerm := s.log[s.lastApplied].Term
-					//fmt.Printf("%d. RaftServer UpdateFile() start 2-phase commit to %d.\n", s.id, idx)
-
-					//print_state(s)
-
-					for {
-						var appendEntryInput = AppendEntryInput{Term: s.term, PrevLogIndex: prevLogIndex, PrevLogTerm: prevLogTerm,
-							Entries: s.log, LeaderCommit: s.commitIndex}
-
-						appendEntryResponse, err := c.AppendEntries(ctx, &appendEntryInput)
-						if err != nil {
-							break
-						}
-						//checkError(err)
-						if appendEntryResponse.Success {
-							appendSuccesses++
-							s.matchIndex[idx] = appendEntryResponse.MatchedIndex
-							s.nextIndex[idx] = int64(len(s.log))
-							//fmt.Printf("%d. RaftServer UpdateFile() applied appendEntry to %d successfully\n", s.id, idx)
-							break
-						} else {
-							if prevLogIndex > 0 {
-								prevLogIndex--
-								prevLogTerm = s.log[prevLogIndex].Term
-								s.nextIndex[idx] = int64(prevLogIndex)
-							} else {
-								break
-							}
-						}
+				appendEntryResponse, err := c.AppendEntries(ctx, &appendEntryInput)
+				if err != nil {
+					break
+				}
+				//checkError(err)
+				if appendEntryResponse.Success {
+					appendSuccesses++
+					s.matchIndex[idx] = appendEntryResponse.MatchedIndex
+					s.nextIndex[idx] = int64(len(s.log))
+					//fmt.Printf("%d. RaftServer UpdateFile() applied appendEntry to %d successfully\n", s.id, idx)
+					break
+				} else {
+					if prevLogIndex > 0 {
+						prevLogIndex--
+						prevLogTerm = s.log[prevLogIndex].Term
+						s.nextIndex[idx] = int64(prevLogIndex)
+					} else {
+						break
 					}
 				}
-
-				// Commit update if majority of servers agreed
-				if appendSuccesses >= int(math.Ceil(float64(len(s.raftAddrs))/2.0)) {
-					//fmt.Printf("%d. Apply commit to log. appendSuccesses: %d\n", s.id, appendSuccesses)
-					// log update in local log
-					s.metaStore.FileMetaMap[filemeta.Filename] = filemeta
-					version.Version = filemeta.Version
-					s.lastApplied = int64(len(s.log) - 1)
-					//s.SendHeartbeat(ctx, empty)
-					return &version, ctx.Err()
-				} // otherwise restart and try to get a majority again
 			}
-		} else { // leader is crashed
-			return nil, ERR_SERVER_CRASHED
 		}
+
+		// Commit update if majority of servers agreed
+		if appendSuccesses >= int(math.Ceil(float64(len(s.raftAddrs))/2.0)) {
+			//fmt.Printf("%d. Apply commit to log. appendSuccesses: %d\n", s.id, appendSuccesses)
+			return nil
+		} // otherwise restart and try to get a majority again
 	}
-	return nil, ERR_NOT_LEADER
 }
 
 // 1. Reply false if term < currentTerm (§5.1)
@@ -244,6 +510,9 @@ func (s *RaftSurfstore) UpdateFile(ctx context.Context, filemeta *FileMetaData)
 // 5. If leaderCommit > commitIndex, set commitIndex = min(leaderCommit, index
 // of last new entry)
 func (s *RaftSurfstore) AppendEntries(ctx context.Context, input *AppendEntryInput) (*AppendEntryOutput, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
 	var output = AppendEntryOutput{Term: s.term, Success: true}
 
 	if s.isCrashed {
@@ -270,9 +539,8 @@ func (s *RaftSurfstore) AppendEntries(ctx context.Context, input *AppendEntryInp
 						s.lastApplied = s.lastApplied + 1
 					}
 					fmt.Printf("%d. Applied commit to log. s.commitIndex: %d. s.lastApplied: %d. len(s.log): %d\n", s.id, s.commitIndex, s.lastApplied, len(s.log))
-					filemeta := s.log[s.lastApplied].FileMetaData
-					fmt.Printf("%d. Log commit (%d) meta: %v\n", s.id, s.lastApplied, filemeta)
-					s.metaStore.FileMetaMap[filemeta.Filename] = filemeta
+					fmt.Printf("%d. Log commit (%d) entry: %v\n", s.id, s.lastApplied, s.log[s.lastApplied])
+					s.applyEntry(s.log[s.lastApplied])
 
 					if s.lastApplied == 0 {
 						break
@@ -332,6 +600,9 @@ func (s *RaftSurfstore) AppendEntries(ctx context.Context, input *AppendEntryInp
 }
 
 func (s *RaftSurfstore) SetLeader(ctx context.Context, empty *emptypb.Empty) (*Success, error) {
+	s.stateMutex.Lock()
+	defer s.stateMutex.Unlock()
+
 	s.term++
 	s.isLeader = true
 	s.nextIndex = make([]int64, len(s.raftAddrs))
@@ -348,6 +619,13 @@ func (s *RaftSurfstore) SetLeader(ctx context.Context, empty *emptypb.Empty) (*S
 }
 
 func (s *RaftSurfstore) SendHeartbeat(ctx context.Context, _ *emptypb.Empty) (*Success, error) {
+	s.stateMutex.RLock()
+	defer s.stateMutex.RUnlock()
+	return s.sendHeartbeat(ctx)
+}
+
+// Callers must hold stateMutex
+func (s *RaftSurfstore) sendHeartbeat(ctx context.Context) (*Success, error) {
 	print_state(s)
 	var appendEntryInput = AppendEntryInput{Term: s.term, Entries: make([]*UpdateOperation, 0)}
 	respondedServers := 1 // automatically call self
@@ -405,15 +683,17 @@ func (s *RaftSurfstore) Restore(ctx context.Context, _ *emptypb.Empty) (*Success
 }
 
 func (s *RaftSurfstore) GetInternalState(ctx context.Context, empty *emptypb.Empty) (*RaftInternalState, error) {
+	s.stateMutex.RLock()
 	fileInfoMap, _ := s.metaStore.GetFileInfoMap(ctx, empty)
 	s.isLeaderMutex.RLock()
 	state := &RaftInternalState{
 		IsLeader: s.isLeader,
 		Term:     s.term,
-		Log:      s.log,
+		Log:      append([]*UpdateOperation{}, s.log...),
 		MetaMap:  fileInfoMap,
 	}
 	s.isLeaderMutex.RUnlock()
+	s.stateMutex.RUnlock()
 
 	return state, nil
 }
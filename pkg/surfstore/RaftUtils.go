@@ -3,6 +3,7 @@ package surfstore
 import (
 	"bufio"
 	"encoding/json"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -13,8 +14,109 @@ import (
 )
 
 type RaftConfig struct {
-	RaftAddrs  []string
-	BlockAddrs []string
+	RaftAddrs    []string
+	BlockAddrs   []string
+	BlockServers []BlockServerConfig // BlockAddrs with their weights, in the same order
+	VirtualNodes int                 // ring points per unit of weight, 1 if unset
+	Placement    string              // PLACEMENT_RING if unset, or PLACEMENT_RENDEZVOUS
+
+	// copies kept of each block, 1 if unset. Copies go to BlockStores in
+	// different failure domains where the Zone and Rack labels allow.
+	ReplicationFactor int
+}
+
+// Entries of BlockAddrs are either an address or an object with its weight
+// and failure domain labels:
+//
+//	"BlockAddrs": ["localhost:8080", {"Addr": "localhost:8081", "Weight": 2, "Zone": "a", "Rack": "r1"}]
+type BlockServerConfig struct {
+	Addr   string
+	Weight int
+	Zone   string
+	Rack   string
+}
+
+func (cfg *RaftConfig) UnmarshalJSON(data []byte) error {
+	var rawConfig struct {
+		RaftAddrs         []string
+		BlockAddrs        []json.RawMessage
+		VirtualNodes      int
+		Placement         string
+		ReplicationFactor int
+	}
+	if err := json.Unmarshal(data, &rawConfig); err != nil {
+		return err
+	}
+
+	cfg.RaftAddrs = rawConfig.RaftAddrs
+	cfg.VirtualNodes = rawConfig.VirtualNodes
+	cfg.Placement = rawConfig.Placement
+	if !IsPlacementStrategy(cfg.Placement) {
+		return fmt.Errorf("unknown Placement %q", cfg.Placement)
+	}
+	cfg.ReplicationFactor = rawConfig.ReplicationFactor
+	if cfg.ReplicationFactor < 0 {
+		return fmt.Errorf("invalid ReplicationFactor %d", cfg.ReplicationFactor)
+	}
+	cfg.BlockAddrs = make([]string, 0, len(rawConfig.BlockAddrs))
+	cfg.BlockServers = make([]BlockServerConfig, 0, len(rawConfig.BlockAddrs))
+	for _, rawAddr := range rawConfig.BlockAddrs {
+		server := BlockServerConfig{Weight: 1}
+		if err := json.Unmarshal(rawAddr, &server.Addr); err != nil {
+			if err := json.Unmarshal(rawAddr, &server); err != nil {
+				return fmt.Errorf("invalid BlockAddrs entry %s", string(rawAddr))
+			}
+		}
+		if server.Addr == "" || server.Weight < 1 {
+			return fmt.Errorf("invalid BlockAddrs entry %s", string(rawAddr))
+		}
+		cfg.BlockAddrs = append(cfg.BlockAddrs, server.Addr)
+		cfg.BlockServers = append(cfg.BlockServers, server)
+	}
+	return nil
+}
+
+// GetBlockMembership returns the BlockStores the config starts the cluster with
+func (cfg RaftConfig) GetBlockMembership() *BlockMembership {
+	membership := BlockMembership{Servers: make([]*BlockServer, 0, len(cfg.BlockAddrs)), VirtualNodes: int32(cfg.VirtualNodes), Placement: cfg.Placement}
+	membership.ReplicationFactor = int32(cfg.ReplicationFactor)
+	for _, server := range cfg.GetBlockServers() {
+		membership.Servers = append(membership.Servers, &BlockServer{Addr: server.Addr, Weight: int32(server.Weight), Zone: server.Zone, Rack: server.Rack})
+	}
+	return &membership
+}
+
+// GetBlockServers returns the weighted BlockServers, treating a config built
+// with only BlockAddrs as all weights being 1
+func (cfg RaftConfig) GetBlockServers() []BlockServerConfig {
+	if len(cfg.BlockServers) == len(cfg.BlockAddrs) {
+		return cfg.BlockServers
+	}
+	servers := make([]BlockServerConfig, 0, len(cfg.BlockAddrs))
+	for _, addr := range cfg.BlockAddrs {
+		servers = append(servers, BlockServerConfig{Addr: addr, Weight: 1})
+	}
+	return servers
+}
+
+// GetPlacementStrategy builds the placement strategy the config selects over its BlockStores
+func (cfg RaftConfig) GetPlacementStrategy() PlacementStrategy {
+	return NewPlacementStrategy(cfg.Placement, cfg.GetBlockServers(), cfg.VirtualNodes)
+}
+
+// IsPlacementStrategy checks whether placement names a known strategy, where
+// "" is the default ring
+func IsPlacementStrategy(placement string) bool {
+	return placement == "" || placement == PLACEMENT_RING || placement == PLACEMENT_RENDEZVOUS
+}
+
+// NewPlacementStrategy builds the named strategy over servers. virtualNodes
+// only applies to the ring.
+func NewPlacementStrategy(placement string, servers []BlockServerConfig, virtualNodes int) PlacementStrategy {
+	if placement == PLACEMENT_RENDEZVOUS {
+		return NewRendezvousHash(servers)
+	}
+	return NewWeightedConsistentHashRing(servers, virtualNodes)
 }
 
 func LoadRaftConfigFile(filename string) (cfg RaftConfig) {
@@ -40,13 +142,15 @@ func NewRaftServer(id int64, config RaftConfig) (*RaftSurfstore, error) {
 
 	isLeaderMutex := sync.RWMutex{}
 	isCrashedMutex := sync.RWMutex{}
-	consistentHashRing := NewConsistentHashRing(config.BlockAddrs)
+	migrationMutex := sync.Mutex{}
+	metaStore := NewMetaStore(config.BlockAddrs, nil)
+	metaStore.ApplyBlockMembership(config.GetBlockMembership())
 
 	server := RaftSurfstore{
 		isLeader:       false,
 		isLeaderMutex:  &isLeaderMutex,
 		term:           0,
-		metaStore:      NewMetaStore(config.BlockAddrs, consistentHashRing),
+		metaStore:      metaStore,
 		log:            make([]*UpdateOperation, 0),
 		isCrashed:      false,
 		isCrashedMutex: &isCrashedMutex,
@@ -57,6 +161,15 @@ func NewRaftServer(id int64, config RaftConfig) (*RaftSurfstore, error) {
 		lastApplied:    0,
 		nextIndex:      make([]int64, len(config.RaftAddrs)),
 		matchIndex:     make([]int64, len(config.RaftAddrs)),
+		gcGracePeriod:  DEFAULT_GC_GRACE_PERIOD,
+
+		blockStoreStats:      map[string]*BlockStoreStats{},
+		blockStoreStatsMutex: &sync.RWMutex{},
+		migrationMutex:       &migrationMutex,
+		stateMutex:           &sync.RWMutex{},
+
+		changed:     make(chan struct{}),
+		changeMutex: &sync.Mutex{},
 	}
 
 	return &server, nil
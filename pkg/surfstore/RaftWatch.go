@@ -0,0 +1,63 @@
+package surfstore
+
+import (
+	context "context"
+	"fmt"
+	"time"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// Wake the WaitForChanges calls after a file update is applied
+func (s *RaftSurfstore) notifyChanges() {
+	s.changeMutex.Lock()
+	defer s.changeMutex.Unlock()
+	s.changeIndex++
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+// Return the current cursor once it differs from the given one, or after
+// DEFAULT_WATCH_TIMEOUT. Cursors are counted by each leader, so a new leader
+// gives watchers one spurious change rather than a missed one.
+func (s *RaftSurfstore) WaitForChanges(ctx context.Context, cursor *ChangeCursor) (*ChangeCursor, error) {
+	if s.isLeader {
+		if !s.isCrashed {
+			var empty emptypb.Empty
+			succ, err := s.SendHeartbeat(ctx, &empty)
+			checkError(err)
+			if !succ.Flag {
+				fmt.Printf("SendHeartbeat failed\n")
+				return nil, ERR_SERVER_CRASHED
+			}
+
+			timeout := time.NewTimer(DEFAULT_WATCH_TIMEOUT)
+			defer timeout.Stop()
+			for {
+				s.changeMutex.Lock()
+				index, changed := s.changeIndex, s.changed
+				s.changeMutex.Unlock()
+				if index != cursor.Index {
+					return &ChangeCursor{Index: index}, nil
+				}
+
+				select {
+				case <-changed:
+				case <-timeout.C:
+					return &ChangeCursor{Index: index}, nil
+				case <-ctx.Done():
+					return nil, ctx.Err()
+				}
+				if !s.isLeader {
+					return nil, ERR_NOT_LEADER
+				}
+				if s.isCrashed {
+					return nil, ERR_SERVER_CRASHED
+				}
+			}
+		} else { // leader is crashed
+			return nil, ERR_SERVER_CRASHED
+		}
+	}
+	return nil, ERR_NOT_LEADER
+}
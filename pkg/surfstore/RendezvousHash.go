@@ -0,0 +1,106 @@
+package surfstore
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"math"
+	"sort"
+)
+
+// RendezvousHash assigns each block to the server with the highest score for
+// it. A membership change only moves the blocks whose top server joined or
+// left, and there is no ring to balance, which suits small tiers that change
+// often. Lookups score every server, so they cost O(servers) per block.
+type RendezvousHash struct {
+	Servers []BlockServerConfig // do not modify
+}
+
+// GetResponsibleServer returns the server with the highest score for the block
+func (r RendezvousHash) GetResponsibleServer(blockId string) string {
+	best := ""
+	bestScore := math.Inf(-1)
+	for _, server := range r.Servers {
+		score := r.score(server, blockId)
+		if score > bestScore || (score == bestScore && server.Addr < best) {
+			best = server.Addr
+			bestScore = score
+		}
+	}
+	return best
+}
+
+// LookupMany returns the responsible server of each block, in input order
+func (r RendezvousHash) LookupMany(blockIds []string) []string {
+	servers := make([]string, len(blockIds))
+	for i, blockId := range blockIds {
+		servers[i] = r.GetResponsibleServer(blockId)
+	}
+	return servers
+}
+
+// GetTopServers returns the n servers with the highest scores for the block,
+// highest first
+func (r RendezvousHash) GetTopServers(blockId string, n int) []string {
+	scores := make(map[string]float64, len(r.Servers))
+	servers := make([]string, 0, len(r.Servers))
+	for _, server := range r.Servers {
+		if _, ok := scores[server.Addr]; !ok {
+			servers = append(servers, server.Addr)
+		}
+		scores[server.Addr] = r.score(server, blockId)
+	}
+	sort.Slice(servers, func(i, j int) bool {
+		if scores[servers[i]] != scores[servers[j]] {
+			return scores[servers[i]] > scores[servers[j]]
+		}
+		return servers[i] < servers[j]
+	})
+
+	if n > 0 && n < len(servers) {
+		return servers[:n]
+	}
+	return servers
+}
+
+// GetReplicaServers returns n servers for the copies of the block, in order of
+// score but skipping servers in failure domains already used
+func (r RendezvousHash) GetReplicaServers(blockId string, n int) []string {
+	return spreadOverFailureDomains(r.GetTopServers(blockId, 0), getServerLabels(r.Servers), n)
+}
+
+// GetOwnershipShares returns each server's share of the weight, which is
+// exactly the share of blocks weighted rendezvous hashing gives it
+func (r RendezvousHash) GetOwnershipShares() map[string]float64 {
+	shares := make(map[string]float64)
+	totalWeight := 0
+	for _, server := range r.Servers {
+		totalWeight += server.Weight
+	}
+	for _, server := range r.Servers {
+		shares[server.Addr] += float64(server.Weight) / float64(totalWeight)
+	}
+	return shares
+}
+
+// Weighted score -weight/ln(u) for a uniform u in (0, 1) drawn from the hash of
+// the server and block, so a server wins a share of blocks proportional to its
+// weight
+func (r RendezvousHash) score(server BlockServerConfig, blockId string) float64 {
+	h := sha256.Sum256([]byte("blockstore" + server.Addr + blockId))
+	u := (float64(binary.BigEndian.Uint64(h[:8])>>11) + 0.5) / (1 << 53)
+	return -float64(server.Weight) / math.Log(u)
+}
+
+// This line guarantees all method for RendezvousHash are implemented
+var _ PlacementStrategy = new(RendezvousHash)
+
+func NewRendezvousHash(servers []BlockServerConfig) *RendezvousHash {
+	rendezvousHash := RendezvousHash{Servers: make([]BlockServerConfig, 0, len(servers))}
+	for _, server := range servers {
+		if server.Weight < 1 {
+			server.Weight = 1
+		}
+		rendezvousHash.Servers = append(rendezvousHash.Servers, server)
+	}
+	return &rendezvousHash
+}
@@ -21,6 +21,58 @@ const (
 	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
 )
 
+type Codec int32
+
+const (
+	Codec_NONE   Codec = 0
+	Codec_GZIP   Codec = 1
+	Codec_ZSTD   Codec = 2
+	Codec_SNAPPY Codec = 3
+)
+
+// Enum value maps for Codec.
+var (
+	Codec_name = map[int32]string{
+		0: "NONE",
+		1: "GZIP",
+		2: "ZSTD",
+		3: "SNAPPY",
+	}
+	Codec_value = map[string]int32{
+		"NONE":   0,
+		"GZIP":   1,
+		"ZSTD":   2,
+		"SNAPPY": 3,
+	}
+)
+
+func (x Codec) Enum() *Codec {
+	p := new(Codec)
+	*p = x
+	return p
+}
+
+func (x Codec) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Codec) Descriptor() protoreflect.EnumDescriptor {
+	return file_SurfStore_proto_enumTypes[0].Descriptor()
+}
+
+func (Codec) Type() protoreflect.EnumType {
+	return &file_SurfStore_proto_enumTypes[0]
+}
+
+func (x Codec) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Codec.Descriptor instead.
+func (Codec) EnumDescriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{0}
+}
+
 type BlockHash struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -115,19 +167,268 @@ func (x *BlockHashes) GetHashes() []string {
 	return nil
 }
 
+type BlockDeletion struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Hashes             []string `protobuf:"bytes,1,rep,name=hashes,proto3" json:"hashes,omitempty"`
+	GracePeriodSeconds int64    `protobuf:"varint,2,opt,name=gracePeriodSeconds,proto3" json:"gracePeriodSeconds,omitempty"`
+}
+
+func (x *BlockDeletion) Reset() {
+	*x = BlockDeletion{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockDeletion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockDeletion) ProtoMessage() {}
+
+func (x *BlockDeletion) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockDeletion.ProtoReflect.Descriptor instead.
+func (*BlockDeletion) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *BlockDeletion) GetHashes() []string {
+	if x != nil {
+		return x.Hashes
+	}
+	return nil
+}
+
+func (x *BlockDeletion) GetGracePeriodSeconds() int64 {
+	if x != nil {
+		return x.GracePeriodSeconds
+	}
+	return 0
+}
+
+type Codecs struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Codecs []Codec `protobuf:"varint,1,rep,packed,name=codecs,proto3,enum=surfstore.Codec" json:"codecs,omitempty"`
+}
+
+func (x *Codecs) Reset() {
+	*x = Codecs{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *Codecs) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*Codecs) ProtoMessage() {}
+
+func (x *Codecs) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use Codecs.ProtoReflect.Descriptor instead.
+func (*Codecs) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *Codecs) GetCodecs() []Codec {
+	if x != nil {
+		return x.Codecs
+	}
+	return nil
+}
+
+type CompressionStats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	UncompressedBytes int64 `protobuf:"varint,1,opt,name=uncompressedBytes,proto3" json:"uncompressedBytes,omitempty"`
+	StoredBytes       int64 `protobuf:"varint,2,opt,name=storedBytes,proto3" json:"storedBytes,omitempty"`
+}
+
+func (x *CompressionStats) Reset() {
+	*x = CompressionStats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *CompressionStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*CompressionStats) ProtoMessage() {}
+
+func (x *CompressionStats) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use CompressionStats.ProtoReflect.Descriptor instead.
+func (*CompressionStats) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *CompressionStats) GetUncompressedBytes() int64 {
+	if x != nil {
+		return x.UncompressedBytes
+	}
+	return 0
+}
+
+func (x *CompressionStats) GetStoredBytes() int64 {
+	if x != nil {
+		return x.StoredBytes
+	}
+	return 0
+}
+
+// capacityBytes is 0 when the BlockStore is unlimited
+type BlockStoreStats struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	BlockCount    int64 `protobuf:"varint,1,opt,name=blockCount,proto3" json:"blockCount,omitempty"`
+	BytesUsed     int64 `protobuf:"varint,2,opt,name=bytesUsed,proto3" json:"bytesUsed,omitempty"`
+	CapacityBytes int64 `protobuf:"varint,3,opt,name=capacityBytes,proto3" json:"capacityBytes,omitempty"`
+	FreeBytes     int64 `protobuf:"varint,4,opt,name=freeBytes,proto3" json:"freeBytes,omitempty"`
+	CacheHits     int64 `protobuf:"varint,5,opt,name=cacheHits,proto3" json:"cacheHits,omitempty"`
+	CacheMisses   int64 `protobuf:"varint,6,opt,name=cacheMisses,proto3" json:"cacheMisses,omitempty"`
+}
+
+func (x *BlockStoreStats) Reset() {
+	*x = BlockStoreStats{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockStoreStats) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockStoreStats) ProtoMessage() {}
+
+func (x *BlockStoreStats) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockStoreStats.ProtoReflect.Descriptor instead.
+func (*BlockStoreStats) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *BlockStoreStats) GetBlockCount() int64 {
+	if x != nil {
+		return x.BlockCount
+	}
+	return 0
+}
+
+func (x *BlockStoreStats) GetBytesUsed() int64 {
+	if x != nil {
+		return x.BytesUsed
+	}
+	return 0
+}
+
+func (x *BlockStoreStats) GetCapacityBytes() int64 {
+	if x != nil {
+		return x.CapacityBytes
+	}
+	return 0
+}
+
+func (x *BlockStoreStats) GetFreeBytes() int64 {
+	if x != nil {
+		return x.FreeBytes
+	}
+	return 0
+}
+
+func (x *BlockStoreStats) GetCacheHits() int64 {
+	if x != nil {
+		return x.CacheHits
+	}
+	return 0
+}
+
+func (x *BlockStoreStats) GetCacheMisses() int64 {
+	if x != nil {
+		return x.CacheMisses
+	}
+	return 0
+}
+
 type Block struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
 	BlockData []byte `protobuf:"bytes,1,opt,name=blockData,proto3" json:"blockData,omitempty"`
-	BlockSize int32  `protobuf:"varint,2,opt,name=blockSize,proto3" json:"blockSize,omitempty"`
+	BlockSize int32  `protobuf:"varint,2,opt,name=blockSize,proto3" json:"blockSize,omitempty"` // size of the uncompressed data
+	Codec     Codec  `protobuf:"varint,3,opt,name=codec,proto3,enum=surfstore.Codec" json:"codec,omitempty"`
+	Encrypted bool   `protobuf:"varint,4,opt,name=encrypted,proto3" json:"encrypted,omitempty"`
+	Hash      string `protobuf:"bytes,5,opt,name=hash,proto3" json:"hash,omitempty"`         // key for encrypted blocks, whose content the server cannot hash
+	Checksum  string `protobuf:"bytes,6,opt,name=checksum,proto3" json:"checksum,omitempty"` // hash of blockData the server takes when an encrypted block is put, to scrub it with
 }
 
 func (x *Block) Reset() {
 	*x = Block{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[2]
+		mi := &file_SurfStore_proto_msgTypes[6]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -140,7 +441,7 @@ func (x *Block) String() string {
 func (*Block) ProtoMessage() {}
 
 func (x *Block) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[2]
+	mi := &file_SurfStore_proto_msgTypes[6]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -153,7 +454,7 @@ func (x *Block) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Block.ProtoReflect.Descriptor instead.
 func (*Block) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{2}
+	return file_SurfStore_proto_rawDescGZIP(), []int{6}
 }
 
 func (x *Block) GetBlockData() []byte {
@@ -170,6 +471,34 @@ func (x *Block) GetBlockSize() int32 {
 	return 0
 }
 
+func (x *Block) GetCodec() Codec {
+	if x != nil {
+		return x.Codec
+	}
+	return Codec_NONE
+}
+
+func (x *Block) GetEncrypted() bool {
+	if x != nil {
+		return x.Encrypted
+	}
+	return false
+}
+
+func (x *Block) GetHash() string {
+	if x != nil {
+		return x.Hash
+	}
+	return ""
+}
+
+func (x *Block) GetChecksum() string {
+	if x != nil {
+		return x.Checksum
+	}
+	return ""
+}
+
 type Success struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -181,7 +510,7 @@ type Success struct {
 func (x *Success) Reset() {
 	*x = Success{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[3]
+		mi := &file_SurfStore_proto_msgTypes[7]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -194,7 +523,7 @@ func (x *Success) String() string {
 func (*Success) ProtoMessage() {}
 
 func (x *Success) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[3]
+	mi := &file_SurfStore_proto_msgTypes[7]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -207,7 +536,7 @@ func (x *Success) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Success.ProtoReflect.Descriptor instead.
 func (*Success) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{3}
+	return file_SurfStore_proto_rawDescGZIP(), []int{7}
 }
 
 func (x *Success) GetFlag() bool {
@@ -225,12 +554,13 @@ type FileMetaData struct {
 	Filename      string   `protobuf:"bytes,1,opt,name=filename,proto3" json:"filename,omitempty"`
 	Version       int32    `protobuf:"varint,2,opt,name=version,proto3" json:"version,omitempty"`
 	BlockHashList []string `protobuf:"bytes,3,rep,name=blockHashList,proto3" json:"blockHashList,omitempty"`
+	Chunker       string   `protobuf:"bytes,4,opt,name=chunker,proto3" json:"chunker,omitempty"` // how the file was split into blocks, fixed BlockSize chunks if empty
 }
 
 func (x *FileMetaData) Reset() {
 	*x = FileMetaData{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[4]
+		mi := &file_SurfStore_proto_msgTypes[8]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -243,7 +573,7 @@ func (x *FileMetaData) String() string {
 func (*FileMetaData) ProtoMessage() {}
 
 func (x *FileMetaData) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[4]
+	mi := &file_SurfStore_proto_msgTypes[8]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -256,7 +586,7 @@ func (x *FileMetaData) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FileMetaData.ProtoReflect.Descriptor instead.
 func (*FileMetaData) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{4}
+	return file_SurfStore_proto_rawDescGZIP(), []int{8}
 }
 
 func (x *FileMetaData) GetFilename() string {
@@ -280,6 +610,13 @@ func (x *FileMetaData) GetBlockHashList() []string {
 	return nil
 }
 
+func (x *FileMetaData) GetChunker() string {
+	if x != nil {
+		return x.Chunker
+	}
+	return ""
+}
+
 type FileInfoMap struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -291,7 +628,7 @@ type FileInfoMap struct {
 func (x *FileInfoMap) Reset() {
 	*x = FileInfoMap{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[5]
+		mi := &file_SurfStore_proto_msgTypes[9]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -304,7 +641,7 @@ func (x *FileInfoMap) String() string {
 func (*FileInfoMap) ProtoMessage() {}
 
 func (x *FileInfoMap) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[5]
+	mi := &file_SurfStore_proto_msgTypes[9]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -317,7 +654,7 @@ func (x *FileInfoMap) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use FileInfoMap.ProtoReflect.Descriptor instead.
 func (*FileInfoMap) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{5}
+	return file_SurfStore_proto_rawDescGZIP(), []int{9}
 }
 
 func (x *FileInfoMap) GetFileInfoMap() map[string]*FileMetaData {
@@ -338,7 +675,7 @@ type Version struct {
 func (x *Version) Reset() {
 	*x = Version{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[6]
+		mi := &file_SurfStore_proto_msgTypes[10]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -351,7 +688,7 @@ func (x *Version) String() string {
 func (*Version) ProtoMessage() {}
 
 func (x *Version) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[6]
+	mi := &file_SurfStore_proto_msgTypes[10]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -364,7 +701,7 @@ func (x *Version) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use Version.ProtoReflect.Descriptor instead.
 func (*Version) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{6}
+	return file_SurfStore_proto_rawDescGZIP(), []int{10}
 }
 
 func (x *Version) GetVersion() int32 {
@@ -385,7 +722,7 @@ type BlockStoreMap struct {
 func (x *BlockStoreMap) Reset() {
 	*x = BlockStoreMap{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[7]
+		mi := &file_SurfStore_proto_msgTypes[11]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -398,7 +735,7 @@ func (x *BlockStoreMap) String() string {
 func (*BlockStoreMap) ProtoMessage() {}
 
 func (x *BlockStoreMap) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[7]
+	mi := &file_SurfStore_proto_msgTypes[11]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -411,7 +748,7 @@ func (x *BlockStoreMap) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BlockStoreMap.ProtoReflect.Descriptor instead.
 func (*BlockStoreMap) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{7}
+	return file_SurfStore_proto_rawDescGZIP(), []int{11}
 }
 
 func (x *BlockStoreMap) GetBlockStoreMap() map[string]*BlockHashes {
@@ -432,7 +769,7 @@ type BlockStoreAddrs struct {
 func (x *BlockStoreAddrs) Reset() {
 	*x = BlockStoreAddrs{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[8]
+		mi := &file_SurfStore_proto_msgTypes[12]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -445,7 +782,7 @@ func (x *BlockStoreAddrs) String() string {
 func (*BlockStoreAddrs) ProtoMessage() {}
 
 func (x *BlockStoreAddrs) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[8]
+	mi := &file_SurfStore_proto_msgTypes[12]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -458,7 +795,7 @@ func (x *BlockStoreAddrs) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use BlockStoreAddrs.ProtoReflect.Descriptor instead.
 func (*BlockStoreAddrs) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{8}
+	return file_SurfStore_proto_rawDescGZIP(), []int{12}
 }
 
 func (x *BlockStoreAddrs) GetBlockStoreAddrs() []string {
@@ -479,7 +816,7 @@ type CrashedState struct {
 func (x *CrashedState) Reset() {
 	*x = CrashedState{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[9]
+		mi := &file_SurfStore_proto_msgTypes[13]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -492,7 +829,7 @@ func (x *CrashedState) String() string {
 func (*CrashedState) ProtoMessage() {}
 
 func (x *CrashedState) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[9]
+	mi := &file_SurfStore_proto_msgTypes[13]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -505,7 +842,7 @@ func (x *CrashedState) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use CrashedState.ProtoReflect.Descriptor instead.
 func (*CrashedState) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{9}
+	return file_SurfStore_proto_rawDescGZIP(), []int{13}
 }
 
 func (x *CrashedState) GetIsCrashed() bool {
@@ -530,7 +867,7 @@ type AppendEntryInput struct {
 func (x *AppendEntryInput) Reset() {
 	*x = AppendEntryInput{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[10]
+		mi := &file_SurfStore_proto_msgTypes[14]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -543,7 +880,7 @@ func (x *AppendEntryInput) String() string {
 func (*AppendEntryInput) ProtoMessage() {}
 
 func (x *AppendEntryInput) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[10]
+	mi := &file_SurfStore_proto_msgTypes[14]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -556,7 +893,7 @@ func (x *AppendEntryInput) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AppendEntryInput.ProtoReflect.Descriptor instead.
 func (*AppendEntryInput) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{10}
+	return file_SurfStore_proto_rawDescGZIP(), []int{14}
 }
 
 func (x *AppendEntryInput) GetTerm() int64 {
@@ -608,7 +945,7 @@ type AppendEntryOutput struct {
 func (x *AppendEntryOutput) Reset() {
 	*x = AppendEntryOutput{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[11]
+		mi := &file_SurfStore_proto_msgTypes[15]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -621,7 +958,7 @@ func (x *AppendEntryOutput) String() string {
 func (*AppendEntryOutput) ProtoMessage() {}
 
 func (x *AppendEntryOutput) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[11]
+	mi := &file_SurfStore_proto_msgTypes[15]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -634,7 +971,7 @@ func (x *AppendEntryOutput) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use AppendEntryOutput.ProtoReflect.Descriptor instead.
 func (*AppendEntryOutput) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{11}
+	return file_SurfStore_proto_rawDescGZIP(), []int{15}
 }
 
 func (x *AppendEntryOutput) GetServerId() int64 {
@@ -670,14 +1007,15 @@ type UpdateOperation struct {
 	sizeCache     protoimpl.SizeCache
 	unknownFields protoimpl.UnknownFields
 
-	Term         int64         `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
-	FileMetaData *FileMetaData `protobuf:"bytes,3,opt,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
+	Term         int64            `protobuf:"varint,1,opt,name=term,proto3" json:"term,omitempty"`
+	FileMetaData *FileMetaData    `protobuf:"bytes,3,opt,name=fileMetaData,proto3" json:"fileMetaData,omitempty"`
+	Membership   *BlockMembership `protobuf:"bytes,4,opt,name=membership,proto3" json:"membership,omitempty"` // set instead of fileMetaData for membership changes
 }
 
 func (x *UpdateOperation) Reset() {
 	*x = UpdateOperation{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[12]
+		mi := &file_SurfStore_proto_msgTypes[16]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -690,7 +1028,7 @@ func (x *UpdateOperation) String() string {
 func (*UpdateOperation) ProtoMessage() {}
 
 func (x *UpdateOperation) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[12]
+	mi := &file_SurfStore_proto_msgTypes[16]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -703,7 +1041,7 @@ func (x *UpdateOperation) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use UpdateOperation.ProtoReflect.Descriptor instead.
 func (*UpdateOperation) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{12}
+	return file_SurfStore_proto_rawDescGZIP(), []int{16}
 }
 
 func (x *UpdateOperation) GetTerm() int64 {
@@ -720,6 +1058,213 @@ func (x *UpdateOperation) GetFileMetaData() *FileMetaData {
 	return nil
 }
 
+func (x *UpdateOperation) GetMembership() *BlockMembership {
+	if x != nil {
+		return x.Membership
+	}
+	return nil
+}
+
+type BlockServer struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Addr   string `protobuf:"bytes,1,opt,name=addr,proto3" json:"addr,omitempty"`
+	Weight int32  `protobuf:"varint,2,opt,name=weight,proto3" json:"weight,omitempty"`
+	Zone   string `protobuf:"bytes,3,opt,name=zone,proto3" json:"zone,omitempty"` // failure domain labels, replicas avoid sharing them
+	Rack   string `protobuf:"bytes,4,opt,name=rack,proto3" json:"rack,omitempty"`
+}
+
+func (x *BlockServer) Reset() {
+	*x = BlockServer{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[17]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockServer) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockServer) ProtoMessage() {}
+
+func (x *BlockServer) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[17]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockServer.ProtoReflect.Descriptor instead.
+func (*BlockServer) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{17}
+}
+
+func (x *BlockServer) GetAddr() string {
+	if x != nil {
+		return x.Addr
+	}
+	return ""
+}
+
+func (x *BlockServer) GetWeight() int32 {
+	if x != nil {
+		return x.Weight
+	}
+	return 0
+}
+
+func (x *BlockServer) GetZone() string {
+	if x != nil {
+		return x.Zone
+	}
+	return ""
+}
+
+func (x *BlockServer) GetRack() string {
+	if x != nil {
+		return x.Rack
+	}
+	return ""
+}
+
+// migrating is set while blocks are copied to their owners under servers,
+// which become the only members once it is cleared
+type BlockMembership struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Servers           []*BlockServer `protobuf:"bytes,1,rep,name=servers,proto3" json:"servers,omitempty"`
+	VirtualNodes      int32          `protobuf:"varint,2,opt,name=virtualNodes,proto3" json:"virtualNodes,omitempty"`
+	Migrating         bool           `protobuf:"varint,3,opt,name=migrating,proto3" json:"migrating,omitempty"`
+	Placement         string         `protobuf:"bytes,4,opt,name=placement,proto3" json:"placement,omitempty"`                  // placement strategy, the consistent hash ring if empty
+	ReplicationFactor int32          `protobuf:"varint,5,opt,name=replicationFactor,proto3" json:"replicationFactor,omitempty"` // copies of each block, 1 if unset
+}
+
+func (x *BlockMembership) Reset() {
+	*x = BlockMembership{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[18]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *BlockMembership) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BlockMembership) ProtoMessage() {}
+
+func (x *BlockMembership) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[18]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BlockMembership.ProtoReflect.Descriptor instead.
+func (*BlockMembership) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{18}
+}
+
+func (x *BlockMembership) GetServers() []*BlockServer {
+	if x != nil {
+		return x.Servers
+	}
+	return nil
+}
+
+func (x *BlockMembership) GetVirtualNodes() int32 {
+	if x != nil {
+		return x.VirtualNodes
+	}
+	return 0
+}
+
+func (x *BlockMembership) GetMigrating() bool {
+	if x != nil {
+		return x.Migrating
+	}
+	return false
+}
+
+func (x *BlockMembership) GetPlacement() string {
+	if x != nil {
+		return x.Placement
+	}
+	return ""
+}
+
+func (x *BlockMembership) GetReplicationFactor() int32 {
+	if x != nil {
+		return x.ReplicationFactor
+	}
+	return 0
+}
+
+// changes applied by the leader, which WaitForChanges waits to move past
+type ChangeCursor struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Index int64 `protobuf:"varint,1,opt,name=index,proto3" json:"index,omitempty"`
+}
+
+func (x *ChangeCursor) Reset() {
+	*x = ChangeCursor{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_SurfStore_proto_msgTypes[19]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *ChangeCursor) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*ChangeCursor) ProtoMessage() {}
+
+func (x *ChangeCursor) ProtoReflect() protoreflect.Message {
+	mi := &file_SurfStore_proto_msgTypes[19]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use ChangeCursor.ProtoReflect.Descriptor instead.
+func (*ChangeCursor) Descriptor() ([]byte, []int) {
+	return file_SurfStore_proto_rawDescGZIP(), []int{19}
+}
+
+func (x *ChangeCursor) GetIndex() int64 {
+	if x != nil {
+		return x.Index
+	}
+	return 0
+}
+
 type RaftInternalState struct {
 	state         protoimpl.MessageState
 	sizeCache     protoimpl.SizeCache
@@ -734,7 +1279,7 @@ type RaftInternalState struct {
 func (x *RaftInternalState) Reset() {
 	*x = RaftInternalState{}
 	if protoimpl.UnsafeEnabled {
-		mi := &file_SurfStore_proto_msgTypes[13]
+		mi := &file_SurfStore_proto_msgTypes[20]
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		ms.StoreMessageInfo(mi)
 	}
@@ -747,7 +1292,7 @@ func (x *RaftInternalState) String() string {
 func (*RaftInternalState) ProtoMessage() {}
 
 func (x *RaftInternalState) ProtoReflect() protoreflect.Message {
-	mi := &file_SurfStore_proto_msgTypes[13]
+	mi := &file_SurfStore_proto_msgTypes[20]
 	if protoimpl.UnsafeEnabled && x != nil {
 		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
 		if ms.LoadMessageInfo() == nil {
@@ -760,7 +1305,7 @@ func (x *RaftInternalState) ProtoReflect() protoreflect.Message {
 
 // Deprecated: Use RaftInternalState.ProtoReflect.Descriptor instead.
 func (*RaftInternalState) Descriptor() ([]byte, []int) {
-	return file_SurfStore_proto_rawDescGZIP(), []int{13}
+	return file_SurfStore_proto_rawDescGZIP(), []int{20}
 }
 
 func (x *RaftInternalState) GetIsLeader() bool {
@@ -802,168 +1347,286 @@ var file_SurfStore_proto_rawDesc = []byte{
 	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x22, 0x25, 0x0a, 0x0b, 0x42, 0x6c,
 	0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x61, 0x73,
 	0x68, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x06, 0x68, 0x61, 0x73, 0x68, 0x65,
-	0x73, 0x22, 0x43, 0x0a, 0x05, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x6c,
-	0x6f, 0x63, 0x6b, 0x44, 0x61, 0x74, 0x61, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x62,
-	0x6c, 0x6f, 0x63, 0x6b, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x63,
-	0x6b, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x6c, 0x6f,
-	0x63, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x22, 0x1d, 0x0a, 0x07, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73,
-	0x73, 0x12, 0x12, 0x0a, 0x04, 0x66, 0x6c, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52,
-	0x04, 0x66, 0x6c, 0x61, 0x67, 0x22, 0x6a, 0x0a, 0x0c, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74,
-	0x61, 0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d,
-	0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d,
-	0x65, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01,
-	0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x62,
-	0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x03, 0x20, 0x03,
-	0x28, 0x09, 0x52, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x4c, 0x69, 0x73,
-	0x74, 0x22, 0xb1, 0x01, 0x0a, 0x0b, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61,
-	0x70, 0x12, 0x49, 0x0a, 0x0b, 0x66, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70,
-	0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x27, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
-	0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x2e, 0x46,
-	0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52,
-	0x0b, 0x66, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x1a, 0x57, 0x0a, 0x10,
-	0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b,
-	0x65, 0x79, 0x12, 0x2d, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28,
-	0x0b, 0x32, 0x17, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69,
-	0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75,
-	0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x23, 0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
-	0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28,
-	0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0xbc, 0x01, 0x0a, 0x0d, 0x42,
-	0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x12, 0x51, 0x0a, 0x0d,
-	0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x18, 0x01, 0x20,
-	0x03, 0x28, 0x0b, 0x32, 0x2b, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
-	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x2e, 0x42, 0x6c,
-	0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79,
-	0x52, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x1a,
-	0x58, 0x0a, 0x12, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70,
-	0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x2c, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65,
-	0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
-	0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x52, 0x05,
-	0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x3b, 0x0a, 0x0f, 0x42, 0x6c, 0x6f,
-	0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x12, 0x28, 0x0a, 0x0f,
-	0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x18,
-	0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72,
-	0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22, 0x2c, 0x0a, 0x0c, 0x43, 0x72, 0x61, 0x73, 0x68, 0x65,
-	0x64, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x73, 0x43, 0x72, 0x61, 0x73,
-	0x68, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x69, 0x73, 0x43, 0x72, 0x61,
-	0x73, 0x68, 0x65, 0x64, 0x22, 0xc6, 0x01, 0x0a, 0x10, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45,
-	0x6e, 0x74, 0x72, 0x79, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72,
-	0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x22, 0x0a,
-	0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20,
-	0x01, 0x28, 0x03, 0x52, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65,
-	0x78, 0x12, 0x20, 0x0a, 0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d,
-	0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54,
-	0x65, 0x72, 0x6d, 0x12, 0x34, 0x0a, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x04,
-	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
-	0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e,
-	0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x6c, 0x65, 0x61,
-	0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52,
-	0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x22, 0x81, 0x01,
-	0x0a, 0x11, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x4f, 0x75, 0x74,
-	0x70, 0x75, 0x74, 0x12, 0x1a, 0x0a, 0x08, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x64, 0x18,
-	0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x64, 0x12,
-	0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74,
-	0x65, 0x72, 0x6d, 0x12, 0x18, 0x0a, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x03,
-	0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x22, 0x0a,
-	0x0c, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20,
-	0x01, 0x28, 0x03, 0x52, 0x0c, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65, 0x64, 0x49, 0x6e, 0x64, 0x65,
-	0x78, 0x22, 0x62, 0x0a, 0x0f, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4f, 0x70, 0x65, 0x72, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01,
-	0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x3b, 0x0a, 0x0c, 0x66, 0x69, 0x6c, 0x65,
-	0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17,
-	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d,
-	0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61, 0x52, 0x0c, 0x66, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74,
-	0x61, 0x44, 0x61, 0x74, 0x61, 0x22, 0xa3, 0x01, 0x0a, 0x11, 0x52, 0x61, 0x66, 0x74, 0x49, 0x6e,
-	0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x1a, 0x0a, 0x08, 0x69,
-	0x73, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x69,
-	0x73, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18,
-	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x2c, 0x0a, 0x03, 0x6c,
-	0x6f, 0x67, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73,
-	0x74, 0x6f, 0x72, 0x65, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4f, 0x70, 0x65, 0x72, 0x61,
-	0x74, 0x69, 0x6f, 0x6e, 0x52, 0x03, 0x6c, 0x6f, 0x67, 0x12, 0x30, 0x0a, 0x07, 0x6d, 0x65, 0x74,
-	0x61, 0x4d, 0x61, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x73, 0x75, 0x72,
-	0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d,
-	0x61, 0x70, 0x52, 0x07, 0x6d, 0x65, 0x74, 0x61, 0x4d, 0x61, 0x70, 0x32, 0xf9, 0x01, 0x0a, 0x0a,
-	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x34, 0x0a, 0x08, 0x47, 0x65,
-	0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x14, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
-	0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x1a, 0x10, 0x2e, 0x73,
-	0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x22, 0x00,
-	0x12, 0x32, 0x0a, 0x08, 0x50, 0x75, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x10, 0x2e, 0x73,
-	0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x1a, 0x12,
-	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65,
-	0x73, 0x73, 0x22, 0x00, 0x12, 0x3d, 0x0a, 0x09, 0x48, 0x61, 0x73, 0x42, 0x6c, 0x6f, 0x63, 0x6b,
-	0x73, 0x12, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c,
-	0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66,
-	0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65,
-	0x73, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48,
-	0x61, 0x73, 0x68, 0x65, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70,
-	0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e,
-	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48,
-	0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x32, 0xa0, 0x02, 0x0a, 0x09, 0x4d, 0x65, 0x74, 0x61,
-	0x53, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x42, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65,
-	0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a,
-	0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65,
-	0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x3b, 0x0a, 0x0a, 0x55, 0x70, 0x64,
-	0x61, 0x74, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74,
-	0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61,
-	0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x56, 0x65, 0x72,
-	0x73, 0x69, 0x6f, 0x6e, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f,
-	0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x73, 0x75, 0x72,
-	0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68,
-	0x65, 0x73, 0x1a, 0x18, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42,
-	0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x4a,
-	0x0a, 0x12, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41,
-	0x64, 0x64, 0x72, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72,
-	0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1a, 0x2e, 0x73,
+	0x73, 0x22, 0x57, 0x0a, 0x0d, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x44, 0x65, 0x6c, 0x65, 0x74, 0x69,
+	0x6f, 0x6e, 0x12, 0x16, 0x0a, 0x06, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x06, 0x68, 0x61, 0x73, 0x68, 0x65, 0x73, 0x12, 0x2e, 0x0a, 0x12, 0x67, 0x72,
+	0x61, 0x63, 0x65, 0x50, 0x65, 0x72, 0x69, 0x6f, 0x64, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x12, 0x67, 0x72, 0x61, 0x63, 0x65, 0x50, 0x65, 0x72,
+	0x69, 0x6f, 0x64, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x22, 0x32, 0x0a, 0x06, 0x43, 0x6f,
+	0x64, 0x65, 0x63, 0x73, 0x12, 0x28, 0x0a, 0x06, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x73, 0x18, 0x01,
+	0x20, 0x03, 0x28, 0x0e, 0x32, 0x10, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x52, 0x06, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x73, 0x22, 0x62,
+	0x0a, 0x10, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61,
+	0x74, 0x73, 0x12, 0x2c, 0x0a, 0x11, 0x75, 0x6e, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73,
+	0x65, 0x64, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x11, 0x75,
+	0x6e, 0x63, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x65, 0x64, 0x42, 0x79, 0x74, 0x65, 0x73,
+	0x12, 0x20, 0x0a, 0x0b, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18,
+	0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x64, 0x42, 0x79, 0x74,
+	0x65, 0x73, 0x22, 0xd3, 0x01, 0x0a, 0x0f, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72,
+	0x65, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x1e, 0x0a, 0x0a, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x43,
+	0x6f, 0x75, 0x6e, 0x74, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x62, 0x6c, 0x6f, 0x63,
+	0x6b, 0x43, 0x6f, 0x75, 0x6e, 0x74, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x79, 0x74, 0x65, 0x73, 0x55,
+	0x73, 0x65, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x62, 0x79, 0x74, 0x65, 0x73,
+	0x55, 0x73, 0x65, 0x64, 0x12, 0x24, 0x0a, 0x0d, 0x63, 0x61, 0x70, 0x61, 0x63, 0x69, 0x74, 0x79,
+	0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0d, 0x63, 0x61, 0x70,
+	0x61, 0x63, 0x69, 0x74, 0x79, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x66, 0x72,
+	0x65, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x66,
+	0x72, 0x65, 0x65, 0x42, 0x79, 0x74, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x63, 0x61, 0x63, 0x68,
+	0x65, 0x48, 0x69, 0x74, 0x73, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x09, 0x63, 0x61, 0x63,
+	0x68, 0x65, 0x48, 0x69, 0x74, 0x73, 0x12, 0x20, 0x0a, 0x0b, 0x63, 0x61, 0x63, 0x68, 0x65, 0x4d,
+	0x69, 0x73, 0x73, 0x65, 0x73, 0x18, 0x06, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0b, 0x63, 0x61, 0x63,
+	0x68, 0x65, 0x4d, 0x69, 0x73, 0x73, 0x65, 0x73, 0x22, 0xb9, 0x01, 0x0a, 0x05, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x12, 0x1c, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x44, 0x61, 0x74, 0x61, 0x18,
+	0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x09, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x44, 0x61, 0x74, 0x61,
+	0x12, 0x1c, 0x0a, 0x09, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x18, 0x02, 0x20,
+	0x01, 0x28, 0x05, 0x52, 0x09, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x69, 0x7a, 0x65, 0x12, 0x26,
+	0x0a, 0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0e, 0x32, 0x10, 0x2e,
+	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x52,
+	0x05, 0x63, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x1c, 0x0a, 0x09, 0x65, 0x6e, 0x63, 0x72, 0x79, 0x70,
+	0x74, 0x65, 0x64, 0x18, 0x04, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x65, 0x6e, 0x63, 0x72, 0x79,
+	0x70, 0x74, 0x65, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x68, 0x61, 0x73, 0x68, 0x18, 0x05, 0x20, 0x01,
+	0x28, 0x09, 0x52, 0x04, 0x68, 0x61, 0x73, 0x68, 0x12, 0x1a, 0x0a, 0x08, 0x63, 0x68, 0x65, 0x63,
+	0x6b, 0x73, 0x75, 0x6d, 0x18, 0x06, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x63, 0x68, 0x65, 0x63,
+	0x6b, 0x73, 0x75, 0x6d, 0x22, 0x1d, 0x0a, 0x07, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12,
+	0x12, 0x0a, 0x04, 0x66, 0x6c, 0x61, 0x67, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x04, 0x66,
+	0x6c, 0x61, 0x67, 0x22, 0x84, 0x01, 0x0a, 0x0c, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61,
+	0x44, 0x61, 0x74, 0x61, 0x12, 0x1a, 0x0a, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d, 0x65,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x08, 0x66, 0x69, 0x6c, 0x65, 0x6e, 0x61, 0x6d, 0x65,
+	0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28,
+	0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a, 0x0d, 0x62, 0x6c,
+	0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x4c, 0x69, 0x73, 0x74, 0x18, 0x03, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x4c, 0x69, 0x73, 0x74,
+	0x12, 0x18, 0x0a, 0x07, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x65, 0x72, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x09, 0x52, 0x07, 0x63, 0x68, 0x75, 0x6e, 0x6b, 0x65, 0x72, 0x22, 0xb1, 0x01, 0x0a, 0x0b, 0x46,
+	0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x12, 0x49, 0x0a, 0x0b, 0x66, 0x69,
+	0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32,
+	0x27, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65,
+	0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f,
+	0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0b, 0x66, 0x69, 0x6c, 0x65, 0x49, 0x6e,
+	0x66, 0x6f, 0x4d, 0x61, 0x70, 0x1a, 0x57, 0x0a, 0x10, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66,
+	0x6f, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79,
+	0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x2d, 0x0a, 0x05, 0x76,
+	0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x73, 0x75, 0x72,
+	0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44,
+	0x61, 0x74, 0x61, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x23,
+	0x0a, 0x07, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x18, 0x0a, 0x07, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x76, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x22, 0xbc, 0x01, 0x0a, 0x0d, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f,
+	0x72, 0x65, 0x4d, 0x61, 0x70, 0x12, 0x51, 0x0a, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x18, 0x01, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x2b, 0x2e, 0x73,
 	0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74,
-	0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22, 0x00, 0x32, 0xa8, 0x05, 0x0a, 0x0d, 0x52,
-	0x61, 0x66, 0x74, 0x53, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x4c, 0x0a, 0x0d,
-	0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x12, 0x1b, 0x2e,
-	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64,
-	0x45, 0x6e, 0x74, 0x72, 0x79, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x1a, 0x1c, 0x2e, 0x73, 0x75, 0x72,
-	0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74,
-	0x72, 0x79, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x09, 0x53, 0x65,
-	0x74, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
+	0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72,
+	0x65, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x0d, 0x62, 0x6c, 0x6f, 0x63, 0x6b,
+	0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x1a, 0x58, 0x0a, 0x12, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10,
+	0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79,
+	0x12, 0x2c, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02,
+	0x38, 0x01, 0x22, 0x3b, 0x0a, 0x0f, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65,
+	0x41, 0x64, 0x64, 0x72, 0x73, 0x12, 0x28, 0x0a, 0x0f, 0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x18, 0x01, 0x20, 0x03, 0x28, 0x09, 0x52, 0x0f,
+	0x62, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22,
+	0x2c, 0x0a, 0x0c, 0x43, 0x72, 0x61, 0x73, 0x68, 0x65, 0x64, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12,
+	0x1c, 0x0a, 0x09, 0x69, 0x73, 0x43, 0x72, 0x61, 0x73, 0x68, 0x65, 0x64, 0x18, 0x01, 0x20, 0x01,
+	0x28, 0x08, 0x52, 0x09, 0x69, 0x73, 0x43, 0x72, 0x61, 0x73, 0x68, 0x65, 0x64, 0x22, 0xc6, 0x01,
+	0x0a, 0x10, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x49, 0x6e, 0x70,
+	0x75, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03,
+	0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x22, 0x0a, 0x0c, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f,
+	0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x70, 0x72,
+	0x65, 0x76, 0x4c, 0x6f, 0x67, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x12, 0x20, 0x0a, 0x0b, 0x70, 0x72,
+	0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x18, 0x03, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x0b, 0x70, 0x72, 0x65, 0x76, 0x4c, 0x6f, 0x67, 0x54, 0x65, 0x72, 0x6d, 0x12, 0x34, 0x0a, 0x07,
+	0x65, 0x6e, 0x74, 0x72, 0x69, 0x65, 0x73, 0x18, 0x04, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e,
+	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65,
+	0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x07, 0x65, 0x6e, 0x74, 0x72, 0x69,
+	0x65, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x43, 0x6f, 0x6d, 0x6d,
+	0x69, 0x74, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x6c, 0x65, 0x61, 0x64, 0x65, 0x72,
+	0x43, 0x6f, 0x6d, 0x6d, 0x69, 0x74, 0x22, 0x81, 0x01, 0x0a, 0x11, 0x41, 0x70, 0x70, 0x65, 0x6e,
+	0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x12, 0x1a, 0x0a, 0x08,
+	0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x08,
+	0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x49, 0x64, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d,
+	0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x18, 0x0a, 0x07,
+	0x73, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x07, 0x73,
+	0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x6d, 0x61, 0x74, 0x63, 0x68, 0x65,
+	0x64, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x04, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0c, 0x6d, 0x61,
+	0x74, 0x63, 0x68, 0x65, 0x64, 0x49, 0x6e, 0x64, 0x65, 0x78, 0x22, 0x9e, 0x01, 0x0a, 0x0f, 0x55,
+	0x70, 0x64, 0x61, 0x74, 0x65, 0x4f, 0x70, 0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x12, 0x12,
+	0x0a, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x18, 0x01, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65,
+	0x72, 0x6d, 0x12, 0x3b, 0x0a, 0x0c, 0x66, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61,
+	0x74, 0x61, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x17, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73,
+	0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74,
+	0x61, 0x52, 0x0c, 0x66, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61, 0x12,
+	0x3a, 0x0a, 0x0a, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
+	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x52,
+	0x0a, 0x6d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x22, 0x61, 0x0a, 0x0b, 0x42,
+	0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x61, 0x64,
+	0x64, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x61, 0x64, 0x64, 0x72, 0x12, 0x16,
+	0x0a, 0x06, 0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x06,
+	0x77, 0x65, 0x69, 0x67, 0x68, 0x74, 0x12, 0x12, 0x0a, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x18, 0x03,
+	0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x7a, 0x6f, 0x6e, 0x65, 0x12, 0x12, 0x0a, 0x04, 0x72, 0x61,
+	0x63, 0x6b, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x04, 0x72, 0x61, 0x63, 0x6b, 0x22, 0xd1,
+	0x01, 0x0a, 0x0f, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68,
+	0x69, 0x70, 0x12, 0x30, 0x0a, 0x07, 0x73, 0x65, 0x72, 0x76, 0x65, 0x72, 0x73, 0x18, 0x01, 0x20,
+	0x03, 0x28, 0x0b, 0x32, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
+	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x65, 0x72, 0x76, 0x65, 0x72, 0x52, 0x07, 0x73, 0x65, 0x72,
+	0x76, 0x65, 0x72, 0x73, 0x12, 0x22, 0x0a, 0x0c, 0x76, 0x69, 0x72, 0x74, 0x75, 0x61, 0x6c, 0x4e,
+	0x6f, 0x64, 0x65, 0x73, 0x18, 0x02, 0x20, 0x01, 0x28, 0x05, 0x52, 0x0c, 0x76, 0x69, 0x72, 0x74,
+	0x75, 0x61, 0x6c, 0x4e, 0x6f, 0x64, 0x65, 0x73, 0x12, 0x1c, 0x0a, 0x09, 0x6d, 0x69, 0x67, 0x72,
+	0x61, 0x74, 0x69, 0x6e, 0x67, 0x18, 0x03, 0x20, 0x01, 0x28, 0x08, 0x52, 0x09, 0x6d, 0x69, 0x67,
+	0x72, 0x61, 0x74, 0x69, 0x6e, 0x67, 0x12, 0x1c, 0x0a, 0x09, 0x70, 0x6c, 0x61, 0x63, 0x65, 0x6d,
+	0x65, 0x6e, 0x74, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x70, 0x6c, 0x61, 0x63, 0x65,
+	0x6d, 0x65, 0x6e, 0x74, 0x12, 0x2c, 0x0a, 0x11, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74,
+	0x69, 0x6f, 0x6e, 0x46, 0x61, 0x63, 0x74, 0x6f, 0x72, 0x18, 0x05, 0x20, 0x01, 0x28, 0x05, 0x52,
+	0x11, 0x72, 0x65, 0x70, 0x6c, 0x69, 0x63, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x46, 0x61, 0x63, 0x74,
+	0x6f, 0x72, 0x22, 0x24, 0x0a, 0x0c, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x75, 0x72, 0x73,
+	0x6f, 0x72, 0x12, 0x14, 0x0a, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x18, 0x01, 0x20, 0x01, 0x28,
+	0x03, 0x52, 0x05, 0x69, 0x6e, 0x64, 0x65, 0x78, 0x22, 0xa3, 0x01, 0x0a, 0x11, 0x52, 0x61, 0x66,
+	0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x1a,
+	0x0a, 0x08, 0x69, 0x73, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08,
+	0x52, 0x08, 0x69, 0x73, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x12, 0x0a, 0x04, 0x74, 0x65,
+	0x72, 0x6d, 0x18, 0x02, 0x20, 0x01, 0x28, 0x03, 0x52, 0x04, 0x74, 0x65, 0x72, 0x6d, 0x12, 0x2c,
+	0x0a, 0x03, 0x6c, 0x6f, 0x67, 0x18, 0x03, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x4f, 0x70,
+	0x65, 0x72, 0x61, 0x74, 0x69, 0x6f, 0x6e, 0x52, 0x03, 0x6c, 0x6f, 0x67, 0x12, 0x30, 0x0a, 0x07,
+	0x6d, 0x65, 0x74, 0x61, 0x4d, 0x61, 0x70, 0x18, 0x04, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x16, 0x2e,
+	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e,
+	0x66, 0x6f, 0x4d, 0x61, 0x70, 0x52, 0x07, 0x6d, 0x65, 0x74, 0x61, 0x4d, 0x61, 0x70, 0x2a, 0x31,
+	0x0a, 0x05, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x12, 0x08, 0x0a, 0x04, 0x4e, 0x4f, 0x4e, 0x45, 0x10,
+	0x00, 0x12, 0x08, 0x0a, 0x04, 0x47, 0x5a, 0x49, 0x50, 0x10, 0x01, 0x12, 0x08, 0x0a, 0x04, 0x5a,
+	0x53, 0x54, 0x44, 0x10, 0x02, 0x12, 0x0a, 0x0a, 0x06, 0x53, 0x4e, 0x41, 0x50, 0x50, 0x59, 0x10,
+	0x03, 0x32, 0x88, 0x06, 0x0a, 0x0a, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65,
+	0x12, 0x34, 0x0a, 0x08, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x12, 0x14, 0x2e, 0x73,
+	0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61,
+	0x73, 0x68, 0x1a, 0x10, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42,
+	0x6c, 0x6f, 0x63, 0x6b, 0x22, 0x00, 0x12, 0x32, 0x0a, 0x08, 0x50, 0x75, 0x74, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x12, 0x10, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42,
+	0x6c, 0x6f, 0x63, 0x6b, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x39, 0x0a, 0x09, 0x47, 0x65,
+	0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74,
+	0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x1a,
+	0x10, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x22, 0x00, 0x30, 0x01, 0x12, 0x35, 0x0a, 0x09, 0x50, 0x75, 0x74, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x73, 0x12, 0x10, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42,
+	0x6c, 0x6f, 0x63, 0x6b, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x28, 0x01, 0x12, 0x3d, 0x0a, 0x09,
+	0x48, 0x61, 0x73, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66,
+	0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65,
+	0x73, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c,
+	0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0e, 0x47,
+	0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x12, 0x16, 0x2e,
+	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
+	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72,
+	0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x12,
+	0x48, 0x0a, 0x14, 0x47, 0x65, 0x74, 0x51, 0x75, 0x61, 0x72, 0x61, 0x6e, 0x74, 0x69, 0x6e, 0x65,
+	0x64, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
 	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a,
-	0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63,
-	0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x3d, 0x0a, 0x0d, 0x53, 0x65, 0x6e, 0x64, 0x48, 0x65, 0x61,
-	0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0c, 0x44, 0x65, 0x6c,
+	0x65, 0x74, 0x65, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x18, 0x2e, 0x73, 0x75, 0x72, 0x66,
+	0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x44, 0x65, 0x6c, 0x65, 0x74,
+	0x69, 0x6f, 0x6e, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
+	0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x12, 0x38, 0x0a,
+	0x09, 0x47, 0x65, 0x74, 0x43, 0x6f, 0x64, 0x65, 0x63, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f,
+	0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70,
+	0x74, 0x79, 0x1a, 0x11, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x43,
+	0x6f, 0x64, 0x65, 0x63, 0x73, 0x22, 0x00, 0x12, 0x4c, 0x0a, 0x13, 0x47, 0x65, 0x74, 0x43, 0x6f,
+	0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x16,
+	0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66,
+	0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1b, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
+	0x72, 0x65, 0x2e, 0x43, 0x6f, 0x6d, 0x70, 0x72, 0x65, 0x73, 0x73, 0x69, 0x6f, 0x6e, 0x53, 0x74,
+	0x61, 0x74, 0x73, 0x22, 0x00, 0x12, 0x43, 0x0a, 0x0f, 0x52, 0x65, 0x65, 0x6e, 0x63, 0x72, 0x79,
+	0x70, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79,
+	0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x22, 0x00, 0x12, 0x40, 0x0a, 0x08, 0x47, 0x65,
+	0x74, 0x53, 0x74, 0x61, 0x74, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1a,
+	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b,
+	0x53, 0x74, 0x6f, 0x72, 0x65, 0x53, 0x74, 0x61, 0x74, 0x73, 0x22, 0x00, 0x32, 0xa0, 0x02, 0x0a,
+	0x09, 0x4d, 0x65, 0x74, 0x61, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x42, 0x0a, 0x0e, 0x47, 0x65,
+	0x74, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x3b,
+	0x0a, 0x0a, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x73,
+	0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74,
+	0x61, 0x44, 0x61, 0x74, 0x61, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72,
+	0x65, 0x2e, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x10, 0x47,
+	0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x12,
+	0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x1a, 0x18, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74,
+	0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61,
+	0x70, 0x22, 0x00, 0x12, 0x4a, 0x0a, 0x12, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53,
+	0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67,
+	0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74,
+	0x79, 0x1a, 0x1a, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c,
+	0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22, 0x00, 0x32,
+	0xc9, 0x07, 0x0a, 0x0d, 0x52, 0x61, 0x66, 0x74, 0x53, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72,
+	0x65, 0x12, 0x4c, 0x0a, 0x0d, 0x41, 0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x69,
+	0x65, 0x73, 0x12, 0x1b, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x41,
+	0x70, 0x70, 0x65, 0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x49, 0x6e, 0x70, 0x75, 0x74, 0x1a,
+	0x1c, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x41, 0x70, 0x70, 0x65,
+	0x6e, 0x64, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x4f, 0x75, 0x74, 0x70, 0x75, 0x74, 0x22, 0x00, 0x12,
+	0x39, 0x0a, 0x09, 0x53, 0x65, 0x74, 0x4c, 0x65, 0x61, 0x64, 0x65, 0x72, 0x12, 0x16, 0x2e, 0x67,
+	0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45,
+	0x6d, 0x70, 0x74, 0x79, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x3d, 0x0a, 0x0d, 0x53, 0x65,
+	0x6e, 0x64, 0x48, 0x65, 0x61, 0x72, 0x74, 0x62, 0x65, 0x61, 0x74, 0x12, 0x16, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d,
+	0x70, 0x74, 0x79, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
+	0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0e, 0x47, 0x65, 0x74,
+	0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d,
+	0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e,
+	0x46, 0x69, 0x6c, 0x65, 0x49, 0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x3b, 0x0a,
+	0x0a, 0x55, 0x70, 0x64, 0x61, 0x74, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61,
+	0x44, 0x61, 0x74, 0x61, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x10, 0x47, 0x65,
+	0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x12, 0x16,
+	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b,
+	0x48, 0x61, 0x73, 0x68, 0x65, 0x73, 0x1a, 0x18, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
+	0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70,
+	0x22, 0x00, 0x12, 0x4a, 0x0a, 0x12, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74,
+	0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c,
+	0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79,
+	0x1a, 0x1a, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f,
+	0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22, 0x00, 0x12, 0x42,
+	0x0a, 0x0e, 0x43, 0x6f, 0x6c, 0x6c, 0x65, 0x63, 0x74, 0x47, 0x61, 0x72, 0x62, 0x61, 0x67, 0x65,
+	0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
+	0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73,
+	0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65, 0x73,
+	0x22, 0x00, 0x12, 0x49, 0x0a, 0x15, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x42, 0x6c, 0x6f, 0x63,
+	0x6b, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x12, 0x1a, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x4d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74,
+	0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x4a, 0x0a,
+	0x12, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x4d, 0x65, 0x6d, 0x62, 0x65, 0x72, 0x73,
+	0x68, 0x69, 0x70, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1a, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x4d, 0x65, 0x6d,
+	0x62, 0x65, 0x72, 0x73, 0x68, 0x69, 0x70, 0x22, 0x00, 0x12, 0x44, 0x0a, 0x0e, 0x57, 0x61, 0x69,
+	0x74, 0x46, 0x6f, 0x72, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x73, 0x12, 0x17, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x75,
+	0x72, 0x73, 0x6f, 0x72, 0x1a, 0x17, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65,
+	0x2e, 0x43, 0x68, 0x61, 0x6e, 0x67, 0x65, 0x43, 0x75, 0x72, 0x73, 0x6f, 0x72, 0x22, 0x00, 0x12,
+	0x4a, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74,
+	0x61, 0x74, 0x65, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1c, 0x2e, 0x73, 0x75,
+	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x52, 0x61, 0x66, 0x74, 0x49, 0x6e, 0x74, 0x65,
+	0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74, 0x61, 0x74, 0x65, 0x22, 0x00, 0x12, 0x37, 0x0a, 0x07, 0x52,
+	0x65, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
 	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x12,
 	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65,
-	0x73, 0x73, 0x22, 0x00, 0x12, 0x42, 0x0a, 0x0e, 0x47, 0x65, 0x74, 0x46, 0x69, 0x6c, 0x65, 0x49,
-	0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e,
-	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x16,
-	0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x49,
-	0x6e, 0x66, 0x6f, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x3b, 0x0a, 0x0a, 0x55, 0x70, 0x64, 0x61,
-	0x74, 0x65, 0x46, 0x69, 0x6c, 0x65, 0x12, 0x17, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f,
-	0x72, 0x65, 0x2e, 0x46, 0x69, 0x6c, 0x65, 0x4d, 0x65, 0x74, 0x61, 0x44, 0x61, 0x74, 0x61, 0x1a,
-	0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x56, 0x65, 0x72, 0x73,
-	0x69, 0x6f, 0x6e, 0x22, 0x00, 0x12, 0x46, 0x0a, 0x10, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63,
-	0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x12, 0x16, 0x2e, 0x73, 0x75, 0x72, 0x66,
-	0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x48, 0x61, 0x73, 0x68, 0x65,
-	0x73, 0x1a, 0x18, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c,
-	0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x4d, 0x61, 0x70, 0x22, 0x00, 0x12, 0x4a, 0x0a,
-	0x12, 0x47, 0x65, 0x74, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f, 0x72, 0x65, 0x41, 0x64,
-	0x64, 0x72, 0x73, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
-	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1a, 0x2e, 0x73, 0x75,
-	0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x42, 0x6c, 0x6f, 0x63, 0x6b, 0x53, 0x74, 0x6f,
-	0x72, 0x65, 0x41, 0x64, 0x64, 0x72, 0x73, 0x22, 0x00, 0x12, 0x4a, 0x0a, 0x10, 0x47, 0x65, 0x74,
-	0x49, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74, 0x61, 0x74, 0x65, 0x12, 0x16, 0x2e,
+	0x73, 0x73, 0x22, 0x00, 0x12, 0x35, 0x0a, 0x05, 0x43, 0x72, 0x61, 0x73, 0x68, 0x12, 0x16, 0x2e,
 	0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e,
-	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x1c, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72,
-	0x65, 0x2e, 0x52, 0x61, 0x66, 0x74, 0x49, 0x6e, 0x74, 0x65, 0x72, 0x6e, 0x61, 0x6c, 0x53, 0x74,
-	0x61, 0x74, 0x65, 0x22, 0x00, 0x12, 0x37, 0x0a, 0x07, 0x52, 0x65, 0x73, 0x74, 0x6f, 0x72, 0x65,
-	0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62,
-	0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73,
-	0x74, 0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x12, 0x35,
-	0x0a, 0x05, 0x43, 0x72, 0x61, 0x73, 0x68, 0x12, 0x16, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65,
-	0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a,
-	0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x2e, 0x53, 0x75, 0x63, 0x63,
-	0x65, 0x73, 0x73, 0x22, 0x00, 0x42, 0x1c, 0x5a, 0x1a, 0x63, 0x73, 0x65, 0x32, 0x32, 0x34, 0x2f,
-	0x70, 0x72, 0x6f, 0x6a, 0x35, 0x2f, 0x70, 0x6b, 0x67, 0x2f, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74,
-	0x6f, 0x72, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+	0x45, 0x6d, 0x70, 0x74, 0x79, 0x1a, 0x12, 0x2e, 0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72,
+	0x65, 0x2e, 0x53, 0x75, 0x63, 0x63, 0x65, 0x73, 0x73, 0x22, 0x00, 0x42, 0x1c, 0x5a, 0x1a, 0x63,
+	0x73, 0x65, 0x32, 0x32, 0x34, 0x2f, 0x70, 0x72, 0x6f, 0x6a, 0x35, 0x2f, 0x70, 0x6b, 0x67, 0x2f,
+	0x73, 0x75, 0x72, 0x66, 0x73, 0x74, 0x6f, 0x72, 0x65, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f,
+	0x33,
 }
 
 var (
@@ -978,76 +1641,113 @@ func file_SurfStore_proto_rawDescGZIP() []byte {
 	return file_SurfStore_proto_rawDescData
 }
 
-var file_SurfStore_proto_msgTypes = make([]protoimpl.MessageInfo, 16)
+var file_SurfStore_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_SurfStore_proto_msgTypes = make([]protoimpl.MessageInfo, 23)
 var file_SurfStore_proto_goTypes = []interface{}{
-	(*BlockHash)(nil),         // 0: surfstore.BlockHash
-	(*BlockHashes)(nil),       // 1: surfstore.BlockHashes
-	(*Block)(nil),             // 2: surfstore.Block
-	(*Success)(nil),           // 3: surfstore.Success
-	(*FileMetaData)(nil),      // 4: surfstore.FileMetaData
-	(*FileInfoMap)(nil),       // 5: surfstore.FileInfoMap
-	(*Version)(nil),           // 6: surfstore.Version
-	(*BlockStoreMap)(nil),     // 7: surfstore.BlockStoreMap
-	(*BlockStoreAddrs)(nil),   // 8: surfstore.BlockStoreAddrs
-	(*CrashedState)(nil),      // 9: surfstore.CrashedState
-	(*AppendEntryInput)(nil),  // 10: surfstore.AppendEntryInput
-	(*AppendEntryOutput)(nil), // 11: surfstore.AppendEntryOutput
-	(*UpdateOperation)(nil),   // 12: surfstore.UpdateOperation
-	(*RaftInternalState)(nil), // 13: surfstore.RaftInternalState
-	nil,                       // 14: surfstore.FileInfoMap.FileInfoMapEntry
-	nil,                       // 15: surfstore.BlockStoreMap.BlockStoreMapEntry
-	(*emptypb.Empty)(nil),     // 16: google.protobuf.Empty
+	(Codec)(0),                // 0: surfstore.Codec
+	(*BlockHash)(nil),         // 1: surfstore.BlockHash
+	(*BlockHashes)(nil),       // 2: surfstore.BlockHashes
+	(*BlockDeletion)(nil),     // 3: surfstore.BlockDeletion
+	(*Codecs)(nil),            // 4: surfstore.Codecs
+	(*CompressionStats)(nil),  // 5: surfstore.CompressionStats
+	(*BlockStoreStats)(nil),   // 6: surfstore.BlockStoreStats
+	(*Block)(nil),             // 7: surfstore.Block
+	(*Success)(nil),           // 8: surfstore.Success
+	(*FileMetaData)(nil),      // 9: surfstore.FileMetaData
+	(*FileInfoMap)(nil),       // 10: surfstore.FileInfoMap
+	(*Version)(nil),           // 11: surfstore.Version
+	(*BlockStoreMap)(nil),     // 12: surfstore.BlockStoreMap
+	(*BlockStoreAddrs)(nil),   // 13: surfstore.BlockStoreAddrs
+	(*CrashedState)(nil),      // 14: surfstore.CrashedState
+	(*AppendEntryInput)(nil),  // 15: surfstore.AppendEntryInput
+	(*AppendEntryOutput)(nil), // 16: surfstore.AppendEntryOutput
+	(*UpdateOperation)(nil),   // 17: surfstore.UpdateOperation
+	(*BlockServer)(nil),       // 18: surfstore.BlockServer
+	(*BlockMembership)(nil),   // 19: surfstore.BlockMembership
+	(*ChangeCursor)(nil),      // 20: surfstore.ChangeCursor
+	(*RaftInternalState)(nil), // 21: surfstore.RaftInternalState
+	nil,                       // 22: surfstore.FileInfoMap.FileInfoMapEntry
+	nil,                       // 23: surfstore.BlockStoreMap.BlockStoreMapEntry
+	(*emptypb.Empty)(nil),     // 24: google.protobuf.Empty
 }
 var file_SurfStore_proto_depIdxs = []int32{
-	14, // 0: surfstore.FileInfoMap.fileInfoMap:type_name -> surfstore.FileInfoMap.FileInfoMapEntry
-	15, // 1: surfstore.BlockStoreMap.blockStoreMap:type_name -> surfstore.BlockStoreMap.BlockStoreMapEntry
-	12, // 2: surfstore.AppendEntryInput.entries:type_name -> surfstore.UpdateOperation
-	4,  // 3: surfstore.UpdateOperation.fileMetaData:type_name -> surfstore.FileMetaData
-	12, // 4: surfstore.RaftInternalState.log:type_name -> surfstore.UpdateOperation
-	5,  // 5: surfstore.RaftInternalState.metaMap:type_name -> surfstore.FileInfoMap
-	4,  // 6: surfstore.FileInfoMap.FileInfoMapEntry.value:type_name -> surfstore.FileMetaData
-	1,  // 7: surfstore.BlockStoreMap.BlockStoreMapEntry.value:type_name -> surfstore.BlockHashes
-	0,  // 8: surfstore.BlockStore.GetBlock:input_type -> surfstore.BlockHash
-	2,  // 9: surfstore.BlockStore.PutBlock:input_type -> surfstore.Block
-	1,  // 10: surfstore.BlockStore.HasBlocks:input_type -> surfstore.BlockHashes
-	16, // 11: surfstore.BlockStore.GetBlockHashes:input_type -> google.protobuf.Empty
-	16, // 12: surfstore.MetaStore.GetFileInfoMap:input_type -> google.protobuf.Empty
-	4,  // 13: surfstore.MetaStore.UpdateFile:input_type -> surfstore.FileMetaData
-	1,  // 14: surfstore.MetaStore.GetBlockStoreMap:input_type -> surfstore.BlockHashes
-	16, // 15: surfstore.MetaStore.GetBlockStoreAddrs:input_type -> google.protobuf.Empty
-	10, // 16: surfstore.RaftSurfstore.AppendEntries:input_type -> surfstore.AppendEntryInput
-	16, // 17: surfstore.RaftSurfstore.SetLeader:input_type -> google.protobuf.Empty
-	16, // 18: surfstore.RaftSurfstore.SendHeartbeat:input_type -> google.protobuf.Empty
-	16, // 19: surfstore.RaftSurfstore.GetFileInfoMap:input_type -> google.protobuf.Empty
-	4,  // 20: surfstore.RaftSurfstore.UpdateFile:input_type -> surfstore.FileMetaData
-	1,  // 21: surfstore.RaftSurfstore.GetBlockStoreMap:input_type -> surfstore.BlockHashes
-	16, // 22: surfstore.RaftSurfstore.GetBlockStoreAddrs:input_type -> google.protobuf.Empty
-	16, // 23: surfstore.RaftSurfstore.GetInternalState:input_type -> google.protobuf.Empty
-	16, // 24: surfstore.RaftSurfstore.Restore:input_type -> google.protobuf.Empty
-	16, // 25: surfstore.RaftSurfstore.Crash:input_type -> google.protobuf.Empty
-	2,  // 26: surfstore.BlockStore.GetBlock:output_type -> surfstore.Block
-	3,  // 27: surfstore.BlockStore.PutBlock:output_type -> surfstore.Success
-	1,  // 28: surfstore.BlockStore.HasBlocks:output_type -> surfstore.BlockHashes
-	1,  // 29: surfstore.BlockStore.GetBlockHashes:output_type -> surfstore.BlockHashes
-	5,  // 30: surfstore.MetaStore.GetFileInfoMap:output_type -> surfstore.FileInfoMap
-	6,  // 31: surfstore.MetaStore.UpdateFile:output_type -> surfstore.Version
-	7,  // 32: surfstore.MetaStore.GetBlockStoreMap:output_type -> surfstore.BlockStoreMap
-	8,  // 33: surfstore.MetaStore.GetBlockStoreAddrs:output_type -> surfstore.BlockStoreAddrs
-	11, // 34: surfstore.RaftSurfstore.AppendEntries:output_type -> surfstore.AppendEntryOutput
-	3,  // 35: surfstore.RaftSurfstore.SetLeader:output_type -> surfstore.Success
-	3,  // 36: surfstore.RaftSurfstore.SendHeartbeat:output_type -> surfstore.Success
-	5,  // 37: surfstore.RaftSurfstore.GetFileInfoMap:output_type -> surfstore.FileInfoMap
-	6,  // 38: surfstore.RaftSurfstore.UpdateFile:output_type -> surfstore.Version
-	7,  // 39: surfstore.RaftSurfstore.GetBlockStoreMap:output_type -> surfstore.BlockStoreMap
-	8,  // 40: surfstore.RaftSurfstore.GetBlockStoreAddrs:output_type -> surfstore.BlockStoreAddrs
-	13, // 41: surfstore.RaftSurfstore.GetInternalState:output_type -> surfstore.RaftInternalState
-	3,  // 42: surfstore.RaftSurfstore.Restore:output_type -> surfstore.Success
-	3,  // 43: surfstore.RaftSurfstore.Crash:output_type -> surfstore.Success
-	26, // [26:44] is the sub-list for method output_type
-	8,  // [8:26] is the sub-list for method input_type
-	8,  // [8:8] is the sub-list for extension type_name
-	8,  // [8:8] is the sub-list for extension extendee
-	0,  // [0:8] is the sub-list for field type_name
+	0,  // 0: surfstore.Codecs.codecs:type_name -> surfstore.Codec
+	0,  // 1: surfstore.Block.codec:type_name -> surfstore.Codec
+	22, // 2: surfstore.FileInfoMap.fileInfoMap:type_name -> surfstore.FileInfoMap.FileInfoMapEntry
+	23, // 3: surfstore.BlockStoreMap.blockStoreMap:type_name -> surfstore.BlockStoreMap.BlockStoreMapEntry
+	17, // 4: surfstore.AppendEntryInput.entries:type_name -> surfstore.UpdateOperation
+	9,  // 5: surfstore.UpdateOperation.fileMetaData:type_name -> surfstore.FileMetaData
+	19, // 6: surfstore.UpdateOperation.membership:type_name -> surfstore.BlockMembership
+	18, // 7: surfstore.BlockMembership.servers:type_name -> surfstore.BlockServer
+	17, // 8: surfstore.RaftInternalState.log:type_name -> surfstore.UpdateOperation
+	10, // 9: surfstore.RaftInternalState.metaMap:type_name -> surfstore.FileInfoMap
+	9,  // 10: surfstore.FileInfoMap.FileInfoMapEntry.value:type_name -> surfstore.FileMetaData
+	2,  // 11: surfstore.BlockStoreMap.BlockStoreMapEntry.value:type_name -> surfstore.BlockHashes
+	1,  // 12: surfstore.BlockStore.GetBlock:input_type -> surfstore.BlockHash
+	7,  // 13: surfstore.BlockStore.PutBlock:input_type -> surfstore.Block
+	2,  // 14: surfstore.BlockStore.GetBlocks:input_type -> surfstore.BlockHashes
+	7,  // 15: surfstore.BlockStore.PutBlocks:input_type -> surfstore.Block
+	2,  // 16: surfstore.BlockStore.HasBlocks:input_type -> surfstore.BlockHashes
+	24, // 17: surfstore.BlockStore.GetBlockHashes:input_type -> google.protobuf.Empty
+	24, // 18: surfstore.BlockStore.GetQuarantinedBlocks:input_type -> google.protobuf.Empty
+	3,  // 19: surfstore.BlockStore.DeleteBlocks:input_type -> surfstore.BlockDeletion
+	24, // 20: surfstore.BlockStore.GetCodecs:input_type -> google.protobuf.Empty
+	24, // 21: surfstore.BlockStore.GetCompressionStats:input_type -> google.protobuf.Empty
+	24, // 22: surfstore.BlockStore.ReencryptBlocks:input_type -> google.protobuf.Empty
+	24, // 23: surfstore.BlockStore.GetStats:input_type -> google.protobuf.Empty
+	24, // 24: surfstore.MetaStore.GetFileInfoMap:input_type -> google.protobuf.Empty
+	9,  // 25: surfstore.MetaStore.UpdateFile:input_type -> surfstore.FileMetaData
+	2,  // 26: surfstore.MetaStore.GetBlockStoreMap:input_type -> surfstore.BlockHashes
+	24, // 27: surfstore.MetaStore.GetBlockStoreAddrs:input_type -> google.protobuf.Empty
+	15, // 28: surfstore.RaftSurfstore.AppendEntries:input_type -> surfstore.AppendEntryInput
+	24, // 29: surfstore.RaftSurfstore.SetLeader:input_type -> google.protobuf.Empty
+	24, // 30: surfstore.RaftSurfstore.SendHeartbeat:input_type -> google.protobuf.Empty
+	24, // 31: surfstore.RaftSurfstore.GetFileInfoMap:input_type -> google.protobuf.Empty
+	9,  // 32: surfstore.RaftSurfstore.UpdateFile:input_type -> surfstore.FileMetaData
+	2,  // 33: surfstore.RaftSurfstore.GetBlockStoreMap:input_type -> surfstore.BlockHashes
+	24, // 34: surfstore.RaftSurfstore.GetBlockStoreAddrs:input_type -> google.protobuf.Empty
+	24, // 35: surfstore.RaftSurfstore.CollectGarbage:input_type -> google.protobuf.Empty
+	19, // 36: surfstore.RaftSurfstore.ChangeBlockMembership:input_type -> surfstore.BlockMembership
+	24, // 37: surfstore.RaftSurfstore.GetBlockMembership:input_type -> google.protobuf.Empty
+	20, // 38: surfstore.RaftSurfstore.WaitForChanges:input_type -> surfstore.ChangeCursor
+	24, // 39: surfstore.RaftSurfstore.GetInternalState:input_type -> google.protobuf.Empty
+	24, // 40: surfstore.RaftSurfstore.Restore:input_type -> google.protobuf.Empty
+	24, // 41: surfstore.RaftSurfstore.Crash:input_type -> google.protobuf.Empty
+	7,  // 42: surfstore.BlockStore.GetBlock:output_type -> surfstore.Block
+	8,  // 43: surfstore.BlockStore.PutBlock:output_type -> surfstore.Success
+	7,  // 44: surfstore.BlockStore.GetBlocks:output_type -> surfstore.Block
+	8,  // 45: surfstore.BlockStore.PutBlocks:output_type -> surfstore.Success
+	2,  // 46: surfstore.BlockStore.HasBlocks:output_type -> surfstore.BlockHashes
+	2,  // 47: surfstore.BlockStore.GetBlockHashes:output_type -> surfstore.BlockHashes
+	2,  // 48: surfstore.BlockStore.GetQuarantinedBlocks:output_type -> surfstore.BlockHashes
+	2,  // 49: surfstore.BlockStore.DeleteBlocks:output_type -> surfstore.BlockHashes
+	4,  // 50: surfstore.BlockStore.GetCodecs:output_type -> surfstore.Codecs
+	5,  // 51: surfstore.BlockStore.GetCompressionStats:output_type -> surfstore.CompressionStats
+	2,  // 52: surfstore.BlockStore.ReencryptBlocks:output_type -> surfstore.BlockHashes
+	6,  // 53: surfstore.BlockStore.GetStats:output_type -> surfstore.BlockStoreStats
+	10, // 54: surfstore.MetaStore.GetFileInfoMap:output_type -> surfstore.FileInfoMap
+	11, // 55: surfstore.MetaStore.UpdateFile:output_type -> surfstore.Version
+	12, // 56: surfstore.MetaStore.GetBlockStoreMap:output_type -> surfstore.BlockStoreMap
+	13, // 57: surfstore.MetaStore.GetBlockStoreAddrs:output_type -> surfstore.BlockStoreAddrs
+	16, // 58: surfstore.RaftSurfstore.AppendEntries:output_type -> surfstore.AppendEntryOutput
+	8,  // 59: surfstore.RaftSurfstore.SetLeader:output_type -> surfstore.Success
+	8,  // 60: surfstore.RaftSurfstore.SendHeartbeat:output_type -> surfstore.Success
+	10, // 61: surfstore.RaftSurfstore.GetFileInfoMap:output_type -> surfstore.FileInfoMap
+	11, // 62: surfstore.RaftSurfstore.UpdateFile:output_type -> surfstore.Version
+	12, // 63: surfstore.RaftSurfstore.GetBlockStoreMap:output_type -> surfstore.BlockStoreMap
+	13, // 64: surfstore.RaftSurfstore.GetBlockStoreAddrs:output_type -> surfstore.BlockStoreAddrs
+	2,  // 65: surfstore.RaftSurfstore.CollectGarbage:output_type -> surfstore.BlockHashes
+	8,  // 66: surfstore.RaftSurfstore.ChangeBlockMembership:output_type -> surfstore.Success
+	19, // 67: surfstore.RaftSurfstore.GetBlockMembership:output_type -> surfstore.BlockMembership
+	20, // 68: surfstore.RaftSurfstore.WaitForChanges:output_type -> surfstore.ChangeCursor
+	21, // 69: surfstore.RaftSurfstore.GetInternalState:output_type -> surfstore.RaftInternalState
+	8,  // 70: surfstore.RaftSurfstore.Restore:output_type -> surfstore.Success
+	8,  // 71: surfstore.RaftSurfstore.Crash:output_type -> surfstore.Success
+	42, // [42:72] is the sub-list for method output_type
+	12, // [12:42] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
 }
 
 func init() { file_SurfStore_proto_init() }
@@ -1081,7 +1781,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Block); i {
+			switch v := v.(*BlockDeletion); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1093,7 +1793,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Success); i {
+			switch v := v.(*Codecs); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1105,7 +1805,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FileMetaData); i {
+			switch v := v.(*CompressionStats); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1117,7 +1817,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*FileInfoMap); i {
+			switch v := v.(*BlockStoreStats); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1129,7 +1829,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*Version); i {
+			switch v := v.(*Block); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1141,7 +1841,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[7].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*BlockStoreMap); i {
+			switch v := v.(*Success); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1153,7 +1853,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[8].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*BlockStoreAddrs); i {
+			switch v := v.(*FileMetaData); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1165,7 +1865,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[9].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*CrashedState); i {
+			switch v := v.(*FileInfoMap); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1177,7 +1877,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[10].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*AppendEntryInput); i {
+			switch v := v.(*Version); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1189,7 +1889,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[11].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*AppendEntryOutput); i {
+			switch v := v.(*BlockStoreMap); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1201,7 +1901,7 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[12].Exporter = func(v interface{}, i int) interface{} {
-			switch v := v.(*UpdateOperation); i {
+			switch v := v.(*BlockStoreAddrs); i {
 			case 0:
 				return &v.state
 			case 1:
@@ -1213,6 +1913,90 @@ func file_SurfStore_proto_init() {
 			}
 		}
 		file_SurfStore_proto_msgTypes[13].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*CrashedState); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[14].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AppendEntryInput); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[15].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*AppendEntryOutput); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[16].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*UpdateOperation); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[17].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlockServer); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[18].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*BlockMembership); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[19].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*ChangeCursor); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_SurfStore_proto_msgTypes[20].Exporter = func(v interface{}, i int) interface{} {
 			switch v := v.(*RaftInternalState); i {
 			case 0:
 				return &v.state
@@ -1230,13 +2014,14 @@ func file_SurfStore_proto_init() {
 		File: protoimpl.DescBuilder{
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: file_SurfStore_proto_rawDesc,
-			NumEnums:      0,
-			NumMessages:   16,
+			NumEnums:      1,
+			NumMessages:   23,
 			NumExtensions: 0,
 			NumServices:   3,
 		},
 		GoTypes:           file_SurfStore_proto_goTypes,
 		DependencyIndexes: file_SurfStore_proto_depIdxs,
+		EnumInfos:         file_SurfStore_proto_enumTypes,
 		MessageInfos:      file_SurfStore_proto_msgTypes,
 	}.Build()
 	File_SurfStore_proto = out.File
@@ -1,9 +1,24 @@
 package surfstore
 
+import "time"
+
 const DEFAULT_META_FILENAME string = "index.db"
 
+// Progress of an unfinished sync, see SyncJournal
+const DEFAULT_JOURNAL_FILENAME string = ".surfjournal.db"
+
+// Downloads are written to a temporary file starting with this, then renamed
+const DOWNLOAD_TEMP_PREFIX string = ".surfdownload-"
+
+// DiskBlockStorage writes blocks to a temporary file starting with this, then renames it
+const BLOCK_TEMP_PREFIX string = ".tmp-"
+
+// Ignore rules for the directory it is in and those below, see IgnoreRules
+const IGNORE_FILENAME string = ".surfignore"
+
 const TOMBSTONE_HASHVALUE string = "0"
 const EMPTYFILE_HASHVALUE string = "-1"
+const DIRECTORY_HASHVALUE string = "-2" // hash list of a directory, whose key ends in a slash
 
 const FILENAME_INDEX int = 0
 const VERSION_INDEX int = 1
@@ -11,3 +26,11 @@ const HASH_LIST_INDEX int = 2
 
 const CONFIG_DELIMITER string = ","
 const HASH_DELIMITER string = " "
+
+// Watch mode syncs once local changes have been quiet for DEFAULT_WATCH_DEBOUNCE,
+// or DEFAULT_WATCH_MAX_DELAY after the first of a steady stream of them
+const DEFAULT_WATCH_DEBOUNCE time.Duration = 500 * time.Millisecond
+const DEFAULT_WATCH_MAX_DELAY time.Duration = 5 * time.Second
+
+// How often watch mode scans the base directory when inotify is unavailable
+const DEFAULT_WATCH_POLL_INTERVAL time.Duration = 2 * time.Second
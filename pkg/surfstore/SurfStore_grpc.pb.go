@@ -25,8 +25,16 @@ const _ = grpc.SupportPackageIsVersion7
 type BlockStoreClient interface {
 	GetBlock(ctx context.Context, in *BlockHash, opts ...grpc.CallOption) (*Block, error)
 	PutBlock(ctx context.Context, in *Block, opts ...grpc.CallOption) (*Success, error)
+	GetBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (BlockStore_GetBlocksClient, error)
+	PutBlocks(ctx context.Context, opts ...grpc.CallOption) (BlockStore_PutBlocksClient, error)
 	HasBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockHashes, error)
 	GetBlockHashes(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error)
+	GetQuarantinedBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error)
+	DeleteBlocks(ctx context.Context, in *BlockDeletion, opts ...grpc.CallOption) (*BlockHashes, error)
+	GetCodecs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Codecs, error)
+	GetCompressionStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CompressionStats, error)
+	ReencryptBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error)
+	GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockStoreStats, error)
 }
 
 type blockStoreClient struct {
@@ -55,6 +63,72 @@ func (c *blockStoreClient) PutBlock(ctx context.Context, in *Block, opts ...grpc
 	return out, nil
 }
 
+func (c *blockStoreClient) GetBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (BlockStore_GetBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BlockStore_ServiceDesc.Streams[0], "/surfstore.BlockStore/GetBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blockStoreGetBlocksClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type BlockStore_GetBlocksClient interface {
+	Recv() (*Block, error)
+	grpc.ClientStream
+}
+
+type blockStoreGetBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *blockStoreGetBlocksClient) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *blockStoreClient) PutBlocks(ctx context.Context, opts ...grpc.CallOption) (BlockStore_PutBlocksClient, error) {
+	stream, err := c.cc.NewStream(ctx, &BlockStore_ServiceDesc.Streams[1], "/surfstore.BlockStore/PutBlocks", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &blockStorePutBlocksClient{stream}
+	return x, nil
+}
+
+type BlockStore_PutBlocksClient interface {
+	Send(*Block) error
+	CloseAndRecv() (*Success, error)
+	grpc.ClientStream
+}
+
+type blockStorePutBlocksClient struct {
+	grpc.ClientStream
+}
+
+func (x *blockStorePutBlocksClient) Send(m *Block) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *blockStorePutBlocksClient) CloseAndRecv() (*Success, error) {
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	m := new(Success)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func (c *blockStoreClient) HasBlocks(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockHashes, error) {
 	out := new(BlockHashes)
 	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/HasBlocks", in, out, opts...)
@@ -73,14 +147,76 @@ func (c *blockStoreClient) GetBlockHashes(ctx context.Context, in *emptypb.Empty
 	return out, nil
 }
 
+func (c *blockStoreClient) GetQuarantinedBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/GetQuarantinedBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) DeleteBlocks(ctx context.Context, in *BlockDeletion, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/DeleteBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) GetCodecs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Codecs, error) {
+	out := new(Codecs)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/GetCodecs", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) GetCompressionStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*CompressionStats, error) {
+	out := new(CompressionStats)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/GetCompressionStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) ReencryptBlocks(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/ReencryptBlocks", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *blockStoreClient) GetStats(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockStoreStats, error) {
+	out := new(BlockStoreStats)
+	err := c.cc.Invoke(ctx, "/surfstore.BlockStore/GetStats", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // BlockStoreServer is the server API for BlockStore service.
 // All implementations must embed UnimplementedBlockStoreServer
 // for forward compatibility
 type BlockStoreServer interface {
 	GetBlock(context.Context, *BlockHash) (*Block, error)
 	PutBlock(context.Context, *Block) (*Success, error)
+	GetBlocks(*BlockHashes, BlockStore_GetBlocksServer) error
+	PutBlocks(BlockStore_PutBlocksServer) error
 	HasBlocks(context.Context, *BlockHashes) (*BlockHashes, error)
 	GetBlockHashes(context.Context, *emptypb.Empty) (*BlockHashes, error)
+	GetQuarantinedBlocks(context.Context, *emptypb.Empty) (*BlockHashes, error)
+	DeleteBlocks(context.Context, *BlockDeletion) (*BlockHashes, error)
+	GetCodecs(context.Context, *emptypb.Empty) (*Codecs, error)
+	GetCompressionStats(context.Context, *emptypb.Empty) (*CompressionStats, error)
+	ReencryptBlocks(context.Context, *emptypb.Empty) (*BlockHashes, error)
+	GetStats(context.Context, *emptypb.Empty) (*BlockStoreStats, error)
 	mustEmbedUnimplementedBlockStoreServer()
 }
 
@@ -94,12 +230,36 @@ func (UnimplementedBlockStoreServer) GetBlock(context.Context, *BlockHash) (*Blo
 func (UnimplementedBlockStoreServer) PutBlock(context.Context, *Block) (*Success, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method PutBlock not implemented")
 }
+func (UnimplementedBlockStoreServer) GetBlocks(*BlockHashes, BlockStore_GetBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method GetBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) PutBlocks(BlockStore_PutBlocksServer) error {
+	return status.Errorf(codes.Unimplemented, "method PutBlocks not implemented")
+}
 func (UnimplementedBlockStoreServer) HasBlocks(context.Context, *BlockHashes) (*BlockHashes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method HasBlocks not implemented")
 }
 func (UnimplementedBlockStoreServer) GetBlockHashes(context.Context, *emptypb.Empty) (*BlockHashes, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlockHashes not implemented")
 }
+func (UnimplementedBlockStoreServer) GetQuarantinedBlocks(context.Context, *emptypb.Empty) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetQuarantinedBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) DeleteBlocks(context.Context, *BlockDeletion) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method DeleteBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) GetCodecs(context.Context, *emptypb.Empty) (*Codecs, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCodecs not implemented")
+}
+func (UnimplementedBlockStoreServer) GetCompressionStats(context.Context, *emptypb.Empty) (*CompressionStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetCompressionStats not implemented")
+}
+func (UnimplementedBlockStoreServer) ReencryptBlocks(context.Context, *emptypb.Empty) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ReencryptBlocks not implemented")
+}
+func (UnimplementedBlockStoreServer) GetStats(context.Context, *emptypb.Empty) (*BlockStoreStats, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStats not implemented")
+}
 func (UnimplementedBlockStoreServer) mustEmbedUnimplementedBlockStoreServer() {}
 
 // UnsafeBlockStoreServer may be embedded to opt out of forward compatibility for this service.
@@ -149,6 +309,53 @@ func _BlockStore_PutBlock_Handler(srv interface{}, ctx context.Context, dec func
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BlockStore_GetBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(BlockHashes)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(BlockStoreServer).GetBlocks(m, &blockStoreGetBlocksServer{stream})
+}
+
+type BlockStore_GetBlocksServer interface {
+	Send(*Block) error
+	grpc.ServerStream
+}
+
+type blockStoreGetBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *blockStoreGetBlocksServer) Send(m *Block) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func _BlockStore_PutBlocks_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(BlockStoreServer).PutBlocks(&blockStorePutBlocksServer{stream})
+}
+
+type BlockStore_PutBlocksServer interface {
+	SendAndClose(*Success) error
+	Recv() (*Block, error)
+	grpc.ServerStream
+}
+
+type blockStorePutBlocksServer struct {
+	grpc.ServerStream
+}
+
+func (x *blockStorePutBlocksServer) SendAndClose(m *Success) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *blockStorePutBlocksServer) Recv() (*Block, error) {
+	m := new(Block)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 func _BlockStore_HasBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(BlockHashes)
 	if err := dec(in); err != nil {
@@ -185,6 +392,114 @@ func _BlockStore_GetBlockHashes_Handler(srv interface{}, ctx context.Context, de
 	return interceptor(ctx, in, info, handler)
 }
 
+func _BlockStore_GetQuarantinedBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).GetQuarantinedBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/GetQuarantinedBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).GetQuarantinedBlocks(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_DeleteBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockDeletion)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).DeleteBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/DeleteBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).DeleteBlocks(ctx, req.(*BlockDeletion))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_GetCodecs_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).GetCodecs(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/GetCodecs",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).GetCodecs(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_GetCompressionStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).GetCompressionStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/GetCompressionStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).GetCompressionStats(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_ReencryptBlocks_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).ReencryptBlocks(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/ReencryptBlocks",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).ReencryptBlocks(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _BlockStore_GetStats_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(BlockStoreServer).GetStats(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.BlockStore/GetStats",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(BlockStoreServer).GetStats(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // BlockStore_ServiceDesc is the grpc.ServiceDesc for BlockStore service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -208,8 +523,43 @@ var BlockStore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlockHashes",
 			Handler:    _BlockStore_GetBlockHashes_Handler,
 		},
+		{
+			MethodName: "GetQuarantinedBlocks",
+			Handler:    _BlockStore_GetQuarantinedBlocks_Handler,
+		},
+		{
+			MethodName: "DeleteBlocks",
+			Handler:    _BlockStore_DeleteBlocks_Handler,
+		},
+		{
+			MethodName: "GetCodecs",
+			Handler:    _BlockStore_GetCodecs_Handler,
+		},
+		{
+			MethodName: "GetCompressionStats",
+			Handler:    _BlockStore_GetCompressionStats_Handler,
+		},
+		{
+			MethodName: "ReencryptBlocks",
+			Handler:    _BlockStore_ReencryptBlocks_Handler,
+		},
+		{
+			MethodName: "GetStats",
+			Handler:    _BlockStore_GetStats_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "GetBlocks",
+			Handler:       _BlockStore_GetBlocks_Handler,
+			ServerStreams: true,
+		},
+		{
+			StreamName:    "PutBlocks",
+			Handler:       _BlockStore_PutBlocks_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "SurfStore.proto",
 }
 
@@ -420,6 +770,13 @@ type RaftSurfstoreClient interface {
 	UpdateFile(ctx context.Context, in *FileMetaData, opts ...grpc.CallOption) (*Version, error)
 	GetBlockStoreMap(ctx context.Context, in *BlockHashes, opts ...grpc.CallOption) (*BlockStoreMap, error)
 	GetBlockStoreAddrs(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockStoreAddrs, error)
+	// garbage collection
+	CollectGarbage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error)
+	// block server membership
+	ChangeBlockMembership(ctx context.Context, in *BlockMembership, opts ...grpc.CallOption) (*Success, error)
+	GetBlockMembership(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockMembership, error)
+	// change notification
+	WaitForChanges(ctx context.Context, in *ChangeCursor, opts ...grpc.CallOption) (*ChangeCursor, error)
 	// testing interface
 	GetInternalState(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RaftInternalState, error)
 	Restore(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*Success, error)
@@ -497,6 +854,42 @@ func (c *raftSurfstoreClient) GetBlockStoreAddrs(ctx context.Context, in *emptyp
 	return out, nil
 }
 
+func (c *raftSurfstoreClient) CollectGarbage(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockHashes, error) {
+	out := new(BlockHashes)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/CollectGarbage", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) ChangeBlockMembership(ctx context.Context, in *BlockMembership, opts ...grpc.CallOption) (*Success, error) {
+	out := new(Success)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/ChangeBlockMembership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) GetBlockMembership(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*BlockMembership, error) {
+	out := new(BlockMembership)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetBlockMembership", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *raftSurfstoreClient) WaitForChanges(ctx context.Context, in *ChangeCursor, opts ...grpc.CallOption) (*ChangeCursor, error) {
+	out := new(ChangeCursor)
+	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/WaitForChanges", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 func (c *raftSurfstoreClient) GetInternalState(ctx context.Context, in *emptypb.Empty, opts ...grpc.CallOption) (*RaftInternalState, error) {
 	out := new(RaftInternalState)
 	err := c.cc.Invoke(ctx, "/surfstore.RaftSurfstore/GetInternalState", in, out, opts...)
@@ -537,6 +930,13 @@ type RaftSurfstoreServer interface {
 	UpdateFile(context.Context, *FileMetaData) (*Version, error)
 	GetBlockStoreMap(context.Context, *BlockHashes) (*BlockStoreMap, error)
 	GetBlockStoreAddrs(context.Context, *emptypb.Empty) (*BlockStoreAddrs, error)
+	// garbage collection
+	CollectGarbage(context.Context, *emptypb.Empty) (*BlockHashes, error)
+	// block server membership
+	ChangeBlockMembership(context.Context, *BlockMembership) (*Success, error)
+	GetBlockMembership(context.Context, *emptypb.Empty) (*BlockMembership, error)
+	// change notification
+	WaitForChanges(context.Context, *ChangeCursor) (*ChangeCursor, error)
 	// testing interface
 	GetInternalState(context.Context, *emptypb.Empty) (*RaftInternalState, error)
 	Restore(context.Context, *emptypb.Empty) (*Success, error)
@@ -569,6 +969,18 @@ func (UnimplementedRaftSurfstoreServer) GetBlockStoreMap(context.Context, *Block
 func (UnimplementedRaftSurfstoreServer) GetBlockStoreAddrs(context.Context, *emptypb.Empty) (*BlockStoreAddrs, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetBlockStoreAddrs not implemented")
 }
+func (UnimplementedRaftSurfstoreServer) CollectGarbage(context.Context, *emptypb.Empty) (*BlockHashes, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method CollectGarbage not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) ChangeBlockMembership(context.Context, *BlockMembership) (*Success, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ChangeBlockMembership not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) GetBlockMembership(context.Context, *emptypb.Empty) (*BlockMembership, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBlockMembership not implemented")
+}
+func (UnimplementedRaftSurfstoreServer) WaitForChanges(context.Context, *ChangeCursor) (*ChangeCursor, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method WaitForChanges not implemented")
+}
 func (UnimplementedRaftSurfstoreServer) GetInternalState(context.Context, *emptypb.Empty) (*RaftInternalState, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method GetInternalState not implemented")
 }
@@ -717,6 +1129,78 @@ func _RaftSurfstore_GetBlockStoreAddrs_Handler(srv interface{}, ctx context.Cont
 	return interceptor(ctx, in, info, handler)
 }
 
+func _RaftSurfstore_CollectGarbage_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).CollectGarbage(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/CollectGarbage",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).CollectGarbage(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_ChangeBlockMembership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(BlockMembership)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).ChangeBlockMembership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/ChangeBlockMembership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).ChangeBlockMembership(ctx, req.(*BlockMembership))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_GetBlockMembership_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(emptypb.Empty)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).GetBlockMembership(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/GetBlockMembership",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).GetBlockMembership(ctx, req.(*emptypb.Empty))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _RaftSurfstore_WaitForChanges_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ChangeCursor)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(RaftSurfstoreServer).WaitForChanges(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/surfstore.RaftSurfstore/WaitForChanges",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(RaftSurfstoreServer).WaitForChanges(ctx, req.(*ChangeCursor))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 func _RaftSurfstore_GetInternalState_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
 	in := new(emptypb.Empty)
 	if err := dec(in); err != nil {
@@ -806,6 +1290,22 @@ var RaftSurfstore_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "GetBlockStoreAddrs",
 			Handler:    _RaftSurfstore_GetBlockStoreAddrs_Handler,
 		},
+		{
+			MethodName: "CollectGarbage",
+			Handler:    _RaftSurfstore_CollectGarbage_Handler,
+		},
+		{
+			MethodName: "ChangeBlockMembership",
+			Handler:    _RaftSurfstore_ChangeBlockMembership_Handler,
+		},
+		{
+			MethodName: "GetBlockMembership",
+			Handler:    _RaftSurfstore_GetBlockMembership_Handler,
+		},
+		{
+			MethodName: "WaitForChanges",
+			Handler:    _RaftSurfstore_WaitForChanges_Handler,
+		},
 		{
 			MethodName: "GetInternalState",
 			Handler:    _RaftSurfstore_GetInternalState_Handler,
@@ -0,0 +1,110 @@
+package surfstore
+
+import (
+	context "context"
+	"fmt"
+	"log"
+	"os"
+	pathpkg "path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// What a sync does with local edits to a file that also changed on the server
+// since the last sync: overwrite replaces them with the server's version, copy
+// first keeps them as a conflicted copy next to the file, uploaded as a new file,
+// and merge merges text files with the server's version, using the version in
+// index.db as the base, and falls back to copy.
+const CONFLICT_OVERWRITE string = "overwrite"
+const CONFLICT_COPY string = "copy"
+const CONFLICT_MERGE string = "merge"
+
+// IsConflictPolicy checks whether policy names a known conflict policy, where
+// "" is overwrite
+func IsConflictPolicy(policy string) bool {
+	return policy == "" || policy == CONFLICT_OVERWRITE || policy == CONFLICT_COPY || policy == CONFLICT_MERGE
+}
+
+// Whether the policy keeps local edits that conflict with the server
+func keepsLocalEdits(policy string) bool {
+	return policy == CONFLICT_COPY || policy == CONFLICT_MERGE
+}
+
+// Merge the local edits of filename if the policy allows and they merge
+// cleanly, otherwise keep them as a conflicted copy
+func resolveConflict(path string, filename string, chunker Chunker, localHashes []string, base *FileMetaData, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, stats *CompressionStats, localFileMetaMap map[string]*FileMetaData, conflictCopies map[string]*FileMetaData, summary *SyncSummary) {
+	if client.ConflictPolicy == CONFLICT_MERGE && mergeConflict(path, filename, chunker, localHashes, base, blockStoreMap, client, ctx, stats, localFileMetaMap) {
+		summary.Merged = append(summary.Merged, filename)
+		return
+	}
+	summary.Conflicts = append(summary.Conflicts, keepConflictedCopy(path, filename, chunker, localHashes, blockStoreMap, client, ctx, stats, localFileMetaMap, conflictCopies))
+}
+
+// A file whose local edits were kept as a conflicted copy
+type ConflictCopy struct {
+	Filename string
+	CopyName string
+}
+
+// The key of a conflicted copy of filename, "name (conflicted copy from <host>
+// <time>).ext" in the same directory, numbered if that is taken
+func getConflictCopyName(filename string, host string, now time.Time, taken func(string) bool) string {
+	dir, base := pathpkg.Split(filename)
+	ext := pathpkg.Ext(base)
+	if ext == base { // a dotfile has no extension
+		ext = ""
+	}
+	stem := strings.TrimSuffix(base, ext)
+
+	label := fmt.Sprintf("conflicted copy from %s %s", host, now.Format("2006-01-02 150405"))
+	copyName := fmt.Sprintf("%s%s (%s)%s", dir, stem, label, ext)
+	for n := 2; taken(copyName); n++ {
+		copyName = fmt.Sprintf("%s%s (%s %d)%s", dir, stem, label, n, ext)
+	}
+	return copyName
+}
+
+// Move the local edits of filename to a conflicted copy, upload the copy as a
+// new file and bring filename up to date with the server. The copy's metadata
+// is added to conflictCopies, and filename's to localFileMetaMap.
+func keepConflictedCopy(path string, filename string, chunker Chunker, localHashes []string, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, stats *CompressionStats, localFileMetaMap map[string]*FileMetaData, conflictCopies map[string]*FileMetaData) ConflictCopy {
+	// the server's version may be newer than the one this sync started with
+	remoteFileMetaMap := make(map[string]*FileMetaData)
+	checkError(client.GetFileInfoMap(&remoteFileMetaMap))
+
+	host, err := os.Hostname()
+	if err != nil || host == "" {
+		host = "unknown host"
+	}
+	copyName := getConflictCopyName(filename, host, time.Now(), func(name string) bool {
+		_, remoteTaken := remoteFileMetaMap[name]
+		_, localTaken := localFileMetaMap[name]
+		_, copyTaken := conflictCopies[name]
+		_, statErr := os.Lstat(filepath.Join(filepath.Dir(path), pathpkg.Base(name)))
+		return remoteTaken || localTaken || copyTaken || statErr == nil
+	})
+	copyPath := filepath.Join(filepath.Dir(path), pathpkg.Base(copyName))
+	checkError(os.Rename(path, copyPath))
+
+	// hashed again, as the blocks are read from the copy
+	localHashes = getLocalHashes(copyPath, client, chunker)
+	if err := uploadBlocks(copyName, localHashes, localFileBlocks(copyPath, chunker, client), blockStoreMap, client, ctx, stats); err != nil {
+		log.Fatalf("Errored uploading blocks: %s\n", err.Error())
+	}
+	copyMetadata := &FileMetaData{Filename: copyName, Version: 1, BlockHashList: localHashes, Chunker: chunker.Spec()}
+	returnVersion := copyMetadata.Version
+	checkError(client.UpdateFile(copyMetadata, &returnVersion))
+	if returnVersion != -1 { // otherwise the next sync sees the copy as a new file
+		client.Journal.Committed(copyMetadata)
+		conflictCopies[copyName] = copyMetadata
+	}
+
+	remoteMetaData := remoteFileMetaMap[filename]
+	if remoteMetaData != nil && (wasDeleted(remoteMetaData) ||
+		bringLocalFileUpToDateWithRemote(path, getFileChunker(client, remoteMetaData), blockStoreMap, client, ctx, remoteMetaData, []string{})) {
+		localFileMetaMap[filename] = remoteMetaData
+	}
+	log.Printf("Kept local edits of %s as %s\n", filename, copyName)
+	return ConflictCopy{Filename: filename, CopyName: copyName}
+}
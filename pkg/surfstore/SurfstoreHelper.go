@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"fmt"
 	"log"
+	"net/url"
 	"os"
 	"path/filepath"
 
@@ -41,11 +42,22 @@ const createTable string = `create table if not exists indexes (
 		fileName TEXT, 
 		version INT,
 		hashIndex INT,
-		hashValue TEXT
+		hashValue TEXT,
+		size INT,
+		mtime INT,
+		inode INT
 	);`
 
 const insertTuple string = `insert into indexes (fileName, version, hashIndex, hashValue) VALUES (?,?,?,?);`
 
+// files not split into fixed BlockSize chunks have a row here
+const createChunkerTable string = `create table if not exists chunkers (
+		fileName TEXT,
+		chunker TEXT
+	);`
+
+const insertChunker string = `insert into chunkers (fileName, chunker) VALUES (?,?);`
+
 // WriteMetaFile writes the file meta map back to local metadata file index.db
 func WriteMetaFile(fileMetas map[string]*FileMetaData, baseDir string) error {
 	// remove index.db file if it exists
@@ -75,6 +87,21 @@ func WriteMetaFile(fileMetas map[string]*FileMetaData, baseDir string) error {
 			idx += 1
 		}
 	}
+	if err != nil {
+		return err
+	}
+
+	statement, err = db.Prepare(createChunkerTable)
+	if err != nil {
+		return err
+	}
+	statement.Exec()
+	statement, err = db.Prepare(insertChunker)
+	for fileName, metadata := range fileMetas {
+		if metadata.Chunker != "" {
+			statement.Exec(fileName, metadata.Chunker)
+		}
+	}
 
 	return err
 }
@@ -88,6 +115,7 @@ const getTuplesByFileName string = ``
 
 const getUniqueFilenames = "select fileName, version from indexes order by fileName;"
 const getHashListForFile = "select hashValue from indexes where fileName = ? AND version = ? order by hashIndex;"
+const getChunkers = "select fileName, chunker from chunkers;"
 
 // LoadMetaFromMetaFile loads the local metadata file into a file meta map.
 // The key is the file's name and the value is the file's metadata.
@@ -99,17 +127,16 @@ func LoadMetaFromMetaFile(baseDir string) (fileMetaMap map[string]*FileMetaData,
 	if e != nil || metaFileStats.IsDir() {
 		return fileMetaMap, nil
 	}
-	db, err := sql.Open("sqlite3", metaFilePath)
+	db, err := openReadOnly(metaFilePath)
 	if err != nil {
 		log.Fatal("Error When Opening Meta")
 	}
+	defer db.Close()
 
-	// create the table if need be
-	statement, err := db.Prepare(createTable)
-	if err != nil {
-		fmt.Println(err.Error())
+	// an index.db without the table has no files yet
+	if found, err := hasTable(db, "indexes"); err != nil || !found {
+		return fileMetaMap, err
 	}
-	statement.Exec()
 
 	// retrieve all cse courses tuples in ascending order by code.
 	rows, err := db.Query(getUniqueFilenames)
@@ -138,9 +165,184 @@ func LoadMetaFromMetaFile(baseDir string) (fileMetaMap map[string]*FileMetaData,
 		}
 		fileMetaMap[uniqueFilename] = &fileMetaData
 	}
+
+	// index.db files written before chunkers were recorded only have fixed chunks
+	if found, err := hasTable(db, "chunkers"); err != nil || !found {
+		return fileMetaMap, err
+	}
+	chunkerRows, err := db.Query(getChunkers)
+	if err != nil {
+		fmt.Println(err.Error())
+	}
+	var chunker string
+	for chunkerRows.Next() {
+		chunkerRows.Scan(&uniqueFilename, &chunker)
+		if fileMetaData, ok := fileMetaMap[uniqueFilename]; ok {
+			fileMetaData.Chunker = chunker
+		}
+	}
 	return fileMetaMap, err
 }
 
+// the selected directories, and the tracked files not on disk, see SelectiveSync
+const createSelectionTable string = `create table if not exists selections (
+		prefix TEXT
+	);`
+const createUnmaterializedTable string = `create table if not exists unmaterialized (
+		fileName TEXT
+	);`
+
+const insertSelection string = `insert into selections (prefix) VALUES (?);`
+const insertUnmaterialized string = `insert into unmaterialized (fileName) VALUES (?);`
+const getSelections = "select prefix from selections order by prefix;"
+const getUnmaterialized = "select fileName from unmaterialized;"
+
+// WriteSelectiveSync records the selection in index.db, after WriteMetaFile
+func WriteSelectiveSync(selection *SelectiveSync, baseDir string) error {
+	db, err := sql.Open("sqlite3", ConcatPath(baseDir, DEFAULT_META_FILENAME))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	for _, query := range []string{createSelectionTable, createUnmaterializedTable, "delete from selections;", "delete from unmaterialized;"} {
+		if _, err := db.Exec(query); err != nil {
+			return err
+		}
+	}
+	for _, prefix := range selection.Prefixes {
+		if _, err := db.Exec(insertSelection, prefix); err != nil {
+			return err
+		}
+	}
+	for fileName := range selection.Unmaterialized {
+		if _, err := db.Exec(insertUnmaterialized, fileName); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// LoadSelectiveSync reads the selection from index.db, which selects
+// everything if index.db or the tables are missing
+func LoadSelectiveSync(baseDir string) (*SelectiveSync, error) {
+	selection := &SelectiveSync{Prefixes: make([]string, 0), Unmaterialized: make(map[string]bool)}
+	metaFilePath, _ := filepath.Abs(ConcatPath(baseDir, DEFAULT_META_FILENAME))
+	if metaFileStats, err := os.Stat(metaFilePath); err != nil || metaFileStats.IsDir() {
+		return selection, nil
+	}
+	db, err := openReadOnly(metaFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	for _, table := range []string{"selections", "unmaterialized"} {
+		if found, err := hasTable(db, table); err != nil || !found {
+			return selection, err
+		}
+	}
+	rows, err := db.Query(getSelections)
+	if err != nil {
+		return nil, err
+	}
+	var value string
+	for rows.Next() {
+		rows.Scan(&value)
+		selection.Prefixes = append(selection.Prefixes, value)
+	}
+	rows.Close()
+
+	rows, err = db.Query(getUnmaterialized)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		rows.Scan(&value)
+		selection.Unmaterialized[value] = true
+	}
+	return selection, nil
+}
+
+// the stat of each file when it was hashed, see FileStat. index.db files
+// written before stats were recorded lack the columns until rewritten.
+var fileStatColumns = []string{"size", "mtime", "inode"}
+
+const getIndexColumns = "select name from pragma_table_info('indexes');"
+const getFileStats = "select distinct fileName, size, mtime, inode from indexes where size is not null;"
+const updateFileStat = "update indexes set size = ?, mtime = ?, inode = ? where fileName = ?;"
+
+// WriteFileStats records the stats of the files in index.db, after WriteMetaFile
+func WriteFileStats(fileStats map[string]FileStat, baseDir string) error {
+	db, err := sql.Open("sqlite3", ConcatPath(baseDir, DEFAULT_META_FILENAME))
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	for fileName, stat := range fileStats {
+		if _, err := tx.Exec(updateFileStat, stat.Size, stat.ModTime, int64(stat.Inode), fileName); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// LoadFileStats reads the stats of the files from index.db, where files
+// without one, or a missing index.db, are left out
+func LoadFileStats(baseDir string) (map[string]FileStat, error) {
+	fileStats := make(map[string]FileStat)
+	metaFilePath, _ := filepath.Abs(ConcatPath(baseDir, DEFAULT_META_FILENAME))
+	if metaFileStats, err := os.Stat(metaFilePath); err != nil || metaFileStats.IsDir() {
+		return fileStats, nil
+	}
+	db, err := openReadOnly(metaFilePath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	rows, err := db.Query(getIndexColumns)
+	if err != nil {
+		return nil, err
+	}
+	columns := make(map[string]bool)
+	var column string
+	for rows.Next() {
+		rows.Scan(&column)
+		columns[column] = true
+	}
+	rows.Close()
+	for _, column := range fileStatColumns {
+		if !columns[column] {
+			return fileStats, nil
+		}
+	}
+
+	rows, err = db.Query(getFileStats)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var fileName string
+	var stat FileStat
+	var inode int64
+	for rows.Next() {
+		if err := rows.Scan(&fileName, &stat.Size, &stat.ModTime, &inode); err != nil {
+			return nil, err
+		}
+		stat.Inode = uint64(inode)
+		fileStats[fileName] = stat
+	}
+	return fileStats, rows.Err()
+}
+
 /*
 	Debugging Related
 */
@@ -161,3 +363,20 @@ func PrintMetaMap(metaMap map[string]*FileMetaData) {
 	fmt.Println("---------END PRINT MAP--------")
 
 }
+
+// Open an existing database for reading only, so loading the client's state
+// never changes it, not even a dry run's
+func openReadOnly(path string) (*sql.DB, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	return sql.Open("sqlite3", (&url.URL{Scheme: "file", Path: absPath, RawQuery: "mode=ro"}).String())
+}
+
+// Whether the database has the table, which files written by older clients may lack
+func hasTable(db *sql.DB, table string) (bool, error) {
+	var count int
+	err := db.QueryRow("select count(*) from sqlite_master where type = 'table' and name = ?;", table).Scan(&count)
+	return count > 0, err
+}
@@ -0,0 +1,141 @@
+package surfstore
+
+import (
+	"log"
+	"os"
+	pathpkg "path"
+	"strings"
+)
+
+// A .surfignore pattern, split into path segments, from the .surfignore in
+// the directory with key dirKey ("" for the base directory and the global file)
+type ignoreRule struct {
+	dirKey   string
+	segments []string
+	negate   bool // re-includes what an earlier pattern ignored
+	dirOnly  bool // only matches directories
+}
+
+// IgnoreRules holds gitignore-style patterns: blank lines and lines starting
+// with # are skipped, ! negates, a trailing / matches only directories, a
+// pattern with another / is relative to its file's directory and one without
+// matches at any depth below it, and ** matches any number of directories.
+// The last matching pattern wins, and nothing under an ignored directory can
+// be re-included.
+type IgnoreRules struct {
+	rules []ignoreRule
+}
+
+func NewIgnoreRules() *IgnoreRules {
+	return &IgnoreRules{rules: make([]ignoreRule, 0)}
+}
+
+// Add the patterns of the ignore file at path, which applies to the directory
+// with key dirKey. A missing file adds nothing.
+func (r *IgnoreRules) LoadFile(path string, dirKey string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	r.AddPatterns(dirKey, string(data))
+	return nil
+}
+
+// Add the patterns, one per line, applying to the directory with key dirKey.
+// Patterns loaded later take precedence.
+func (r *IgnoreRules) AddPatterns(dirKey string, patterns string) {
+	for _, line := range strings.Split(patterns, "\n") {
+		line = strings.TrimRight(line, " \t\r")
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		rule := ignoreRule{dirKey: dirKey}
+		if strings.HasPrefix(line, "!") {
+			rule.negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\#") || strings.HasPrefix(line, "\\!") {
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+
+		// a pattern without a slash matches at any depth
+		if !strings.Contains(line, "/") {
+			line = "**/" + line
+		}
+		rule.segments = strings.Split(strings.TrimPrefix(line, "/"), "/")
+		// a trailing /** matches what is inside, not the directory itself
+		if rule.segments[len(rule.segments)-1] == "**" {
+			rule.segments = append(rule.segments[:len(rule.segments)-1], "*", "**")
+		}
+		if _, err := pathpkg.Match(strings.Join(rule.segments, "/"), ""); err != nil {
+			log.Printf("Skipping malformed ignore pattern %q: %v\n", line, err)
+			continue
+		}
+		r.rules = append(r.rules, rule)
+	}
+}
+
+// Ignored reports whether the file or directory with the sync key is ignored,
+// itself or through one of the directories it is in
+func (r *IgnoreRules) Ignored(key string) bool {
+	if r == nil || len(r.rules) == 0 || key == "" {
+		return false
+	}
+	segments := strings.Split(strings.TrimSuffix(key, "/"), "/")
+	for idx := range segments {
+		isDir := idx < len(segments)-1 || isDirectoryKey(key)
+		if r.matches(strings.Join(segments[:idx+1], "/"), isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether the last pattern matching path ignores it
+func (r *IgnoreRules) matches(path string, isDir bool) bool {
+	ignored := false
+	for _, rule := range r.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if !strings.HasPrefix(path, rule.dirKey) {
+			continue
+		}
+		if matchSegments(rule.segments, strings.Split(strings.TrimPrefix(path, rule.dirKey), "/")) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// Match path segments against pattern segments, where ** matches any number
+// of segments
+func matchSegments(pattern []string, segments []string) bool {
+	if len(pattern) == 0 {
+		return len(segments) == 0
+	}
+	if pattern[0] == "**" {
+		for idx := 0; idx <= len(segments); idx++ {
+			if matchSegments(pattern[1:], segments[idx:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segments) == 0 {
+		return false
+	}
+	if matched, _ := pathpkg.Match(pattern[0], segments[0]); !matched {
+		return false
+	}
+	return matchSegments(pattern[1:], segments[1:])
+}
@@ -28,12 +28,79 @@ type BlockStoreInterface interface {
 	// Put a block
 	PutBlock(ctx context.Context, block *Block) (*Success, error)
 
+	// Stream the blocks for a list of hashes, in order
+	GetBlocks(blockHashesIn *BlockHashes, stream BlockStore_GetBlocksServer) error
+
+	// Put every block sent on the stream
+	PutBlocks(stream BlockStore_PutBlocksServer) error
+
 	// Given a list of hashes “in”, returns a list containing the
 	// subset of in that are stored in the key-value store
 	HasBlocks(ctx context.Context, blockHashesIn *BlockHashes) (*BlockHashes, error)
 
 	// Get which blocks are on this BlockStore server
 	GetBlockHashes(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error)
+
+	// Delete blocks that were not put within the grace period
+	DeleteBlocks(ctx context.Context, blockDeletion *BlockDeletion) (*BlockHashes, error)
+
+	// Get which codecs blocks may be compressed with
+	GetCodecs(ctx context.Context, _ *emptypb.Empty) (*Codecs, error)
+
+	// Get the uncompressed and stored size of all blocks
+	GetCompressionStats(ctx context.Context, _ *emptypb.Empty) (*CompressionStats, error)
+
+	// Get which blocks the scrubber has quarantined as corrupt
+	GetQuarantinedBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error)
+
+	// Reload the at-rest keys and rewrite blocks not sealed under the current one
+	ReencryptBlocks(ctx context.Context, _ *emptypb.Empty) (*BlockHashes, error)
+
+	// Get how many blocks are stored and how much space is used and left
+	GetStats(ctx context.Context, _ *emptypb.Empty) (*BlockStoreStats, error)
+}
+
+// Where a BlockStore keeps its blocks. Implementations need not be safe for
+// concurrent use, the BlockStore serializes access.
+type BlockStorage interface {
+	// Get the block stored under hash, found is false if there is none
+	Get(hash string) (block *Block, found bool, err error)
+
+	// Check whether a block is stored under hash
+	Has(hash string) (bool, error)
+
+	// Store a block under hash, replacing any previous one
+	Put(hash string, block *Block) error
+
+	// Remove the block stored under hash
+	Delete(hash string) error
+
+	// Get the hashes of all stored blocks
+	Hashes() ([]string, error)
+
+	// Get how many bytes the block stored under hash takes up, without reading it
+	Size(hash string) (size int64, found bool, err error)
+}
+
+// How blocks are assigned to BlockStores. Implementations are immutable once
+// built, a membership change builds a new one.
+type PlacementStrategy interface {
+	// Get the BlockStore responsible for the block, "" if there are none
+	GetResponsibleServer(blockId string) string
+
+	// Get the responsible BlockStore of each block, in input order
+	LookupMany(blockIds []string) []string
+
+	// Get up to n distinct BlockStores for the block in order of preference,
+	// starting with the responsible one. n < 1 returns all of them.
+	GetTopServers(blockId string, n int) []string
+
+	// Get n distinct BlockStores to hold copies of the block, starting with the
+	// responsible one and spread over as many failure domains as possible
+	GetReplicaServers(blockId string, n int) []string
+
+	// Get the fraction of blocks each BlockStore is expected to hold
+	GetOwnershipShares() map[string]float64
 }
 
 type ClientInterface interface {
@@ -42,10 +109,21 @@ type ClientInterface interface {
 	UpdateFile(fileMetaData *FileMetaData, latestVersion *int32) error
 	GetBlockStoreMap(blockHashesIn []string, blockStoreMap *map[string][]string) error
 	GetBlockStoreAddrs(blockStoreAddrs *[]string) error
+	CollectGarbage(blockHashesOut *[]string) error
 
 	// BlockStore
 	GetBlock(blockHash string, blockStoreAddr string, block *Block) error
 	PutBlock(block *Block, blockStoreAddr string, succ *bool) error
+	GetBlocks(blockHashesIn []string, blockStoreAddr string, blocks *[]*Block) error
+	PutBlocks(blocks []*Block, blockStoreAddr string, succ *bool) error
 	HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string) error
 	GetBlockHashes(blockStoreAddr string, blockHashes *[]string) error
+	GetQuarantinedBlocks(blockStoreAddr string, blockHashes *[]string) error
+	GetCodecs(blockStoreAddr string, codecs *[]Codec) error
+	GetCompressionStats(blockStoreAddr string, stats *CompressionStats) error
+	ReencryptBlocks(blockStoreAddr string, blockHashes *[]string) error
+	GetStats(blockStoreAddr string, stats *BlockStoreStats) error
+	ChangeBlockMembership(membership *BlockMembership, succ *bool) error
+	GetBlockMembership(membership *BlockMembership) error
+	WaitForChanges(cursor int64, latestCursor *int64) error
 }
@@ -0,0 +1,274 @@
+package surfstore
+
+import (
+	context "context"
+	"database/sql"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// What the journal records about a file, in the order a sync does it
+const JOURNAL_PLANNED string = "planned"           // the sync is about to act on the file, Action says how
+const JOURNAL_UPLOADED string = "uploaded"         // the blocks in HashList are on the BlockStores
+const JOURNAL_COMMITTED string = "committed"       // the server accepted Version with HashList, the local file holds it
+const JOURNAL_DOWNLOADING string = "downloading"   // the file is being written to TempPath
+const JOURNAL_MATERIALIZED string = "materialized" // the local file holds Version with HashList
+const JOURNAL_REMOVED string = "removed"           // the local file was removed for the tombstone Version
+
+// One step of a sync, see SyncJournal
+type JournalEntry struct {
+	Filename  string
+	Operation string
+	Action    string
+	Version   int32
+	HashList  []string
+	Chunker   string
+	TempPath  string
+}
+
+// SyncJournal records the progress of ClientSync in the base directory, so a
+// sync that did not finish can be resumed. index.db is only written once a
+// sync finishes, so the next sync takes the files the journal says were
+// committed, downloaded or removed as synced, skips the blocks it says were
+// uploaded that are still on the BlockStores and removes the temporary files
+// of unfinished downloads. The
+// journal is removed once index.db is written.
+type SyncJournal struct {
+	db       *sql.DB
+	path     string
+	entries  []JournalEntry  // left by the unfinished sync
+	uploaded map[string]bool // blocks the unfinished sync uploaded
+}
+
+const createJournalTable string = `create table if not exists journal (
+		seq INTEGER PRIMARY KEY AUTOINCREMENT,
+		fileName TEXT,
+		operation TEXT,
+		action TEXT,
+		version INT,
+		hashList TEXT,
+		chunker TEXT,
+		tempPath TEXT
+	);`
+
+const insertJournalEntry string = `insert into journal (fileName, operation, action, version, hashList, chunker, tempPath) VALUES (?,?,?,?,?,?,?);`
+const getJournalEntries = "select fileName, operation, action, version, hashList, chunker, tempPath from journal order by seq;"
+
+// Whether a file in the base directory holds the client's own state, which is
+// neither synced nor a local change
+func isSyncStateFile(name string) bool {
+	return strings.Contains(name, DEFAULT_META_FILENAME) || strings.HasPrefix(name, DEFAULT_JOURNAL_FILENAME) ||
+		strings.HasPrefix(name, DOWNLOAD_TEMP_PREFIX)
+}
+
+// LoadJournal reads the entries of the journal in baseDir, in the order they
+// were recorded, and none if there is no journal
+func LoadJournal(baseDir string) ([]JournalEntry, error) {
+	entries := make([]JournalEntry, 0)
+	journalPath := filepath.Join(baseDir, DEFAULT_JOURNAL_FILENAME)
+	if journalStats, err := os.Stat(journalPath); err != nil || journalStats.IsDir() {
+		return entries, nil
+	}
+	db, err := openReadOnly(journalPath)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+	if found, err := hasTable(db, "journal"); err != nil || !found {
+		return entries, err
+	}
+
+	rows, err := db.Query(getJournalEntries)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var entry JournalEntry
+		var hashList string
+		if err := rows.Scan(&entry.Filename, &entry.Operation, &entry.Action, &entry.Version, &hashList, &entry.Chunker, &entry.TempPath); err != nil {
+			return nil, err
+		}
+		entry.HashList = make([]string, 0)
+		if hashList != "" {
+			entry.HashList = strings.Split(hashList, HASH_DELIMITER)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, rows.Err()
+}
+
+// OpenJournal opens the journal in baseDir to record a sync, keeping the
+// entries of an unfinished one until Resume is called
+func OpenJournal(baseDir string) (*SyncJournal, error) {
+	entries, err := LoadJournal(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	journalPath := filepath.Join(baseDir, DEFAULT_JOURNAL_FILENAME)
+	db, err := sql.Open("sqlite3", journalPath)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := db.Exec(createJournalTable); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &SyncJournal{db: db, path: journalPath, entries: entries, uploaded: make(map[string]bool)}, nil
+}
+
+// Resume brings indexFileMetaMap up to date with what the unfinished sync
+// did, and removes the temporary files of its unfinished downloads. Its
+// entries stay in the journal, in case this sync does not finish either.
+func (journal *SyncJournal) Resume(indexFileMetaMap map[string]*FileMetaData) {
+	if journal == nil || len(journal.entries) == 0 {
+		return
+	}
+	applyJournal(journal.entries, indexFileMetaMap)
+	for _, entry := range journal.entries {
+		switch entry.Operation {
+		case JOURNAL_UPLOADED:
+			for _, hash := range entry.HashList {
+				journal.uploaded[hash] = true
+			}
+		case JOURNAL_DOWNLOADING:
+			if err := os.Remove(entry.TempPath); err != nil && !os.IsNotExist(err) {
+				log.Printf("Could not remove %s: %s\n", entry.TempPath, err.Error())
+			}
+		}
+	}
+}
+
+// Take the files an unfinished sync committed, downloaded or removed as
+// synced, and log what it did not get to
+func applyJournal(entries []JournalEntry, indexFileMetaMap map[string]*FileMetaData) {
+	if len(entries) == 0 {
+		return
+	}
+	finished := 0
+	pending := make(map[string]string) // file => planned action not finished
+	for _, entry := range entries {
+		switch entry.Operation {
+		case JOURNAL_PLANNED:
+			pending[entry.Filename] = entry.Action
+		case JOURNAL_COMMITTED, JOURNAL_MATERIALIZED:
+			indexFileMetaMap[entry.Filename] = &FileMetaData{Filename: entry.Filename, Version: entry.Version, BlockHashList: entry.HashList, Chunker: entry.Chunker}
+			delete(pending, entry.Filename)
+			finished++
+		case JOURNAL_REMOVED:
+			indexFileMetaMap[entry.Filename] = &FileMetaData{Filename: entry.Filename, Version: entry.Version, BlockHashList: []string{TOMBSTONE_HASHVALUE}}
+			delete(pending, entry.Filename)
+			finished++
+		}
+	}
+	log.Printf("Resuming an unfinished sync: %d operations finished, %d did not\n", finished, len(pending))
+	for filename, action := range pending {
+		log.Printf("  %s of %s did not finish\n", action, filename)
+	}
+}
+
+// Drop from hashAddrs, which maps hashes to the BlockStores they go to, the
+// blocks the unfinished sync uploaded that all of those BlockStores still
+// have. No metadata refers to them yet, so garbage collection may have swept
+// them since, and those are uploaded again.
+func (journal *SyncJournal) skipUploaded(hashAddrs map[string][]string, blockStoreMap *map[string]BlockStoreClient, ctx context.Context) {
+	if journal == nil || len(journal.uploaded) == 0 {
+		return
+	}
+	uploadedHashes := make(map[string][]string) // addr => hashes uploaded to it
+	for hash, addrs := range hashAddrs {
+		if journal.uploaded[hash] {
+			for _, addr := range addrs {
+				uploadedHashes[addr] = append(uploadedHashes[addr], hash)
+			}
+		}
+	}
+
+	storedCopies := make(map[string]int)
+	for addr, hashes := range uploadedHashes {
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		stored, err := (*blockStoreMap)[addr].HasBlocks(callCtx, &BlockHashes{Hashes: hashes})
+		cancel()
+		if err != nil {
+			continue
+		}
+		for _, hash := range stored.Hashes {
+			storedCopies[hash]++
+		}
+	}
+	for hash, copies := range storedCopies {
+		if copies == len(hashAddrs[hash]) {
+			delete(hashAddrs, hash)
+		}
+	}
+}
+
+// Planned records that the sync is about to act on filename, one of the PLAN_ actions
+func (journal *SyncJournal) Planned(filename string, action string) {
+	journal.record(JournalEntry{Filename: filename, Operation: JOURNAL_PLANNED, Action: action})
+}
+
+// Uploaded records that the blocks of filename with hashes are on the BlockStores
+func (journal *SyncJournal) Uploaded(filename string, hashes []string) {
+	journal.record(JournalEntry{Filename: filename, Operation: JOURNAL_UPLOADED, HashList: hashes})
+}
+
+// Committed records that the server accepted the metadata of a local file
+func (journal *SyncJournal) Committed(metadata *FileMetaData) {
+	journal.record(JournalEntry{Filename: metadata.Filename, Operation: JOURNAL_COMMITTED, Version: metadata.Version, HashList: metadata.BlockHashList, Chunker: metadata.Chunker})
+}
+
+// Downloading records that filename is being written to tempPath
+func (journal *SyncJournal) Downloading(filename string, tempPath string) {
+	journal.record(JournalEntry{Filename: filename, Operation: JOURNAL_DOWNLOADING, TempPath: tempPath})
+}
+
+// Materialized records that the local file holds the version in metadata
+func (journal *SyncJournal) Materialized(metadata *FileMetaData) {
+	journal.record(JournalEntry{Filename: metadata.Filename, Operation: JOURNAL_MATERIALIZED, Version: metadata.Version, HashList: metadata.BlockHashList, Chunker: metadata.Chunker})
+}
+
+// Removed records that the local file was removed for the tombstone in metadata
+func (journal *SyncJournal) Removed(metadata *FileMetaData) {
+	journal.record(JournalEntry{Filename: metadata.Filename, Operation: JOURNAL_REMOVED, Version: metadata.Version})
+}
+
+// Entries are written one transaction each, so they are on disk before the
+// sync moves on
+func (journal *SyncJournal) record(entry JournalEntry) {
+	if journal == nil || journal.db == nil {
+		return
+	}
+	_, err := journal.db.Exec(insertJournalEntry, entry.Filename, entry.Operation, entry.Action, entry.Version,
+		strings.Join(entry.HashList, HASH_DELIMITER), entry.Chunker, entry.TempPath)
+	checkError(err)
+}
+
+// Close the journal, leaving it in the base directory
+func (journal *SyncJournal) Close() error {
+	if journal == nil || journal.db == nil {
+		return nil
+	}
+	err := journal.db.Close()
+	journal.db = nil
+	return err
+}
+
+// Clear removes the journal once index.db holds the result of the sync
+func (journal *SyncJournal) Clear() error {
+	if journal == nil {
+		return nil
+	}
+	if err := journal.Close(); err != nil {
+		return err
+	}
+	if err := os.Remove(journal.path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package surfstore
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+)
+
+var ERR_DOWNLOAD_CORRUPT = fmt.Errorf("downloaded file does not match its hash list")
+
+// What a local file looked like before a download, to tell whether it was
+// changed while the download was written
+type fileState struct {
+	exists  bool
+	size    int64
+	modTime int64
+	mode    fs.FileMode
+}
+
+func statFile(path string) fileState {
+	info, err := os.Lstat(path)
+	if err != nil {
+		return fileState{}
+	}
+	return fileState{exists: true, size: info.Size(), modTime: info.ModTime().UnixNano(), mode: info.Mode()}
+}
+
+// Write the version of a file in metadata to path, which looked like before
+// when the download started. write streams the data to a temporary file in
+// the same directory, which is synced to disk and checked against the hash
+// list, then renamed over path, so the file is never seen half written.
+// Returns false, leaving path alone, if it was changed meanwhile.
+func materializeFile(path string, write func(w io.Writer) error, metadata *FileMetaData, before fileState, client RPCClient) (bool, error) {
+	mode := fs.FileMode(0644)
+	if before.exists {
+		mode = before.mode.Perm()
+	}
+	tempFile, err := os.CreateTemp(filepath.Dir(path), DOWNLOAD_TEMP_PREFIX+"*")
+	if err != nil {
+		return false, err
+	}
+	tempPath := tempFile.Name()
+	client.Journal.Downloading(metadata.Filename, tempPath)
+
+	buffered := bufio.NewWriter(tempFile)
+	err = write(buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if err == nil {
+		err = tempFile.Chmod(mode)
+	}
+	if err == nil {
+		err = tempFile.Sync()
+	}
+	if closeErr := tempFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = verifyFile(tempPath, metadata, client)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return false, err
+	}
+
+	if statFile(path) != before {
+		os.Remove(tempPath)
+		log.Printf("%s changed while it was downloaded, keeping the local edits\n", metadata.Filename)
+		return false, nil
+	}
+	if err := os.Rename(tempPath, path); err != nil {
+		os.Remove(tempPath)
+		return false, err
+	}
+	syncDirectory(filepath.Dir(path))
+	client.Journal.Materialized(metadata)
+	return true, nil
+}
+
+// Check the file at path splits into the blocks of the hash list in metadata
+func verifyFile(path string, metadata *FileMetaData, client RPCClient) error {
+	hashes := getLocalHashes(path, client, getFileChunker(client, metadata))
+	if len(hashes) != len(metadata.BlockHashList) {
+		return ERR_DOWNLOAD_CORRUPT
+	}
+	for i, hash := range hashes {
+		if hash != metadata.BlockHashList[i] {
+			return ERR_DOWNLOAD_CORRUPT
+		}
+	}
+	return nil
+}
+
+// Make a rename in dir survive a crash, where the platform allows
+func syncDirectory(dir string) {
+	if d, err := os.Open(dir); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
@@ -0,0 +1,226 @@
+package surfstore
+
+import (
+	"bytes"
+	context "context"
+	"io"
+	"log"
+	"os"
+	"unicode/utf8"
+)
+
+// Text files up to this size are merged, larger ones get conflicted copies
+const MAX_MERGE_SIZE int = 1 << 20
+
+// Lines of the base times lines of a side, past which the diff of the middle
+// of the files (after common lines at both ends) is too big to compute
+const MAX_MERGE_CELLS int = 1 << 22
+
+// Text is valid UTF-8 without NUL bytes
+func isMergeableText(data []byte) bool {
+	return len(data) <= MAX_MERGE_SIZE && utf8.Valid(data) && bytes.IndexByte(data, 0) == -1
+}
+
+// Split after each newline, so joining the lines gives back data
+func splitLines(data []byte) [][]byte {
+	lines := make([][]byte, 0)
+	for len(data) > 0 {
+		end := bytes.IndexByte(data, '\n') + 1
+		if end == 0 {
+			end = len(data)
+		}
+		lines = append(lines, data[:end])
+		data = data[end:]
+	}
+	return lines
+}
+
+func sameLines(a [][]byte, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// For each base line, the index of the line of other it is matched to by a
+// longest common subsequence, or -1. ok is false if the diff is too big.
+func matchLines(base [][]byte, other [][]byte) (matches []int, ok bool) {
+	matches = make([]int, len(base))
+	for i := range matches {
+		matches[i] = -1
+	}
+
+	// common lines at both ends are matched without the table
+	prefix := 0
+	for prefix < len(base) && prefix < len(other) && bytes.Equal(base[prefix], other[prefix]) {
+		matches[prefix] = prefix
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(base)-prefix && suffix < len(other)-prefix &&
+		bytes.Equal(base[len(base)-1-suffix], other[len(other)-1-suffix]) {
+		matches[len(base)-1-suffix] = len(other) - 1 - suffix
+		suffix++
+	}
+
+	a := base[prefix : len(base)-suffix]
+	b := other[prefix : len(other)-suffix]
+	if len(a) == 0 || len(b) == 0 {
+		return matches, true
+	}
+	if len(a)*len(b) > MAX_MERGE_CELLS {
+		return nil, false
+	}
+
+	// lengths[i][j] is the LCS length of a[i:] and b[j:]
+	width := len(b) + 1
+	lengths := make([]int32, (len(a)+1)*width)
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if bytes.Equal(a[i], b[j]) {
+				lengths[i*width+j] = lengths[(i+1)*width+j+1] + 1
+			} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+				lengths[i*width+j] = lengths[(i+1)*width+j]
+			} else {
+				lengths[i*width+j] = lengths[i*width+j+1]
+			}
+		}
+	}
+	for i, j := 0, 0; i < len(a) && j < len(b); {
+		if bytes.Equal(a[i], b[j]) {
+			matches[prefix+i] = prefix + j
+			i++
+			j++
+		} else if lengths[(i+1)*width+j] >= lengths[i*width+j+1] {
+			i++
+		} else {
+			j++
+		}
+	}
+	return matches, true
+}
+
+// MergeText merges the changes local and remote made to base, line by line.
+// Base lines both sides kept anchor the merge; between anchors, a side's lines
+// are taken if the other side left the base's alone or made the same change.
+// ok is false if both sides changed the same lines differently, or a file is
+// not text.
+func MergeText(base []byte, local []byte, remote []byte) (merged []byte, ok bool) {
+	if !isMergeableText(base) || !isMergeableText(local) || !isMergeableText(remote) {
+		return nil, false
+	}
+	baseLines, localLines, remoteLines := splitLines(base), splitLines(local), splitLines(remote)
+	localMatches, ok := matchLines(baseLines, localLines)
+	if !ok {
+		return nil, false
+	}
+	remoteMatches, ok := matchLines(baseLines, remoteLines)
+	if !ok {
+		return nil, false
+	}
+
+	var out bytes.Buffer
+	i, j, k := 0, 0, 0 // next line of base, local and remote
+	for {
+		// the next base line both sides kept
+		next := i
+		for next < len(baseLines) && (localMatches[next] == -1 || remoteMatches[next] == -1) {
+			next++
+		}
+		if next == i && next < len(baseLines) && localMatches[next] == j && remoteMatches[next] == k {
+			out.Write(baseLines[i])
+			i, j, k = i+1, j+1, k+1
+			continue
+		}
+
+		localEnd, remoteEnd := len(localLines), len(remoteLines)
+		if next < len(baseLines) {
+			localEnd, remoteEnd = localMatches[next], remoteMatches[next]
+		}
+		baseChunk, localChunk, remoteChunk := baseLines[i:next], localLines[j:localEnd], remoteLines[k:remoteEnd]
+		var chosen [][]byte
+		if sameLines(localChunk, baseChunk) || sameLines(localChunk, remoteChunk) {
+			chosen = remoteChunk
+		} else if sameLines(remoteChunk, baseChunk) {
+			chosen = localChunk
+		} else {
+			return nil, false
+		}
+		for _, line := range chosen {
+			out.Write(line)
+		}
+
+		if next == len(baseLines) {
+			return out.Bytes(), true
+		}
+		i, j, k = next, localEnd, remoteEnd
+	}
+}
+
+// Merge the local edits of filename with the server's latest version, using
+// the version in base as the common ancestor, and commit the result as the
+// next version. Returns false, having changed nothing, if the file cannot be
+// merged cleanly or the server's version changed again meanwhile.
+func mergeConflict(path string, filename string, chunker Chunker, localHashes []string, base *FileMetaData, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, stats *CompressionStats, localFileMetaMap map[string]*FileMetaData) bool {
+	remoteFileMetaMap := make(map[string]*FileMetaData)
+	checkError(client.GetFileInfoMap(&remoteFileMetaMap))
+	remoteMetaData := remoteFileMetaMap[filename]
+	if base == nil || wasDeleted(base) || remoteMetaData == nil || wasDeleted(remoteMetaData) {
+		return false
+	}
+
+	// only files small enough to merge are read into memory
+	before := statFile(path)
+	if before.size > int64(MAX_MERGE_SIZE) {
+		return false
+	}
+	local, err := os.ReadFile(path)
+	checkError(err)
+	baseData, ok := reconstituteFile(path, chunker, blockStoreMap, client, ctx, base.BlockHashList, localHashes, MAX_MERGE_SIZE)
+	if !ok {
+		return false
+	}
+	remote, ok := reconstituteFile(path, chunker, blockStoreMap, client, ctx, remoteMetaData.BlockHashList, localHashes, MAX_MERGE_SIZE)
+	if !ok {
+		return false
+	}
+	merged, ok := MergeText(baseData, local, remote)
+	if !ok {
+		return false
+	}
+
+	// split the way the server's version was, so other clients agree
+	mergedChunker := getFileChunker(client, remoteMetaData)
+	mergedHashes := make([]string, 0)
+	mergedBlocks := make([]*Block, 0)
+	for _, chunk := range mergedChunker.Split(merged) {
+		mergedHashes = append(mergedHashes, getLocalBlockHash(chunk, client))
+		mergedBlocks = append(mergedBlocks, &Block{BlockData: chunk, BlockSize: int32(len(chunk))})
+	}
+	if err := uploadBlocks(filename, mergedHashes, memoryBlocks(mergedHashes, mergedBlocks), blockStoreMap, client, ctx, stats); err != nil {
+		log.Fatalf("Errored uploading blocks: %s\n", err.Error())
+	}
+	mergedMetaData := &FileMetaData{Filename: filename, Version: remoteMetaData.Version + 1, BlockHashList: mergedHashes, Chunker: mergedChunker.Spec()}
+	returnVersion := mergedMetaData.Version
+	checkError(client.UpdateFile(mergedMetaData, &returnVersion))
+	if returnVersion == -1 {
+		return false
+	}
+
+	// journaled once written, a merge committed but not written is merged again
+	written, err := materializeFile(path, func(w io.Writer) error {
+		_, err := w.Write(merged)
+		return err
+	}, mergedMetaData, before, client)
+	checkError(err)
+	if written {
+		localFileMetaMap[filename] = mergedMetaData
+	}
+	log.Printf("Merged local edits of %s into version %d\n", filename, mergedMetaData.Version)
+	return true
+}
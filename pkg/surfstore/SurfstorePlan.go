@@ -0,0 +1,181 @@
+package surfstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+)
+
+// Actions of a sync plan
+const PLAN_UPLOAD string = "upload"
+const PLAN_DOWNLOAD string = "download"
+const PLAN_DELETE_LOCAL string = "delete-local"
+const PLAN_DELETE_REMOTE string = "delete-remote"
+const PLAN_CONFLICT string = "conflict"
+
+// Formats a plan is printed in
+const PLAN_FORMAT_TABLE string = "table"
+const PLAN_FORMAT_JSON string = "json"
+
+// What a sync would do to one file. Downloaded bytes are estimated from the
+// number of blocks missing locally, as block sizes are only known once fetched.
+type PlannedAction struct {
+	Action    string `json:"action"`
+	Filename  string `json:"file"`
+	Blocks    int    `json:"blocks"`
+	Bytes     int64  `json:"bytes"`
+	Estimated bool   `json:"estimated,omitempty"`
+	Detail    string `json:"detail,omitempty"`
+}
+
+// SyncPlan is what a dry run of ClientSync found to do, without uploading,
+// committing or writing anything
+type SyncPlan struct {
+	Actions       []PlannedAction `json:"actions"`
+	UploadBytes   int64           `json:"uploadBytes"`
+	DownloadBytes int64           `json:"downloadBytes"`
+}
+
+// IsPlanFormat checks whether format names a known plan format, where "" is a table
+func IsPlanFormat(format string) bool {
+	return format == "" || format == PLAN_FORMAT_TABLE || format == PLAN_FORMAT_JSON
+}
+
+// Plan the sync of a file ClientSync found locally, mirroring the order of
+// its cases
+func (plan *SyncPlan) planLocalFile(filename string, localMetadata *FileMetaData, remoteMetaData *FileMetaData, localModification bool, differFromRemote bool, editedSinceSync bool, localHashes []string, localSizes []int, chunker Chunker, client RPCClient) {
+	switch {
+	case remoteMetaData == nil:
+		plan.addUpload(filename, getHashesToUpload(filename, localHashes), localHashes, localSizes, "new")
+	case remoteMetaData.Version > localMetadata.Version && editedSinceSync && differFromRemote:
+		action := plan.downloadAction(PLAN_CONFLICT, filename, remoteMetaData, localHashes, chunker)
+		switch client.ConflictPolicy {
+		case CONFLICT_COPY:
+			action.Detail = "local edits kept as a conflicted copy"
+		case CONFLICT_MERGE:
+			action.Detail = "merged if the edits do not overlap, otherwise a conflicted copy"
+		default:
+			action.Detail = "local edits replaced by the server's version"
+		}
+		if keepsLocalEdits(client.ConflictPolicy) {
+			blocks, bytes := countUniqueBlocks(localHashes, localHashes, localSizes)
+			action.Blocks += blocks
+			action.Bytes += bytes
+			plan.UploadBytes += bytes
+		}
+		plan.add(action)
+	case remoteMetaData.Version > localMetadata.Version && wasDeleted(remoteMetaData):
+		plan.add(PlannedAction{Action: PLAN_DELETE_LOCAL, Filename: filename, Detail: "deleted on the server"})
+	case remoteMetaData.Version > localMetadata.Version:
+		plan.add(plan.downloadAction(PLAN_DOWNLOAD, filename, remoteMetaData, localHashes, chunker))
+	case localModification || differFromRemote:
+		if wasDeleted(localMetadata) {
+			plan.add(PlannedAction{Action: PLAN_DELETE_REMOTE, Filename: filename, Detail: "deleted locally"})
+		} else if isDirectoryKey(filename) {
+			plan.addUpload(filename, []string{}, localHashes, localSizes, "")
+		} else {
+			missingHashes := make([]string, 0)
+			for _, hash := range localHashes {
+				if !hashInHashList(remoteMetaData.BlockHashList, hash) {
+					missingHashes = append(missingHashes, hash)
+				}
+			}
+			plan.addUpload(filename, missingHashes, localHashes, localSizes, "changed")
+		}
+	}
+}
+
+// Plan the download of a file that is only on the server
+func (plan *SyncPlan) planRemoteFile(filename string, remoteMetaData *FileMetaData, client RPCClient) {
+	if wasDeleted(remoteMetaData) {
+		return
+	}
+	plan.add(plan.downloadAction(PLAN_DOWNLOAD, filename, remoteMetaData, []string{}, getFileChunker(client, remoteMetaData)))
+}
+
+func (plan *SyncPlan) addUpload(filename string, hashes []string, localHashes []string, localSizes []int, detail string) {
+	blocks, bytes := countUniqueBlocks(hashes, localHashes, localSizes)
+	plan.UploadBytes += bytes
+	plan.add(PlannedAction{Action: PLAN_UPLOAD, Filename: filename, Blocks: blocks, Bytes: bytes, Detail: detail})
+}
+
+// The blocks of the server's version missing locally, at the chunker's average size
+func (plan *SyncPlan) downloadAction(action string, filename string, remoteMetaData *FileMetaData, localHashes []string, chunker Chunker) PlannedAction {
+	missing := make(map[string]bool)
+	for _, hash := range remoteMetaData.BlockHashList {
+		if hash != DIRECTORY_HASHVALUE && !hashInHashList(localHashes, hash) {
+			missing[hash] = true
+		}
+	}
+	bytes := int64(len(missing)) * int64(averageChunkSize(chunker))
+	plan.DownloadBytes += bytes
+	return PlannedAction{Action: action, Filename: filename, Blocks: len(missing), Bytes: bytes, Estimated: len(missing) > 0}
+}
+
+func (plan *SyncPlan) add(action PlannedAction) {
+	plan.Actions = append(plan.Actions, action)
+}
+
+// Number and size of the distinct blocks among hashes
+func countUniqueBlocks(hashes []string, localHashes []string, localSizes []int) (int, int64) {
+	seen := make(map[string]bool)
+	var bytes int64
+	for _, hash := range hashes {
+		if seen[hash] {
+			continue
+		}
+		seen[hash] = true
+		if idx := getHashIndex(localHashes, hash); idx != -1 && idx < len(localSizes) {
+			bytes += int64(localSizes[idx])
+		}
+	}
+	return len(seen), bytes
+}
+
+func averageChunkSize(chunker Chunker) int {
+	switch c := chunker.(type) {
+	case FixedChunker:
+		return c.BlockSize
+	case FastCDCChunker:
+		return c.AvgSize
+	}
+	return 0
+}
+
+// Write the plan as a table, or as JSON
+func (plan *SyncPlan) Write(w io.Writer, format string) error {
+	sort.SliceStable(plan.Actions, func(i, j int) bool { return plan.Actions[i].Filename < plan.Actions[j].Filename })
+	if format == PLAN_FORMAT_JSON {
+		if plan.Actions == nil {
+			plan.Actions = []PlannedAction{}
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(plan)
+	}
+
+	counts := make(map[string]int)
+	table := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(table, "ACTION\tBLOCKS\tBYTES\tFILE\n")
+	for _, action := range plan.Actions {
+		bytes := fmt.Sprint(action.Bytes)
+		if action.Estimated {
+			bytes = "~" + bytes
+		}
+		file := action.Filename
+		if action.Detail != "" {
+			file += " (" + action.Detail + ")"
+		}
+		fmt.Fprintf(table, "%s\t%d\t%s\t%s\n", action.Action, action.Blocks, bytes, file)
+		counts[action.Action]++
+	}
+	if err := table.Flush(); err != nil {
+		return err
+	}
+	_, err := fmt.Fprintf(w, "Plan: %d uploads (%d bytes), %d downloads (~%d bytes), %d local deletions, %d remote deletions, %d conflicts\n",
+		counts[PLAN_UPLOAD], plan.UploadBytes, counts[PLAN_DOWNLOAD], plan.DownloadBytes,
+		counts[PLAN_DELETE_LOCAL], counts[PLAN_DELETE_REMOTE], counts[PLAN_CONFLICT])
+	return err
+}
@@ -3,6 +3,7 @@ package surfstore
 import (
 	context "context"
 	"fmt"
+	"io"
 	"strings"
 	"time"
 
@@ -14,6 +15,17 @@ type RPCClient struct {
 	MetaStoreAddrs []string
 	BaseDir        string
 	BlockSize      int
+	Codec          Codec        // preferred codec for uploaded blocks
+	Cipher         *BlockCipher // encrypts blocks end to end when set
+	BlockCache     BlockStorage // client-local copies of downloaded blocks when set
+	Chunker        string       // spec of the chunker new files are split with, see ParseChunker
+	ConflictPolicy string       // CONFLICT_OVERWRITE, CONFLICT_COPY or CONFLICT_MERGE
+	IgnoreFile     string       // global ignore file, applied before the .surfignore files
+	Selection      []string     // directories to sync, replacing those saved in index.db unless nil
+	DryRun         bool         // print the plan of the sync instead of syncing
+	PlanFormat     string       // PLAN_FORMAT_TABLE or PLAN_FORMAT_JSON
+	FullRescan     bool         // hash every file, not only those whose stat changed
+	Journal        *SyncJournal // records the progress of a sync, set by ClientSync
 }
 
 func (surfClient *RPCClient) GetBlockStoreMap(blockHashesIn []string, blockStoreMap *map[string][]string) error {
@@ -78,6 +90,124 @@ func (surfClient *RPCClient) GetBlockStoreAddrs(blockStoreAddrs *[]string) error
 	return ERR_SERVER_CRASHED // all servers are crashed
 }
 
+func (surfClient *RPCClient) CollectGarbage(blockHashesOut *[]string) error {
+	for _, raftServerAddr := range surfClient.MetaStoreAddrs {
+		// connect to the server
+		conn, err := grpc.Dial(raftServerAddr, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		// perform the call, sweeping every BlockStore can take a while
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		var empty emptypb.Empty
+		deleted, err := c.CollectGarbage(ctx, &empty)
+		if err != nil {
+			conn.Close()
+			if err == ERR_NOT_LEADER || err == ERR_SERVER_CRASHED || strings.Contains(err.Error(), "Server is not the leader") || strings.Contains(err.Error(), "Server is crashed") {
+				continue
+			} else {
+				return ERR_SERVER_CRASHED
+			}
+		} else {
+			*blockHashesOut = deleted.Hashes
+			return conn.Close()
+		}
+	}
+	return ERR_SERVER_CRASHED // all servers crashed
+}
+
+func (surfClient *RPCClient) ChangeBlockMembership(membership *BlockMembership, succ *bool) error {
+	for _, raftServerAddr := range surfClient.MetaStoreAddrs {
+		// connect to the server
+		conn, err := grpc.Dial(raftServerAddr, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		// perform the call
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		success, err := c.ChangeBlockMembership(ctx, membership)
+		if err != nil {
+			conn.Close()
+			if err == ERR_NOT_LEADER || err == ERR_SERVER_CRASHED || strings.Contains(err.Error(), "Server is not the leader") || strings.Contains(err.Error(), "Server is crashed") {
+				continue
+			} else {
+				return err
+			}
+		} else {
+			*succ = success.Flag
+			return conn.Close()
+		}
+	}
+	return ERR_SERVER_CRASHED // all servers crashed
+}
+
+func (surfClient *RPCClient) GetBlockMembership(membership *BlockMembership) error {
+	for _, raftServerAddr := range surfClient.MetaStoreAddrs {
+		// connect to the server
+		conn, err := grpc.Dial(raftServerAddr, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		// perform the call
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		var empty emptypb.Empty
+		current, err := c.GetBlockMembership(ctx, &empty)
+		if err != nil {
+			conn.Close()
+			if err == ERR_NOT_LEADER || err == ERR_SERVER_CRASHED || strings.Contains(err.Error(), "Server is not the leader") || strings.Contains(err.Error(), "Server is crashed") {
+				continue
+			} else {
+				return ERR_SERVER_CRASHED
+			}
+		} else {
+			membership.Servers = current.Servers
+			membership.VirtualNodes = current.VirtualNodes
+			membership.Migrating = current.Migrating
+			membership.Placement = current.Placement
+			membership.ReplicationFactor = current.ReplicationFactor
+			return conn.Close()
+		}
+	}
+	return ERR_SERVER_CRASHED // all servers crashed
+}
+
+func (surfClient *RPCClient) WaitForChanges(cursor int64, latestCursor *int64) error {
+	for _, raftServerAddr := range surfClient.MetaStoreAddrs {
+		// connect to the server
+		conn, err := grpc.Dial(raftServerAddr, grpc.WithInsecure())
+		if err != nil {
+			return err
+		}
+		c := NewRaftSurfstoreClient(conn)
+
+		// perform the call, which the leader holds until a change or its timeout
+		ctx, cancel := context.WithTimeout(context.Background(), DEFAULT_WATCH_TIMEOUT+5*time.Second)
+		defer cancel()
+		latest, err := c.WaitForChanges(ctx, &ChangeCursor{Index: cursor})
+		if err != nil {
+			conn.Close()
+			if err == ERR_NOT_LEADER || err == ERR_SERVER_CRASHED || strings.Contains(err.Error(), "Server is not the leader") || strings.Contains(err.Error(), "Server is crashed") {
+				continue
+			} else {
+				return ERR_SERVER_CRASHED
+			}
+		} else {
+			*latestCursor = latest.Index
+			return conn.Close()
+		}
+	}
+	return ERR_SERVER_CRASHED // all servers crashed
+}
+
 func (surfClient *RPCClient) GetBlockHashes(blockStoreAddr string, blockHashes *[]string) error {
 	// connect to the server
 	addr := blockStoreAddr
@@ -106,6 +236,152 @@ func (surfClient *RPCClient) GetBlockHashes(blockStoreAddr string, blockHashes *
 	return conn.Close()
 }
 
+func (surfClient *RPCClient) GetQuarantinedBlocks(blockStoreAddr string, blockHashes *[]string) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var empty emptypb.Empty
+	hashes, err := c.GetQuarantinedBlocks(ctx, &empty)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	*blockHashes = hashes.Hashes
+	// close the connection
+	return conn.Close()
+}
+
+func (surfClient *RPCClient) ReencryptBlocks(blockStoreAddr string, blockHashes *[]string) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call, rewriting every block can take a while
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+	var empty emptypb.Empty
+	hashes, err := c.ReencryptBlocks(ctx, &empty)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	*blockHashes = hashes.Hashes
+	// close the connection
+	return conn.Close()
+}
+
+func (surfClient *RPCClient) GetCodecs(blockStoreAddr string, codecs *[]Codec) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var empty emptypb.Empty
+	supported, err := c.GetCodecs(ctx, &empty)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	*codecs = supported.Codecs
+	// close the connection
+	return conn.Close()
+}
+
+func (surfClient *RPCClient) GetCompressionStats(blockStoreAddr string, stats *CompressionStats) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var empty emptypb.Empty
+	serverStats, err := c.GetCompressionStats(ctx, &empty)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	stats.UncompressedBytes = serverStats.UncompressedBytes
+	stats.StoredBytes = serverStats.StoredBytes
+	// close the connection
+	return conn.Close()
+}
+
+func (surfClient *RPCClient) GetStats(blockStoreAddr string, stats *BlockStoreStats) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	var empty emptypb.Empty
+	serverStats, err := c.GetStats(ctx, &empty)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	stats.BlockCount = serverStats.BlockCount
+	stats.BytesUsed = serverStats.BytesUsed
+	stats.CapacityBytes = serverStats.CapacityBytes
+	stats.FreeBytes = serverStats.FreeBytes
+	stats.CacheHits = serverStats.CacheHits
+	stats.CacheMisses = serverStats.CacheMisses
+	// close the connection
+	return conn.Close()
+}
+
 func (surfClient *RPCClient) GetBlock(blockHash string, blockStoreAddr string, block *Block) error {
 	// connect to the server
 	addr := blockStoreAddr
@@ -129,6 +405,7 @@ func (surfClient *RPCClient) GetBlock(blockHash string, blockStoreAddr string, b
 	}
 	block.BlockData = b.BlockData
 	block.BlockSize = b.BlockSize
+	block.Codec = b.Codec
 
 	// close the connection
 	return conn.Close()
@@ -161,6 +438,85 @@ func (surfClient *RPCClient) PutBlock(block *Block, blockStoreAddr string, succ
 	return conn.Close()
 }
 
+func (surfClient *RPCClient) GetBlocks(blockHashesIn []string, blockStoreAddr string, blocks *[]*Block) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.GetBlocks(ctx, &BlockHashes{Hashes: blockHashesIn})
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	received := make([]*Block, 0, len(blockHashesIn))
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			conn.Close()
+			return err
+		}
+		received = append(received, block)
+	}
+	*blocks = received
+
+	// close the connection
+	return conn.Close()
+}
+
+func (surfClient *RPCClient) PutBlocks(blocks []*Block, blockStoreAddr string, succ *bool) error {
+	// connect to the server
+	addr := blockStoreAddr
+	if strings.Contains(blockStoreAddr, "blockstore") {
+		addr = strings.Replace(blockStoreAddr, "blockstore", "", -1)
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return err
+	}
+	c := NewBlockStoreClient(conn)
+
+	// perform the call
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	stream, err := c.PutBlocks(ctx)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	for _, block := range blocks {
+		if err := stream.Send(block); err != nil {
+			conn.Close()
+			return err
+		}
+	}
+	success, err := stream.CloseAndRecv()
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	*succ = success.Flag
+
+	// close the connection
+	return conn.Close()
+}
+
 func (surfClient *RPCClient) HasBlocks(blockHashesIn []string, blockStoreAddr string, blockHashesOut *[]string) error {
 	// connect to the server
 	addr := blockStoreAddr
@@ -0,0 +1,53 @@
+package surfstore
+
+import (
+	"fmt"
+	pathpkg "path"
+	"sort"
+	"strings"
+)
+
+// SelectiveSync limits a client to some directories of the server. Files
+// outside them are neither downloaded nor uploaded, but their latest remote
+// metadata is kept in index.db and marked unmaterialized, so their absence is
+// not taken for a deletion, and they are downloaded once selected.
+type SelectiveSync struct {
+	Prefixes       []string        // selected directories, without slashes at the ends; none selects everything
+	Unmaterialized map[string]bool // keys tracked in index.db that are not on disk
+}
+
+// ParseSelection cleans up the directories to select, where an empty list
+// or the base directory itself selects everything
+func ParseSelection(dirs []string) ([]string, error) {
+	prefixes := make([]string, 0, len(dirs))
+	for _, dir := range dirs {
+		prefix := strings.Trim(dir, "/")
+		if prefix == "" || prefix == "." {
+			return []string{}, nil
+		}
+		if !isValidSyncKey(prefix) {
+			return nil, fmt.Errorf("%q is not a relative path inside the base directory", dir)
+		}
+		prefixes = append(prefixes, pathpkg.Clean(prefix))
+	}
+	sort.Strings(prefixes)
+	return prefixes, nil
+}
+
+// Selected reports whether the file or directory with the sync key is synced:
+// it is in a selected directory, or is a directory holding one
+func (s *SelectiveSync) Selected(key string) bool {
+	if s == nil || len(s.Prefixes) == 0 || key == "" {
+		return true
+	}
+	trimmed := strings.TrimSuffix(key, "/")
+	for _, prefix := range s.Prefixes {
+		if trimmed == prefix || strings.HasPrefix(trimmed, prefix+"/") {
+			return true
+		}
+		if isDirectoryKey(key) && strings.HasPrefix(prefix, key) {
+			return true
+		}
+	}
+	return false
+}
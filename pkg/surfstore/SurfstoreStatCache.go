@@ -0,0 +1,36 @@
+package surfstore
+
+import (
+	"io/fs"
+	"time"
+)
+
+// Files changed this close to a scan may change again within the same tick
+// of the filesystem clock without their stat changing, so are not cached
+const RACY_STAT_WINDOW time.Duration = time.Second
+
+// What a file looked like when it was last hashed. A file whose stat is the
+// one recorded in index.db is taken to still have the hashes recorded there.
+type FileStat struct {
+	Size    int64
+	ModTime int64 // nanoseconds since the epoch
+	Inode   uint64
+}
+
+func getFileStat(info fs.FileInfo) FileStat {
+	return FileStat{Size: info.Size(), ModTime: info.ModTime().UnixNano(), Inode: getInode(info)}
+}
+
+// The hashes recorded in index.db for a file, if it has not changed since
+func getCachedHashes(filename string, stat FileStat, fileStats map[string]FileStat, indexMetadata *FileMetaData) ([]string, bool) {
+	cached, ok := fileStats[filename]
+	if !ok || cached != stat || indexMetadata == nil || wasDeleted(indexMetadata) {
+		return nil, false
+	}
+	return append(make([]string, 0, len(indexMetadata.BlockHashList)), indexMetadata.BlockHashList...), true
+}
+
+// Whether the stat of a file seen at scanStarted can be trusted next time
+func isCacheableStat(stat FileStat, scanStarted time.Time) bool {
+	return stat.ModTime < scanStarted.Add(-RACY_STAT_WINDOW).UnixNano()
+}
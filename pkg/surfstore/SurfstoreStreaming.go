@@ -0,0 +1,195 @@
+package surfstore
+
+import (
+	"bytes"
+	context "context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+// Files are hashed, uploaded and downloaded a block at a time, so a sync of
+// any size holds about this many bytes of blocks in memory
+const STREAM_MEMORY_BUDGET int = 16 << 20
+
+var ERR_FILE_CHANGED = fmt.Errorf("file changed while it was uploaded")
+var ERR_TOO_LARGE = fmt.Errorf("file is too large")
+
+// Where the blocks of an upload are read from, visiting each block with its
+// hash in order. A block is only valid until visit returns.
+type blockSource func(visit func(hash string, data []byte) error) error
+
+// The blocks of the local file at path, read a block at a time
+func localFileBlocks(path string, chunker Chunker, client RPCClient) blockSource {
+	return func(visit func(hash string, data []byte) error) error {
+		return forEachLocalChunk(path, chunker, func(chunk []byte) error {
+			return visit(getLocalBlockHash(chunk, client), chunk)
+		})
+	}
+}
+
+// Blocks already in memory, where blocks[i] holds the data for hashes[i]
+func memoryBlocks(hashes []string, blocks []*Block) blockSource {
+	return func(visit func(hash string, data []byte) error) error {
+		for i, hash := range hashes {
+			if err := visit(hash, blocks[i].BlockData); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func forEachLocalChunk(path string, chunker Chunker, visit func(chunk []byte) error) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return ReadChunks(file, chunker, visit)
+}
+
+// Where a block of a local file is
+type chunkLocation struct {
+	offset int64
+	size   int64
+}
+
+// Find the blocks of the local file at path, which has localHashes when split
+// with chunker, reading it once. If the file no longer has as many blocks, it
+// changed since it was hashed and none of it is reused.
+func locateLocalChunks(path string, chunker Chunker, localHashes []string) map[string]chunkLocation {
+	locations := make(map[string]chunkLocation)
+	if len(localHashes) == 0 {
+		return locations
+	}
+	var offset int64
+	idx := 0
+	err := forEachLocalChunk(path, chunker, func(chunk []byte) error {
+		if idx >= len(localHashes) {
+			return ERR_FILE_CHANGED
+		}
+		if _, seen := locations[localHashes[idx]]; !seen {
+			locations[localHashes[idx]] = chunkLocation{offset: offset, size: int64(len(chunk))}
+		}
+		offset += int64(len(chunk))
+		idx++
+		return nil
+	})
+	if err != nil || idx != len(localHashes) {
+		return make(map[string]chunkLocation)
+	}
+	return locations
+}
+
+// Write the file with the given hashes to w, copying the blocks the local file
+// at path has, which has localHashes when split with chunker, and downloading
+// the others a window of blocks at a time
+func writeRemoteFile(w io.Writer, path string, chunker Chunker, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, hashList []string, localHashes []string) error {
+	locations := locateLocalChunks(path, chunker, localHashes)
+	var localFile *os.File
+	if len(locations) > 0 {
+		var err error
+		if localFile, err = os.Open(path); err != nil {
+			return err
+		}
+		defer localFile.Close()
+	}
+
+	window := STREAM_MEMORY_BUDGET / chunker.MaxChunkSize()
+	if window < 1 {
+		window = 1
+	}
+	for start := 0; start < len(hashList); start += window {
+		end := start + window
+		if end > len(hashList) {
+			end = len(hashList)
+		}
+		fetchedBlocks := fetchBlocks(hashList[start:end], locations, blockStoreMap, client, ctx)
+		for _, hash := range hashList[start:end] {
+			if data, fetched := fetchedBlocks[hash]; fetched {
+				if _, err := w.Write(data); err != nil {
+					return err
+				}
+				continue
+			}
+			location := locations[hash]
+			if _, err := io.Copy(w, io.NewSectionReader(localFile, location.offset, location.size)); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// Download the blocks among hashes not in the local file, from the client-local
+// cache or the server holding them
+func fetchBlocks(hashes []string, locations map[string]chunkLocation, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context) map[string][]byte {
+	fetchedBlocks := make(map[string][]byte)
+	wanted := make([]string, 0)
+	for _, hash := range hashes {
+		if _, local := locations[hash]; local {
+			continue
+		}
+		if _, seen := fetchedBlocks[hash]; seen {
+			continue
+		}
+		if data, cached := getCachedBlock(hash, client); cached {
+			fetchedBlocks[hash] = data
+			continue
+		}
+		wanted = append(wanted, hash)
+		fetchedBlocks[hash] = nil
+	}
+	if len(wanted) == 0 {
+		return fetchedBlocks
+	}
+
+	// group the blocks by the server holding them
+	responsibleServers := make(map[string][]string)
+	checkError(client.GetBlockStoreMap(wanted, &responsibleServers))
+	missingHashes := make(map[string][]string)
+	for _, hash := range wanted {
+		blockStoreAddr := returnServerAddrForHash(responsibleServers, hash)
+		if blockStoreAddr == "" {
+			log.Fatal("Invalid addr in fetchBlocks\n")
+		}
+		missingHashes[blockStoreAddr] = append(missingHashes[blockStoreAddr], hash)
+	}
+
+	// one GetBlocks stream per server, blocks come back in request order. The
+	// stream has no deadline, it takes as long as the blocks need.
+	for blockStoreAddr, hashes := range missingHashes {
+		blockStoreC := (*blockStoreMap)[blockStoreAddr]
+		streamCtx, cancel := context.WithCancel(ctx)
+		stream, err := blockStoreC.GetBlocks(streamCtx, &BlockHashes{Hashes: hashes})
+		checkError(err)
+		for _, hash := range hashes {
+			block, err := stream.Recv()
+			checkError(err)
+			if len(block.BlockData) == 0 {
+				// placement changed since the block was put, look on the other servers
+				block = findBlockOnOtherServers(hash, blockStoreAddr, blockStoreMap, ctx)
+			}
+			fetchedBlocks[hash], err = decodeBlock(block, hash, client)
+			checkError(err)
+			cacheBlock(hash, fetchedBlocks[hash], client)
+		}
+		cancel()
+	}
+	return fetchedBlocks
+}
+
+// A buffer refusing to hold more than limit bytes
+type cappedBuffer struct {
+	bytes.Buffer
+	limit int
+}
+
+func (b *cappedBuffer) Write(p []byte) (int, error) {
+	if b.Len()+len(p) > b.limit {
+		return 0, ERR_TOO_LARGE
+	}
+	return b.Buffer.Write(p)
+}
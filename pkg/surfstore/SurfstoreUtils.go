@@ -3,12 +3,14 @@ package surfstore
 import (
 	context "context"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
-	"math"
 	"os"
+	pathpkg "path"
 	"path/filepath"
 	"reflect"
+	"sort"
 	"strings"
 	"time"
 
@@ -28,52 +30,88 @@ func ClientSync(client RPCClient) {
 	}
 	//	fmt.Printf("baseDirPath: %s, client.BaseDir: %s\n", baseDirPath, client.BaseDir)
 
+	indexFileMetaMap, err := LoadMetaFromMetaFile(client.BaseDir)
+	checkError(err)
+
+	// pick up where a sync that did not finish left off
+	if client.DryRun {
+		journalEntries, err := LoadJournal(client.BaseDir)
+		checkError(err)
+		applyJournal(journalEntries, indexFileMetaMap)
+	} else {
+		client.Journal, err = OpenJournal(client.BaseDir)
+		checkError(err)
+		defer client.Journal.Close()
+		client.Journal.Resume(indexFileMetaMap)
+	}
+
+	selection, err := LoadSelectiveSync(client.BaseDir)
+	checkError(err)
+	if client.Selection != nil {
+		selection.Prefixes, err = ParseSelection(client.Selection)
+		checkError(err)
+	}
+
+	// the global ignore file, then each directory's .surfignore as the walk enters it
+	ignoreRules := NewIgnoreRules()
+	if client.IgnoreFile != "" {
+		checkError(ignoreRules.LoadFile(client.IgnoreFile, ""))
+	}
+
+	// files whose stat did not change since they were hashed are not read again
+	fileStats := make(map[string]FileStat)
+	if !client.FullRescan {
+		fileStats, err = LoadFileStats(client.BaseDir)
+		checkError(err)
+	}
+	walkedStats := make(map[string]FileStat)
+	scanStarted := time.Now()
+
 	/* Start updating local index.db file with local changes */
 	localFileMetaMap := make(map[string]*FileMetaData)
-	err := filepath.Walk(client.BaseDir, func(path string, info fs.FileInfo, err error) error {
+	err = filepath.Walk(client.BaseDir, func(path string, info fs.FileInfo, err error) error {
 		if err != nil {
 			fmt.Printf("prevent panic by handling failure accessing a path %q: %v\n", path, err)
 			return err
 		}
-		if !info.IsDir() {
-			filename := filepath.Base(path)
+		filename, err := getSyncKey(client.BaseDir, path, info.IsDir())
+		if err != nil {
+			return err
+		}
+		if strings.Contains(filename, CONFIG_DELIMITER) || ignoreRules.Ignored(filename) || !selection.Selected(filename) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			if err := ignoreRules.LoadFile(filepath.Join(path, IGNORE_FILENAME), filename); err != nil {
+				return err
+			}
+		}
+		if filename == "" { // the base directory itself
+			return nil
+		}
+
+		if info.IsDir() {
+			localFileMetaMap[filename] = &FileMetaData{Filename: filename, BlockHashList: []string{DIRECTORY_HASHVALUE}}
+		} else {
 			//			fmt.Printf("visited file: %q\n", filename)
-			if strings.Contains(filename, DEFAULT_META_FILENAME) ||
-				strings.Contains(filename, CONFIG_DELIMITER) ||
-				strings.Contains(filename, "/") {
+			if isSyncStateFile(filepath.Base(path)) {
 				return nil
 			}
 
-			file, err := os.Open(path)
-			if err != nil {
-				log.Fatal(err)
+			// split the file the way it was split when last synced
+			chunker := getFileChunker(client, indexFileMetaMap[filename])
+			stat := getFileStat(info)
+			hashes, cached := getCachedHashes(filename, stat, fileStats, indexFileMetaMap[filename])
+			if !cached {
+				hashes = getLocalHashes(path, client, chunker)
 			}
-			defer file.Close()
-
-			var metadata = FileMetaData{Filename: filename, BlockHashList: make([]string, 0)}
-
-			fileInfo, _ := file.Stat()
-			var fileSize int64 = fileInfo.Size()
-			numChunks := uint64(math.Ceil(float64(fileSize) / float64(client.BlockSize)))
-
-			for i := uint64(0); i < numChunks; i++ {
-				chunkSize := client.BlockSize
-				if i == numChunks-1 { // last chunk may not be full blocksize
-					chunkSize = int(fileSize) % client.BlockSize
-				}
-
-				buf := make([]byte, chunkSize)
-				bytesRead, err := file.Read(buf)
-				if err != nil {
-					fmt.Printf("Error reading %s\n", path)
-					continue
-				}
-
-				chunk := buf[:bytesRead]
-				hash := GetBlockHashString(chunk)
-				//				fmt.Printf("Hash: %s\n", hash)
-				metadata.BlockHashList = append(metadata.BlockHashList, hash)
+			if isCacheableStat(stat, scanStarted) {
+				walkedStats[filename] = stat
 			}
+			var metadata = FileMetaData{Filename: filename, BlockHashList: hashes, Chunker: chunker.Spec()}
 			localFileMetaMap[filename] = &metadata
 			//			metadata.Version = 1
 			//			fmt.Printf("list: %s\n", localFileMetaMap[filename].BlockHashList)
@@ -85,13 +123,29 @@ func ClientSync(client RPCClient) {
 	}
 	//fmt.Printf("Done updating local index.db file with local changes\n")
 
-	indexFileMetaMap, err := LoadMetaFromMetaFile(client.BaseDir)
-	checkError(err)
-
-	// Check if any files were deleted
+	// Check if any files were deleted. Ignored files are left alone, and stay in
+	// index.db in case they stop being ignored. Files outside the selection
+	// stay tracked, and unmaterialized ones that are selected again are
+	// downloaded like new remote files.
+	skippedFileMetaMap := make(map[string]*FileMetaData)
+	unmaterialized := make(map[string]bool)
 	for indexFilename, indexMetadata := range indexFileMetaMap {
+		if ignoreRules.Ignored(indexFilename) {
+			skippedFileMetaMap[indexFilename] = indexMetadata
+			unmaterialized[indexFilename] = selection.Unmaterialized[indexFilename]
+			continue
+		}
+		if !selection.Selected(indexFilename) {
+			skippedFileMetaMap[indexFilename] = indexMetadata
+			unmaterialized[indexFilename] = true
+			continue
+		}
+		if selection.Unmaterialized[indexFilename] {
+			delete(indexFileMetaMap, indexFilename)
+			continue
+		}
 		_, filenameExistsInLocal := localFileMetaMap[indexFilename]
-		if !filenameExistsInLocal && !wasDeleted(*indexMetadata) { // File was deleted
+		if !filenameExistsInLocal && !wasDeleted(indexMetadata) { // File was deleted
 			indexMetadata.BlockHashList = []string{TOMBSTONE_HASHVALUE}
 			localFileMetaMap[indexFilename] = indexMetadata
 			//			fmt.Printf("%s was deleted from local system.\n", indexFilename)
@@ -102,9 +156,9 @@ func ClientSync(client RPCClient) {
 	/* Done updating local index.db file with local changes */
 	//fmt.Printf("Done searching for deleted files on local\n")
 
-	// perform the call
-	var empty emptypb.Empty
-	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	// block transfers take as long as the files need, so the sync has no
+	// deadline and unary BlockStore calls set their own
+	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Download remote fileInfoMap
@@ -128,7 +182,7 @@ func ClientSync(client RPCClient) {
 	if err == ERR_SERVER_CRASHED {
 		return
 	}
-	fmt.Printf("Done retrieving getFileInfoMap\n")
+	log.Printf("Done retrieving getFileInfoMap\n")
 	/*fmt.Printf("Done retrieving getFileInfoMap\n")
 	remoteFileMetaMap := *remoteFileInfoMap.FileInfoMap*/
 
@@ -148,26 +202,57 @@ func ClientSync(client RPCClient) {
 		blockStoreC := NewBlockStoreClient(blockStoreConn)
 		blockStoreMap[addr] = blockStoreC
 	}
-	fmt.Printf("Done getting BlockStoreClients\n")
+	log.Printf("Done getting BlockStoreClients\n")
+	client.Codec = negotiateCodec(client.Codec, &blockStoreMap, ctx)
+	var compressionStats CompressionStats
+
+	// directories deleted on the server are removed once the files in them are
+	directoriesToRemove := make([]string, 0)
+	// conflicted copies join localFileMetaMap after the loop, so it does not visit them
+	conflictCopies := make(map[string]*FileMetaData)
+	var summary SyncSummary
+	var plan SyncPlan // what a dry run would have done
 
 	// fmt.Printf("\n")
 	for localFilename, localMetadata := range localFileMetaMap {
 		//		fmt.Printf("\n")
+		localPath := getLocalPath(baseDirPath, localFilename)
 		remoteMetaData, filenameExistsInRemote := remoteFileMetaMap[localFilename]
+		// a file on the server is split the way it was uploaded, so the hashes compare
+		chunker := getFileChunker(client, remoteMetaData, indexFileMetaMap[localFilename])
 		var localHashes = make([]string, 0)
-		var localBlocks = make([]Block, 0)
-		if !wasDeleted(*localMetadata) {
-			getLocalHashesAndBlocks(baseDirPath+localFilename, client.BlockSize, &localHashes, &localBlocks)
+		var localSizes = make([]int, 0) // only for the plan of a dry run
+		if isDirectoryKey(localFilename) && !wasDeleted(localMetadata) {
+			localHashes = append(localHashes, DIRECTORY_HASHVALUE)
+			chunker = FixedChunker{BlockSize: client.BlockSize}
+		} else if !wasDeleted(localMetadata) {
+			if localMetadata.Chunker == chunker.Spec() { // hashed by the walk
+				localHashes = append(localHashes, localMetadata.BlockHashList...)
+			} else {
+				localHashes = getLocalHashes(localPath, client, chunker)
+			}
+			// blocks are read from the file as they are uploaded, a dry run only needs their sizes
+			if client.DryRun && (!filenameExistsInRemote || !reflect.DeepEqual(localHashes, remoteMetaData.BlockHashList)) {
+				localHashes = make([]string, 0)
+				getLocalHashesAndSizes(localPath, client, chunker, &localHashes, &localSizes)
+			}
 		} else {
 			localHashes = append(localHashes, "0")
 		}
 		localModification := !reflect.DeepEqual(localHashes, localMetadata.BlockHashList)
-		if (filenameExistsInRemote && wasDeleted(*remoteMetaData)) || wasDeleted(*localMetadata) {
+		if (filenameExistsInRemote && wasDeleted(remoteMetaData)) || wasDeleted(localMetadata) {
 			localModification = true
 		}
 		if localModification {
 			localMetadata.BlockHashList = localHashes
 		}
+		localMetadata.Chunker = chunker.Spec()
+
+		// edits since the last sync that a newer version on the server would overwrite
+		indexMetadata := indexFileMetaMap[localFilename]
+		editedSinceSync := !wasDeleted(localMetadata) && !isDirectoryKey(localFilename) &&
+			(indexMetadata == nil || !reflect.DeepEqual(localHashes, indexMetadata.BlockHashList))
+		locallyEdited := keepsLocalEdits(client.ConflictPolicy) && editedSinceSync
 
 		differFromRemote := true
 		if filenameExistsInRemote {
@@ -176,15 +261,24 @@ func ClientSync(client RPCClient) {
 		}
 		//		fmt.Printf("localModification: %t. differFromRemote: %t\n", localModification, differFromRemote)
 
+		if client.DryRun {
+			plan.planLocalFile(localFilename, localMetadata, remoteMetaData, localModification, differFromRemote, editedSinceSync, localHashes, localSizes, chunker, client)
+			continue
+		}
+
 		// Local file not in remote. Upload it
 		if !filenameExistsInRemote {
 			//fmt.Printf("%s does not exist in remote\n", localFilename)
 			localFileMetaMap[localFilename].Version = 1
+			client.Journal.Planned(localFilename, PLAN_UPLOAD)
 
 			// Upload blocks
-			if !uploadBlocks(localHashes, localBlocks, &blockStoreMap, client, ctx) {
+			err = uploadBlocks(localFilename, getHashesToUpload(localFilename, localHashes), localFileBlocks(localPath, chunker, client), &blockStoreMap, client, ctx, &compressionStats)
+			if err == ERR_FILE_CHANGED {
+				skipChangedFile(localFilename, indexFileMetaMap, localFileMetaMap)
+			} else if err != nil {
 				// handle error
-				log.Fatal("Had an error\n")
+				log.Fatalf("Had an error: %s\n", err.Error())
 			} else { // Try to upload metadata
 				//fmt.Printf("Update metaStoreC with localMetadata (%d): %v\n", len(localMetadata.BlockHashList), localMetadata)
 				//sort.Strings(localMetadata.BlockHashList)
@@ -193,40 +287,69 @@ func ClientSync(client RPCClient) {
 				err = client.UpdateFile(localMetadata, &returnVersion)
 				checkError(err)
 				//PrintNumOnEachServer(&blockStoreMap, ctx, empty)
-				if returnVersion == -1 { // Someone uploaded newer version of this file. Handle conflict.
-					/*updatedRemoteMeta := */ handleNewerVersionOnServer(baseDirPath+localFilename, client.BlockSize, &blockStoreMap, client, ctx, *remoteMetaData, indexFileMetaMap, localHashes, empty)
-					//indexFileMetaMap[localFilename] = &updatedRemoteMeta
+				if returnVersion == -1 && locallyEdited { // Someone uploaded this file too. Keep both or merge.
+					resolveConflict(localPath, localFilename, chunker, localHashes, indexMetadata, &blockStoreMap, client, ctx, &compressionStats, localFileMetaMap, conflictCopies, &summary)
+				} else if returnVersion == -1 { // Someone uploaded newer version of this file. Handle conflict.
+					// the file was not on the server when this sync started, take the version that was uploaded
+					currentFileMetaMap := make(map[string]*FileMetaData)
+					checkError(client.GetFileInfoMap(&currentFileMetaMap))
+					if currentMetaData := currentFileMetaMap[localFilename]; currentMetaData != nil && !wasDeleted(currentMetaData) {
+						handleNewerVersionOnServer(localPath, localFilename, chunker, &blockStoreMap, client, ctx, currentMetaData, localFileMetaMap, localHashes)
+						summary.Downloaded++
+					}
+				} else {
+					client.Journal.Committed(localMetadata)
+					summary.Uploaded++
 				}
 			}
 			//			fmt.Printf("%s version num: %d\n", localFilename, localMetadata.Version)
 			//uploadFile(client.BaseDir+localFilename, client.BlockSize, blockStoreC, metaStoreC, ctx, localMetadata.Version, *remoteMetaData, indexFileMetaMap, empty)
-		} else if remoteMetaData.Version > localMetadata.Version && wasDeleted(*remoteMetaData) { // File was deleted from the remote system, but still present on local
+		} else if remoteMetaData.Version > localMetadata.Version && locallyEdited && differFromRemote { // Changed on both sides. Keep both or merge.
+			client.Journal.Planned(localFilename, PLAN_CONFLICT)
+			resolveConflict(localPath, localFilename, chunker, localHashes, indexMetadata, &blockStoreMap, client, ctx, &compressionStats, localFileMetaMap, conflictCopies, &summary)
+		} else if remoteMetaData.Version > localMetadata.Version && wasDeleted(remoteMetaData) { // File was deleted from the remote system, but still present on local
 			//fmt.Printf("%s was deleted in remote, but not on local\n", localFilename)
 			localFileMetaMap[localFilename] = remoteMetaData
-			err = os.Remove(baseDirPath + localFilename)
-			checkError(err)
+			if isDirectoryKey(localFilename) {
+				directoriesToRemove = append(directoriesToRemove, localPath)
+			} else {
+				client.Journal.Planned(localFilename, PLAN_DELETE_LOCAL)
+				err = os.Remove(localPath)
+				checkError(err)
+				client.Journal.Removed(remoteMetaData)
+			}
+			summary.Removed++
 		} else if remoteMetaData.Version > localMetadata.Version {
 			/* The remote file is a higher version than the local version, bring the local version up to date with the remote
 			by downloading any necessary blocks. */
 			//fmt.Printf("%s has higher version number in remote than in local\n", localFilename)
-			bringLocalFileUpToDateWithRemote(baseDirPath+localFilename, client.BlockSize, &blockStoreMap, client, ctx, *remoteMetaData, localHashes)
-			err = client.GetFileInfoMap(&remoteFileMetaMap)
-			checkError(err)
-			localFileMetaMap[localFilename] = remoteFileMetaMap[localFilename]
+			client.Journal.Planned(localFilename, PLAN_DOWNLOAD)
+			if bringLocalFileUpToDateWithRemote(localPath, chunker, &blockStoreMap, client, ctx, remoteMetaData, localHashes) {
+				err = client.GetFileInfoMap(&remoteFileMetaMap)
+				checkError(err)
+				localFileMetaMap[localFilename] = remoteFileMetaMap[localFilename]
+				summary.Downloaded++
+			} // otherwise the local edits are a conflict for the next sync
 		} else if remoteMetaData.Version <= localMetadata.Version && (localModification || differFromRemote) { // Remote version should never be less than local
 			/* The local version has local modifications while both the remote and the local are the same version. Upload the
 			differing blocks onto the remote server. */
 			//fmt.Printf("%s has modifications on local and has same version in remote\n", localFilename)
-			success := true
-			if !wasDeleted(*localMetadata) {
+			if wasDeleted(localMetadata) {
+				client.Journal.Planned(localFilename, PLAN_DELETE_REMOTE)
+			} else {
+				client.Journal.Planned(localFilename, PLAN_UPLOAD)
+			}
+			var uploadErr error
+			if !wasDeleted(localMetadata) && !isDirectoryKey(localFilename) {
 				remoteMissingHashes := getMissingHashesFromLocalAndRemote(localHashes, remoteMetaData, client, ctx) // hashes missing from remote
-				var blocksToUpload = make([]Block, 0)
-				getBlocksFromHashes(remoteMissingHashes, localBlocks, &blocksToUpload) // get blocks corresponding to hashes
 				//fmt.Printf("Upload blocks: %v\n", localHashes)
-				success = uploadBlocks(localHashes, blocksToUpload, &blockStoreMap, client, ctx)
+				uploadErr = uploadBlocks(localFilename, remoteMissingHashes, localFileBlocks(localPath, chunker, client), &blockStoreMap, client, ctx, &compressionStats)
 			}
-			if !success {
-				log.Fatal("Errored uploading blocks")
+			if uploadErr == ERR_FILE_CHANGED {
+				skipChangedFile(localFilename, indexFileMetaMap, localFileMetaMap)
+				continue
+			} else if uploadErr != nil {
+				log.Fatalf("Errored uploading blocks: %s\n", uploadErr.Error())
 			}
 			//fmt.Printf("%s localMetadata.Version: %d\n", localFilename, localMetadata.Version)
 			localMetadata.Version += 1
@@ -234,9 +357,17 @@ func ClientSync(client RPCClient) {
 			err = client.UpdateFile(localMetadata, &returnVersion)
 			//fmt.Printf("%s version num: %d\n", localFilename, localMetadata.Version)
 			//fmt.Printf("Err: %s\n", err)
-			if returnVersion == -1 { // Someone uploaded newer version of this file. Handle conflict.
-				/*updatedRemoteMeta := */ handleNewerVersionOnServer(baseDirPath+localFilename, client.BlockSize, &blockStoreMap, client, ctx, *remoteMetaData, localFileMetaMap, localHashes, empty)
+			if returnVersion == -1 && locallyEdited { // Someone uploaded newer version of this file. Keep both or merge.
+				resolveConflict(localPath, localFilename, chunker, localHashes, indexMetadata, &blockStoreMap, client, ctx, &compressionStats, localFileMetaMap, conflictCopies, &summary)
+			} else if returnVersion == -1 { // Someone uploaded newer version of this file. Handle conflict.
+				/*updatedRemoteMeta := */ handleNewerVersionOnServer(localPath, localFilename, chunker, &blockStoreMap, client, ctx, remoteMetaData, localFileMetaMap, localHashes)
 				//indexFileMetaMap[localFilename] = &updatedRemoteMeta
+				summary.Downloaded++
+			} else {
+				if err == nil {
+					client.Journal.Committed(localMetadata)
+				}
+				summary.Uploaded++
 			}
 		} /* else {
 			fmt.Printf("%s has no detected changes on local or remote.\n", localFilename)
@@ -245,6 +376,9 @@ func ClientSync(client RPCClient) {
 		//fmt.Printf("%v\n", localMetadata)
 	}
 	//fmt.Printf("\nDone running through local and remote comparison\n")
+	for copyName, copyMetadata := range conflictCopies {
+		localFileMetaMap[copyName] = copyMetadata
+	}
 
 	// check for remote files not present on local system
 	for remoteFilename, remoteMetadata := range remoteFileMetaMap {
@@ -252,29 +386,106 @@ func ClientSync(client RPCClient) {
 		//localHashes, localBlocks := getLocalHashesAndBlocks(client.BaseDir+localFilename, client.BlockSize)
 		//localModification := !reflect.DeepEqual(localHashes, localMetadata.BlockHashList)
 
+		if !filenameExistsInLocal && ignoreRules.Ignored(remoteFilename) {
+			continue
+		}
+		if !filenameExistsInLocal && !selection.Selected(remoteFilename) { // tracked, not downloaded
+			skippedFileMetaMap[remoteFilename] = remoteMetadata
+			unmaterialized[remoteFilename] = true
+			continue
+		}
 		if !filenameExistsInLocal { // Download remote file to local
-			if !wasDeleted(*remoteMetadata) {
+			if !isValidSyncKey(remoteFilename) {
+				log.Printf("Skipping %q, it is not a relative path inside the base directory\n", remoteFilename)
+				continue
+			}
+			if client.DryRun {
+				plan.planRemoteFile(remoteFilename, remoteMetadata, client)
+				continue
+			}
+			if !wasDeleted(remoteMetadata) {
 				//				fmt.Printf("%s doesn't exist on local\n", remoteFilename)
-				bringLocalFileUpToDateWithRemote(baseDirPath+remoteFilename, client.BlockSize, &blockStoreMap, client, ctx, *remoteMetadata, []string{})
+				client.Journal.Planned(remoteFilename, PLAN_DOWNLOAD)
+				if !bringLocalFileUpToDateWithRemote(getLocalPath(baseDirPath, remoteFilename), getFileChunker(client, remoteMetadata), &blockStoreMap, client, ctx, remoteMetadata, []string{}) {
+					continue // created locally meanwhile, a conflict for the next sync
+				}
+				summary.Downloaded++
 			}
 			localFileMetaMap[remoteFilename] = remoteMetadata
 		}
 	}
+
+	if client.DryRun { // nothing was changed, index.db included
+		checkError(plan.Write(os.Stdout, client.PlanFormat))
+		return
+	}
+
+	for skippedFilename, skippedMetadata := range skippedFileMetaMap {
+		localFileMetaMap[skippedFilename] = skippedMetadata
+	}
+
+	// deepest first, and only if nothing untracked is left in them
+	sort.Slice(directoriesToRemove, func(i, j int) bool { return len(directoriesToRemove[i]) > len(directoriesToRemove[j]) })
+	for _, directory := range directoriesToRemove {
+		if err := os.Remove(directory); err != nil {
+			log.Printf("Could not remove %s: %s\n", directory, err.Error())
+		}
+	}
 	//	fmt.Printf("Done running through remote and local comparison\n")
 
+	if compressionStats.StoredBytes > 0 {
+		log.Printf("Uploaded %d bytes as %d bytes with %s (%.2fx)\n", compressionStats.UncompressedBytes, compressionStats.StoredBytes,
+			client.Codec, float64(compressionStats.UncompressedBytes)/float64(compressionStats.StoredBytes))
+	}
+
 	err = WriteMetaFile(localFileMetaMap, baseDirPath)
 	checkError(err)
+	err = WriteFileStats(walkedStats, baseDirPath)
+	checkError(err)
+	for filename, isUnmaterialized := range unmaterialized {
+		if !isUnmaterialized {
+			delete(unmaterialized, filename)
+		}
+	}
+	selection.Unmaterialized = unmaterialized
+	err = WriteSelectiveSync(selection, baseDirPath)
+	checkError(err)
+	err = client.Journal.Clear()
+	checkError(err)
+	fmt.Print(summary)
 }
 
-func PrintNumOnEachServer(blockStoreMap *map[string]BlockStoreClient, ctx context.Context, empty emptypb.Empty) {
+// What a sync changed, printed when it finishes
+type SyncSummary struct {
+	Uploaded   int
+	Downloaded int
+	Removed    int
+	Merged     []string
+	Conflicts  []ConflictCopy
+}
+
+func (summary SyncSummary) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Sync summary: %d uploaded, %d downloaded, %d removed, %d merged, %d conflicts\n",
+		summary.Uploaded, summary.Downloaded, summary.Removed, len(summary.Merged), len(summary.Conflicts))
+	for _, filename := range summary.Merged {
+		fmt.Fprintf(&b, "  merged: %s changed on both sides, edits merged\n", filename)
+	}
+	for _, conflict := range summary.Conflicts {
+		fmt.Fprintf(&b, "  conflict: %s changed on both sides, local edits kept as %s\n", conflict.Filename, conflict.CopyName)
+	}
+	return b.String()
+}
+
+func PrintNumOnEachServer(blockStoreMap *map[string]BlockStoreClient, ctx context.Context, empty *emptypb.Empty) {
 	for k, v := range *blockStoreMap {
-		blockHashes, err := v.GetBlockHashes(ctx, &empty)
+		blockHashes, err := v.GetBlockHashes(ctx, empty)
 		checkError(err)
 		fmt.Printf("%s: %d hashes\n", k, len(blockHashes.Hashes))
 	}
 }
 
-func wasDeleted(localMetadata FileMetaData) bool {
+func wasDeleted(localMetadata *FileMetaData) bool {
 	deleted := false
 	if len(localMetadata.BlockHashList) == 1 && localMetadata.BlockHashList[0] == TOMBSTONE_HASHVALUE {
 		deleted = true
@@ -283,21 +494,55 @@ func wasDeleted(localMetadata FileMetaData) bool {
 	return deleted
 }
 
-func handleNewerVersionOnServer(path string, blockSize int, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, remoteMetaData FileMetaData, indexFileMetaMap map[string]*FileMetaData, localHashes []string, empty emptypb.Empty) {
-	bringLocalFileUpToDateWithRemote(path, blockSize, blockStoreMap, client, ctx, remoteMetaData, localHashes)
+func handleNewerVersionOnServer(path string, filename string, chunker Chunker, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, remoteMetaData *FileMetaData, indexFileMetaMap map[string]*FileMetaData, localHashes []string) {
+	if !bringLocalFileUpToDateWithRemote(path, chunker, blockStoreMap, client, ctx, remoteMetaData, localHashes) {
+		return
+	}
 	remoteFileMetaMap := make(map[string]*FileMetaData)
 	err := client.GetFileInfoMap(&remoteFileMetaMap)
-	indexFileMetaMap[filepath.Base(path)] = remoteFileMetaMap[filepath.Base(path)]
+	indexFileMetaMap[filename] = remoteFileMetaMap[filename]
 	checkError(err)
 }
 
-func getBlocksFromHashes(targetHashes []string, localBlocks []Block, blockToUpload *[]Block) {
-	for _, block := range localBlocks {
-		blockhash := GetBlockHashString(block.BlockData)
-		if hashInHashList(targetHashes, blockhash) {
-			*blockToUpload = append(*blockToUpload, block)
-		}
+// Files are synced under their path relative to the base directory, with
+// forward slashes on every platform, and directories with a trailing slash.
+// The base directory itself has the empty key.
+func getSyncKey(baseDir string, path string, isDir bool) (string, error) {
+	relativePath, err := filepath.Rel(baseDir, path)
+	if err != nil {
+		return "", err
+	}
+	if relativePath == "." {
+		return "", nil
+	}
+	key := filepath.ToSlash(relativePath)
+	if isDir {
+		key += "/"
 	}
+	return key, nil
+}
+
+func isDirectoryKey(key string) bool {
+	return strings.HasSuffix(key, "/")
+}
+
+// A key from the server must stay inside the base directory
+func isValidSyncKey(key string) bool {
+	trimmed := strings.TrimSuffix(key, "/")
+	return trimmed != "" && trimmed == pathpkg.Clean(trimmed) && !pathpkg.IsAbs(trimmed) &&
+		trimmed != ".." && !strings.HasPrefix(trimmed, "../") && !strings.Contains(trimmed, "\\")
+}
+
+func getLocalPath(baseDirPath string, key string) string {
+	return filepath.Join(baseDirPath, filepath.FromSlash(strings.TrimSuffix(key, "/")))
+}
+
+// Directories have no blocks to upload
+func getHashesToUpload(filename string, hashes []string) []string {
+	if isDirectoryKey(filename) {
+		return []string{}
+	}
+	return hashes
 }
 
 // For use when uploading the different blocks to the server
@@ -311,36 +556,158 @@ func getMissingHashesFromLocalAndRemote(localHashes []string, remoteMetaData *Fi
 	return missingHashes
 }
 
-func uploadBlocks(hashes []string, blocks []Block, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context) bool {
+// The hash a block of a local file is stored under, keyed when encrypting
+func getLocalBlockHash(blockData []byte, client RPCClient) string {
+	if client.Cipher != nil {
+		return client.Cipher.HashBlock(blockData)
+	}
+	return GetBlockHashString(blockData)
+}
+
+// Compress and, if the client has a key, encrypt a local block for upload
+func encodeBlock(block *Block, hash string, client RPCClient) (*Block, error) {
+	encoded, err := CompressBlock(block, client.Codec)
+	if err != nil {
+		return nil, err
+	}
+	if client.Cipher != nil {
+		encoded = client.Cipher.Seal(encoded, hash)
+	}
+	return encoded, nil
+}
+
+// Undo encodeBlock on a block downloaded for hash
+func decodeBlock(block *Block, hash string, client RPCClient) ([]byte, error) {
+	data := block.BlockData
+	if block.Encrypted {
+		if client.Cipher == nil {
+			return nil, fmt.Errorf("block %s is encrypted but no key was given", hash)
+		}
+		var err error
+		if data, err = client.Cipher.Open(block, hash); err != nil {
+			return nil, err
+		}
+	}
+	return DecompressBlockData(data, block.Codec)
+}
+
+// Use the preferred codec only if every BlockStore accepts it
+func negotiateCodec(preferred Codec, blockStoreMap *map[string]BlockStoreClient, ctx context.Context) Codec {
+	if preferred == Codec_NONE {
+		return preferred
+	}
+
+	for addr, blockStoreC := range *blockStoreMap {
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		supported, err := blockStoreC.GetCodecs(callCtx, &emptypb.Empty{})
+		cancel()
+		if err != nil || !codecInCodecList(supported.Codecs, preferred) {
+			log.Printf("%s does not accept %s blocks, uploading uncompressed\n", addr, preferred)
+			return Codec_NONE
+		}
+	}
+	return preferred
+}
+
+func codecInCodecList(codecs []Codec, target Codec) bool {
+	for _, codec := range codecs {
+		if codec == target {
+			return true
+		}
+	}
+	return false
+}
+
+// Upload the blocks of filename with the given hashes, read from source,
+// streaming every block a BlockStore is responsible for over a single
+// PutBlocks call as it is read. Blocks are compressed with client.Codec and the
+// sizes added to stats. Blocks the journal says an unfinished sync uploaded are
+// skipped if they are still stored. Returns ERR_FILE_CHANGED if source lacks
+// some of the blocks.
+func uploadBlocks(filename string, hashes []string, source blockSource, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, stats *CompressionStats) error {
+	if len(hashes) == 0 {
+		return nil
+	}
+
 	// returns map of addr => hashes
 	responsibleServers := make(map[string][]string)
 	err := client.GetBlockStoreMap(hashes, &responsibleServers)
 	checkError(err)
-
-	flag := true
+	hashAddrs := make(map[string][]string)
 	for addr, blockHashes := range responsibleServers {
-		//fmt.Printf("uploadBlocks[%d]: %s\n", idx, GetBlockHashString(block.BlockData))
-
-		blockStoreC := (*blockStoreMap)[addr]
 		for _, blockHash := range blockHashes {
-			blockIdx := getHashIndex(hashes, blockHash)
-			if blockIdx == -1 {
-				log.Fatal("Invalid block index in uploadBlocks()")
-			}
+			hashAddrs[blockHash] = append(hashAddrs[blockHash], addr)
+		}
+	}
+	client.Journal.skipUploaded(hashAddrs, blockStoreMap, ctx)
+	if len(hashAddrs) == 0 {
+		client.Journal.Uploaded(filename, hashes)
+		return nil
+	}
 
-			succ, err := blockStoreC.PutBlock(ctx, &blocks[blockIdx])
-			checkError(err)
-			if !succ.Flag {
-				flag = false
-				break
+	// the streams live as long as the upload, and are torn down if it fails
+	streamCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	streams := make(map[string]BlockStore_PutBlocksClient)
+	sent := make(map[string]bool)
+	err = source(func(blockHash string, data []byte) error {
+		if sent[blockHash] { // same block appears more than once in the file
+			return nil
+		}
+		addrs, wanted := hashAddrs[blockHash]
+		if !wanted {
+			return nil
+		}
+		block, err := encodeBlock(&Block{BlockData: data, BlockSize: int32(len(data))}, blockHash, client)
+		if err != nil {
+			return err
+		}
+		for _, addr := range addrs {
+			stream, open := streams[addr]
+			if !open {
+				if stream, err = (*blockStoreMap)[addr].PutBlocks(streamCtx); err != nil {
+					return err
+				}
+				streams[addr] = stream
+			}
+			if err := stream.Send(block); err != nil {
+				return err
 			}
 		}
-		if !flag {
-			break
+		stats.UncompressedBytes += int64(len(data))
+		stats.StoredBytes += int64(len(block.BlockData))
+		sent[blockHash] = true
+		return nil
+	})
+
+	for addr, stream := range streams {
+		succ, closeErr := stream.CloseAndRecv()
+		if err == nil && closeErr != nil {
+			err = closeErr
+		} else if err == nil && !succ.Flag {
+			err = fmt.Errorf("%s did not store the blocks", addr)
 		}
 	}
+	if err != nil {
+		return err
+	}
+	if len(sent) != len(hashAddrs) {
+		return ERR_FILE_CHANGED
+	}
+	client.Journal.Uploaded(filename, hashes)
+
+	return nil
+}
 
-	return flag
+// Leave a file that changed while it was uploaded as index.db has it, so the
+// next sync hashes it again
+func skipChangedFile(filename string, indexFileMetaMap map[string]*FileMetaData, localFileMetaMap map[string]*FileMetaData) {
+	log.Printf("%s changed while it was uploaded, it is synced next time\n", filename)
+	if indexMetadata := indexFileMetaMap[filename]; indexMetadata != nil {
+		localFileMetaMap[filename] = indexMetadata
+	} else {
+		delete(localFileMetaMap, filename)
+	}
 }
 
 func getHashIndex(hashes []string, target string) int {
@@ -369,107 +736,123 @@ func returnServerAddrForHash(responsibleServers map[string][]string, targetHash
 	return addr
 }
 
-func bringLocalFileUpToDateWithRemote(path string, blockSize int, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, remoteMetaData FileMetaData, localHashes []string) {
-	var reconstitutedFile []byte = make([]byte, 0)
-	responsibleServers := make(map[string][]string)
-	err := client.GetBlockStoreMap(remoteMetaData.BlockHashList, &responsibleServers)
-	checkError(err)
-
-	for idx, remoteHash := range remoteMetaData.BlockHashList {
-		var chunk []byte
-		succ := true
-		if !hashInHashList(localHashes, remoteHash) {
-			blockStoreAddr := returnServerAddrForHash(responsibleServers, remoteHash)
-			if blockStoreAddr == "" {
-				log.Fatal("Invalid addr in bringLocalFileUpToDateWithRemote\n")
-			}
-
-			blockStoreC := (*blockStoreMap)[blockStoreAddr]
-			block, err := blockStoreC.GetBlock(ctx, &BlockHash{Hash: remoteHash})
-			checkError(err)
-			chunk = block.BlockData
-		} else {
-			//			fmt.Printf("hashInHashList(localHashes (%d), remoteHash): %t\n", len(localHashes), hashInHashList(localHashes, remoteHash))
-			chunk, succ = readChunk(path, blockSize, idx)
-		}
-		if !succ {
-			log.Fatal("Failed to read chunk\n")
-		}
-		reconstitutedFile = append(reconstitutedFile, chunk...)
+// Bring the local file at path to the server's version in remoteMetaData,
+// reusing the blocks of the local file, which has localHashes. Returns false,
+// leaving the file alone, if it was changed while the version was downloaded.
+func bringLocalFileUpToDateWithRemote(path string, chunker Chunker, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, remoteMetaData *FileMetaData, localHashes []string) bool {
+	if isDirectoryKey(remoteMetaData.Filename) {
+		checkError(os.MkdirAll(path, 0755))
+		return true
 	}
+	checkError(os.MkdirAll(filepath.Dir(path), 0755))
+	before := statFile(path)
 
 	// Write file back to local
-	err = os.WriteFile(path, reconstitutedFile, 0644)
+	download := func(localHashes []string) (bool, error) {
+		return materializeFile(path, func(w io.Writer) error {
+			return writeRemoteFile(w, path, chunker, blockStoreMap, client, ctx, remoteMetaData.BlockHashList, localHashes)
+		}, remoteMetaData, before, client)
+	}
+	written, err := download(localHashes)
+	if err == ERR_DOWNLOAD_CORRUPT && len(localHashes) > 0 { // a reused local block may have changed since it was hashed
+		written, err = download([]string{})
+	}
 	checkError(err)
+	return written
 }
 
-// return true for match, false for differ
-func getLocalHashesAndBlocks(path string, blockSize int, localHashes *[]string, localBlocks *[]Block) {
-	file, err := os.Open(path)
+// The contents of a file with the given hashes, reusing the blocks of the local
+// file at path, which has localHashes when split with chunker. Returns false
+// if the file is over limit bytes.
+func reconstituteFile(path string, chunker Chunker, blockStoreMap *map[string]BlockStoreClient, client RPCClient, ctx context.Context, hashList []string, localHashes []string, limit int) ([]byte, bool) {
+	buf := cappedBuffer{limit: limit}
+	err := writeRemoteFile(&buf, path, chunker, blockStoreMap, client, ctx, hashList, localHashes)
+	if err == ERR_TOO_LARGE {
+		return nil, false
+	}
 	checkError(err)
-	defer file.Close()
+	return buf.Bytes(), true
+}
 
-	fileInfo, _ := file.Stat()
-	var fileSize int64 = fileInfo.Size()
-	numChunks := uint64(math.Ceil(float64(fileSize) / float64(blockSize)))
+// Look the block up in the client-local cache, checking it was not corrupted on disk
+func getCachedBlock(hash string, client RPCClient) ([]byte, bool) {
+	if client.BlockCache == nil {
+		return nil, false
+	}
+	block, found, err := client.BlockCache.Get(hash)
+	if err != nil || !found || getLocalBlockHash(block.BlockData, client) != hash {
+		return nil, false
+	}
+	return block.BlockData, true
+}
 
-	buf := make([]byte, blockSize)
+// Keep a downloaded block in the client-local cache
+func cacheBlock(hash string, data []byte, client RPCClient) {
+	if client.BlockCache == nil {
+		return
+	}
+	if err := client.BlockCache.Put(hash, &Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+		log.Printf("Could not cache block %s: %v\n", hash, err)
+	}
+}
 
-	for i := uint64(0); i < numChunks; i++ {
-		chunkSize := blockSize
-		if i == numChunks-1 { // last chunk may not be full blocksize
-			chunkSize = int(fileSize) % blockSize
+// Ask every server other than skipAddr for the block, returning an empty block
+// if none has it
+func findBlockOnOtherServers(hash string, skipAddr string, blockStoreMap *map[string]BlockStoreClient, ctx context.Context) *Block {
+	addrs := make([]string, 0, len(*blockStoreMap))
+	for addr := range *blockStoreMap {
+		if addr != skipAddr {
+			addrs = append(addrs, addr)
 		}
-
-		if len(buf) != chunkSize {
-			buf = make([]byte, chunkSize)
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		callCtx, cancel := context.WithTimeout(ctx, time.Second)
+		block, err := (*blockStoreMap)[addr].GetBlock(callCtx, &BlockHash{Hash: hash})
+		cancel()
+		if err == nil && len(block.BlockData) > 0 {
+			return block
 		}
-
-		bytesRead, err := file.Read(buf)
-		checkError(err)
-
-		chunk := buf[:bytesRead]
-		hash := GetBlockHashString(chunk)
-		//	fmt.Printf("getLocalHash hash: %s\n", hash)
-		*localHashes = append(*localHashes, hash)
-
-		chunkData := make([]byte, chunkSize)
-		copy(chunkData, chunk)
-
-		var block = Block{BlockData: chunkData, BlockSize: int32(chunkSize)}
-
-		*localBlocks = append(*localBlocks, block)
-		//		fmt.Printf("getLocalHash block len: %d\n", len(block.BlockData))
 	}
+	return &Block{BlockData: []byte("")}
 }
 
-func readChunk(path string, blockSize int, chunk int) ([]byte, bool) {
-	file, err := os.Open(path)
-	checkError(err)
-	defer file.Close()
-
-	fileInfo, _ := file.Stat()
-	var fileSize int64 = fileInfo.Size()
-	numChunks := uint64(math.Ceil(float64(fileSize) / float64(blockSize)))
-	buf := make([]byte, blockSize)
-
-	for i := 0; i < int(numChunks); i++ {
-		chunkSize := blockSize
-		if uint64(i) == numChunks-1 { // last chunk may not be full blocksize
-			chunkSize = int(fileSize) % blockSize
+// The chunker a file is split with: the one recorded in the first of the
+// metadata that knows the file, so every client splits it the same way, or
+// the client's preferred one for a new file
+func getFileChunker(client RPCClient, metadatas ...*FileMetaData) Chunker {
+	spec := client.Chunker
+	for _, metadata := range metadatas {
+		if metadata != nil && !wasDeleted(metadata) {
+			spec = metadata.Chunker
+			break
 		}
+	}
+	chunker, err := ParseChunker(spec, client.BlockSize)
+	checkError(err)
+	return chunker
+}
 
-		if len(buf) != chunkSize {
-			buf = make([]byte, chunkSize)
-		}
+// Hash each chunk of the file, reading it a chunk at a time
+func getLocalHashes(path string, client RPCClient, chunker Chunker) []string {
+	hashes := make([]string, 0)
+	err := forEachLocalChunk(path, chunker, func(chunk []byte) error {
+		hashes = append(hashes, getLocalBlockHash(chunk, client))
+		return nil
+	})
+	checkError(err)
+	return hashes
+}
 
-		bytesRead, err := file.Read(buf)
-		checkError(err)
-		if i == chunk {
-			return buf[:bytesRead], true
-		}
-	}
-	return []byte(""), false
+// Hash each chunk of the file, and note its size
+func getLocalHashesAndSizes(path string, client RPCClient, chunker Chunker, localHashes *[]string, localSizes *[]int) {
+	err := forEachLocalChunk(path, chunker, func(chunk []byte) error {
+		*localHashes = append(*localHashes, getLocalBlockHash(chunk, client))
+		*localSizes = append(*localSizes, len(chunk))
+		return nil
+	})
+	checkError(err)
 }
 
 func hashInHashList(hashList []string, targetHash string) bool {
@@ -485,6 +868,6 @@ func hashInHashList(hashList []string, targetHash string) bool {
 
 func checkError(err error) {
 	if err != nil {
-		log.Fatal("Error: %s\n", err.Error())
+		log.Fatalf("Error: %s\n", err.Error())
 	}
 }
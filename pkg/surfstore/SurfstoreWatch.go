@@ -0,0 +1,205 @@
+package surfstore
+
+import (
+	"io/fs"
+	"log"
+	"path/filepath"
+	"time"
+)
+
+// Reports that something under the base directory changed. Changes is closed
+// if the watcher stops on its own.
+type changeWatcher interface {
+	Changes() <-chan struct{}
+	Close() error
+}
+
+// ClientWatch syncs the base directory, then keeps it in sync until stop is
+// closed: local changes are picked up with inotify, or by polling every
+// pollInterval when it is positive or inotify is unavailable, and remote ones
+// by waiting on the leader. Bursts of changes are debounced into one sync. A
+// sync in progress when stop is closed is finished, and pending changes get a
+// last sync, so index.db is left up to date.
+func ClientWatch(client RPCClient, pollInterval time.Duration, stop <-chan struct{}) {
+	var watcher changeWatcher
+	var err error
+	if pollInterval > 0 {
+		watcher = newPollingWatcher(client.BaseDir, pollInterval)
+	} else if watcher, err = newInotifyWatcher(client.BaseDir); err != nil {
+		log.Printf("Watching %s by polling, inotify is unavailable: %v\n", client.BaseDir, err)
+		watcher = newPollingWatcher(client.BaseDir, DEFAULT_WATCH_POLL_INTERVAL)
+	}
+	defer func() { watcher.Close() }()
+
+	remoteChanges := make(chan struct{}, 1)
+	go watchRemote(client, remoteChanges, stop)
+
+	ClientSync(client)
+
+	debouncer := newDebouncer(DEFAULT_WATCH_DEBOUNCE, DEFAULT_WATCH_MAX_DELAY)
+	for {
+		select {
+		case _, ok := <-watcher.Changes():
+			if !ok {
+				log.Printf("Watching %s by polling, inotify stopped\n", client.BaseDir)
+				watcher = newPollingWatcher(client.BaseDir, DEFAULT_WATCH_POLL_INTERVAL)
+			}
+			debouncer.add(time.Now())
+		case <-remoteChanges:
+			debouncer.add(time.Now())
+		case <-debouncer.timer.C:
+			debouncer.pending = false
+			log.Printf("Syncing %s\n", client.BaseDir)
+			ClientSync(client)
+		case <-stop:
+			if debouncer.pending {
+				ClientSync(client)
+			}
+			return
+		}
+	}
+}
+
+// Signal changes whenever the leader's change cursor moves. The first cursor
+// counts as a change, covering anything committed before the watch started.
+func watchRemote(client RPCClient, changes chan<- struct{}, stop <-chan struct{}) {
+	cursor := int64(-1)
+	for {
+		var latestCursor int64
+		if err := client.WaitForChanges(cursor, &latestCursor); err != nil {
+			log.Printf("Waiting for remote changes failed: %v\n", err)
+			select {
+			case <-time.After(DEFAULT_WATCH_POLL_INTERVAL):
+			case <-stop:
+				return
+			}
+			continue
+		}
+		if latestCursor != cursor {
+			signalChange(changes)
+		}
+		cursor = latestCursor
+
+		select {
+		case <-stop:
+			return
+		default:
+		}
+	}
+}
+
+// Send on a channel with room for one signal, without blocking
+func signalChange(changes chan<- struct{}) {
+	select {
+	case changes <- struct{}{}:
+	default:
+	}
+}
+
+// Fires quiet after the last change, or maxDelay after the first pending one
+type debouncer struct {
+	quiet    time.Duration
+	maxDelay time.Duration
+	timer    *time.Timer
+	pending  bool
+	first    time.Time
+}
+
+func newDebouncer(quiet time.Duration, maxDelay time.Duration) *debouncer {
+	timer := time.NewTimer(quiet)
+	timer.Stop()
+	return &debouncer{quiet: quiet, maxDelay: maxDelay, timer: timer}
+}
+
+func (d *debouncer) add(now time.Time) {
+	if !d.pending {
+		d.pending = true
+		d.first = now
+	}
+	delay := d.quiet
+	if untilMax := d.first.Add(d.maxDelay).Sub(now); untilMax < delay {
+		delay = untilMax
+	}
+
+	if !d.timer.Stop() {
+		select {
+		case <-d.timer.C:
+		default:
+		}
+	}
+	d.timer.Reset(delay)
+}
+
+type fileSnapshot struct {
+	size    int64
+	modTime time.Time
+	isDir   bool
+}
+
+// Compares snapshots of the base directory every interval
+type pollingWatcher struct {
+	baseDir  string
+	changes  chan struct{}
+	stop     chan struct{}
+	previous map[string]fileSnapshot
+}
+
+func newPollingWatcher(baseDir string, interval time.Duration) changeWatcher {
+	w := &pollingWatcher{
+		baseDir:  baseDir,
+		changes:  make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+		previous: snapshotDirectory(baseDir),
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				current := snapshotDirectory(w.baseDir)
+				if !sameSnapshot(w.previous, current) {
+					signalChange(w.changes)
+				}
+				w.previous = current
+			case <-w.stop:
+				return
+			}
+		}
+	}()
+	return w
+}
+
+func (w *pollingWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+func (w *pollingWatcher) Close() error {
+	close(w.stop)
+	return nil
+}
+
+// Size, modification time and kind of everything under baseDir but the client's own state
+func snapshotDirectory(baseDir string) map[string]fileSnapshot {
+	snapshot := make(map[string]fileSnapshot)
+	filepath.Walk(baseDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || isSyncStateFile(filepath.Base(path)) {
+			return nil
+		}
+		snapshot[path] = fileSnapshot{size: info.Size(), modTime: info.ModTime(), isDir: info.IsDir()}
+		return nil
+	})
+	return snapshot
+}
+
+func sameSnapshot(a map[string]fileSnapshot, b map[string]fileSnapshot) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, entry := range a {
+		if other, ok := b[path]; !ok || other.size != entry.size || !other.modTime.Equal(entry.modTime) || other.isDir != entry.isDir {
+			return false
+		}
+	}
+	return true
+}
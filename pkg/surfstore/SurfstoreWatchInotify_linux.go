@@ -0,0 +1,116 @@
+package surfstore
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"unsafe"
+)
+
+const inotifyMask uint32 = syscall.IN_CREATE | syscall.IN_DELETE | syscall.IN_MODIFY | syscall.IN_CLOSE_WRITE |
+	syscall.IN_MOVED_FROM | syscall.IN_MOVED_TO | syscall.IN_DELETE_SELF | syscall.IN_MOVE_SELF
+
+// Watches the base directory and every directory under it with inotify,
+// adding directories as they are created
+type inotifyWatcher struct {
+	fd      int
+	file    *os.File
+	dirs    map[int32]string // watch descriptor -> directory
+	changes chan struct{}
+}
+
+func newInotifyWatcher(baseDir string) (changeWatcher, error) {
+	fd, err := syscall.InotifyInit1(syscall.IN_CLOEXEC | syscall.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+	w := &inotifyWatcher{
+		fd:      fd,
+		file:    os.NewFile(uintptr(fd), "inotify"),
+		dirs:    make(map[int32]string),
+		changes: make(chan struct{}, 1),
+	}
+	if err := w.addTree(baseDir); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Changes() <-chan struct{} {
+	return w.changes
+}
+
+// Unblocks readEvents, which closes changes
+func (w *inotifyWatcher) Close() error {
+	return w.file.Close()
+}
+
+// Watch dir and the directories under it
+func (w *inotifyWatcher) addTree(dir string) error {
+	return filepath.Walk(dir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) { // removed since it was listed
+				return nil
+			}
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		wd, err := syscall.InotifyAddWatch(w.fd, path, inotifyMask)
+		if err != nil {
+			return err
+		}
+		w.dirs[int32(wd)] = path
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) readEvents() {
+	defer close(w.changes)
+	buf := make([]byte, 64*(syscall.SizeofInotifyEvent+syscall.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		changed := false
+		for offset := 0; offset+syscall.SizeofInotifyEvent <= n; {
+			event := (*syscall.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + syscall.SizeofInotifyEvent
+			name := strings.TrimRight(string(buf[nameStart:nameStart+int(event.Len)]), "\x00")
+			offset = nameStart + int(event.Len)
+
+			if event.Mask&syscall.IN_Q_OVERFLOW != 0 {
+				changed = true
+				continue
+			}
+			if event.Mask&syscall.IN_IGNORED != 0 {
+				delete(w.dirs, event.Wd)
+				continue
+			}
+			if isSyncStateFile(name) {
+				continue
+			}
+			changed = true
+
+			// the new directory may have filled up before its watch was added,
+			// the sync this triggers walks it anyway
+			if event.Mask&syscall.IN_ISDIR != 0 && event.Mask&(syscall.IN_CREATE|syscall.IN_MOVED_TO) != 0 {
+				if err := w.addTree(filepath.Join(w.dirs[event.Wd], name)); err != nil {
+					log.Printf("Could not watch %s: %v\n", name, err)
+					w.file.Close()
+				}
+			}
+		}
+		if changed {
+			signalChange(w.changes)
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+// +build !linux
+
+package surfstore
+
+import "fmt"
+
+func newInotifyWatcher(baseDir string) (changeWatcher, error) {
+	return nil, fmt.Errorf("inotify is only available on linux")
+}
@@ -0,0 +1,134 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCachedBlockStorageAdmitsFrequentBlocksAndEvictsLRU(t *testing.T) {
+	backend := surfstore.NewMemoryBlockStorage()
+	cache := surfstore.NewCachedBlockStorage(backend, 20)
+
+	blocks := map[string]*surfstore.Block{}
+	for _, data := range []string{"block one.", "block two.", "block 333."} {
+		hash := surfstore.GetBlockHashString([]byte(data))
+		blocks[data] = &surfstore.Block{BlockData: []byte(data), BlockSize: int32(len(data))}
+		backend.Put(hash, blocks[data])
+	}
+	one := surfstore.GetBlockHashString([]byte("block one."))
+	two := surfstore.GetBlockHashString([]byte("block two."))
+	three := surfstore.GetBlockHashString([]byte("block 333."))
+
+	// the first access only counts towards admission
+	cache.Get(one)
+	cache.Get(one)
+	if stats := cache.Stats(); stats.Hits != 0 || stats.Misses != 2 || stats.Bytes != 10 {
+		t.Fatalf("Expected the block to be admitted on its second access, got %+v", cache.Stats())
+	}
+	block, found, _ := cache.Get(one)
+	if !found || !bytes.Equal(block.BlockData, []byte("block one.")) || cache.Stats().Hits != 1 {
+		t.Fatalf("Expected a cache hit, got %+v", cache.Stats())
+	}
+
+	cache.Get(two)
+	cache.Get(two)
+	cache.Get(one)
+	cache.Get(three)
+	cache.Get(three) // evicts two, the least recently used
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Bytes != 20 {
+		t.Fatalf("Expected one eviction, got %+v", stats)
+	}
+	hits := cache.Stats().Hits
+	cache.Get(one)
+	cache.Get(two)
+	if cache.Stats().Hits != hits+1 {
+		t.Fatalf("Expected one to stay cached and two to be evicted, got %+v", cache.Stats())
+	}
+
+	cache.Delete(one)
+	if _, found, _ := backend.Get(one); found {
+		t.Fatalf("Delete should reach the backend")
+	}
+	if _, found, _ := cache.Get(one); found {
+		t.Fatalf("Deleted block is still cached")
+	}
+}
+
+func TestBlockStoreKeepsBlocksOnDisk(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	diskStorage, err := surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open block directory: %s", err.Error())
+	}
+	blockStore := surfstore.NewBlockStoreWithStorage(surfstore.NewCachedBlockStorage(diskStorage, 1024))
+
+	data := []byte("a block that outlives its server")
+	hash := surfstore.GetBlockHashString(data)
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+	blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+
+	stats, _ := blockStore.GetStats(ctx, &emptypb.Empty{})
+	if stats.CacheHits != 1 || stats.CacheMisses != 1 {
+		t.Fatalf("Expected the block to be cached after its second access, got %v", stats)
+	}
+	bytesUsed := stats.BytesUsed
+	if bytesUsed < int64(len(data)) {
+		t.Fatalf("Expected the block file to take up at least the block data, got %v", stats)
+	}
+
+	// a new server on the same directory serves the block and accounts for it
+	diskStorage, _ = surfstore.NewDiskBlockStorage(dir)
+	restarted := surfstore.NewBlockStoreWithStorage(diskStorage)
+	block, _ := restarted.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if !bytes.Equal(block.BlockData, data) {
+		t.Fatalf("Block was not kept on disk")
+	}
+	stats, _ = restarted.GetStats(ctx, &emptypb.Empty{})
+	if stats.BlockCount != 1 || stats.BytesUsed != bytesUsed {
+		t.Fatalf("Unexpected stats after restart %v", stats)
+	}
+	if remaining := restarted.Scrub(); len(remaining) != 0 {
+		t.Fatalf("Blocks on disk should scrub clean, quarantined %v", remaining)
+	}
+}
+
+func TestDiskBlockStorageRemovesTempFilesLeftByCrash(t *testing.T) {
+	dir := t.TempDir()
+	diskStorage, err := surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open block directory: %s", err.Error())
+	}
+	data := []byte("a block written before the crash")
+	hash := surfstore.GetBlockHashString(data)
+	diskStorage.Put(hash, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+
+	// a Put interrupted before its rename
+	leftover := filepath.Join(dir, hash[:2], surfstore.BLOCK_TEMP_PREFIX+hash+"123456")
+	if err := os.WriteFile(leftover, data[:10], 0644); err != nil {
+		t.Fatalf("Could not write the temporary file: %s", err.Error())
+	}
+
+	diskStorage, err = surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not reopen block directory: %s", err.Error())
+	}
+	if _, err := os.Stat(leftover); !os.IsNotExist(err) {
+		t.Fatalf("Expected the temporary file to be removed when the storage is opened")
+	}
+	hashes, _ := diskStorage.Hashes()
+	if !SameHashList(hashes, []string{hash}) {
+		t.Fatalf("Expected only the stored block, got %v", hashes)
+	}
+	if block, found, _ := diskStorage.Get(hash); !found || !bytes.Equal(block.BlockData, data) {
+		t.Fatalf("The stored block should be kept")
+	}
+}
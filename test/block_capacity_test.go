@@ -0,0 +1,59 @@
+package SurfTest
+
+import (
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"sort"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestBlockStoreReportsStatsAndRejectsBlocksOverCapacity(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+	blockStore.Capacity = 30
+
+	first := []byte("twenty bytes of data")
+	if _, err := blockStore.PutBlock(ctx, &surfstore.Block{BlockData: first, BlockSize: int32(len(first))}); err != nil {
+		t.Fatalf("Could not put block: %s", err.Error())
+	}
+	// putting the same block again takes no extra space
+	if _, err := blockStore.PutBlock(ctx, &surfstore.Block{BlockData: first, BlockSize: int32(len(first))}); err != nil {
+		t.Fatalf("Could not put block again: %s", err.Error())
+	}
+
+	stats, _ := blockStore.GetStats(ctx, &emptypb.Empty{})
+	if stats.BlockCount != 1 || stats.BytesUsed != 20 || stats.CapacityBytes != 30 || stats.FreeBytes != 10 {
+		t.Fatalf("Unexpected stats %v", stats)
+	}
+
+	second := []byte("another twenty bytes")
+	if _, err := blockStore.PutBlock(ctx, &surfstore.Block{BlockData: second, BlockSize: int32(len(second))}); err != surfstore.ERR_BLOCKSTORE_FULL {
+		t.Fatalf("Expected the BlockStore to be full, got %v", err)
+	}
+
+	blockStore.DeleteBlocks(ctx, &surfstore.BlockDeletion{Hashes: []string{surfstore.GetBlockHashString(first)}})
+	stats, _ = blockStore.GetStats(ctx, &emptypb.Empty{})
+	if stats.BlockCount != 0 || stats.BytesUsed != 0 || stats.FreeBytes != 30 {
+		t.Fatalf("Deleting should free the space, got %v", stats)
+	}
+}
+
+func TestResponsibleServersFollowTheRing(t *testing.T) {
+	addrs := []string{"localhost:8080", "localhost:8081", "localhost:8082"}
+	ring := surfstore.NewConsistentHashRing(addrs)
+
+	for _, data := range []string{"a", "b", "c", "d"} {
+		hash := surfstore.GetBlockHashString([]byte(data))
+		servers := ring.GetResponsibleServers(hash)
+		sorted := append([]string{}, servers...)
+		sort.Strings(sorted)
+		if !SameHashList(sorted, addrs) {
+			t.Fatalf("Expected every server once, got %v", servers)
+		}
+		if servers[0] != ring.GetResponsibleServer(hash) {
+			t.Fatalf("Expected %s first, got %v", ring.GetResponsibleServer(hash), servers)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"strings"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestCodecsRoundTrip(t *testing.T) {
+	data := []byte(strings.Repeat("text-heavy repos compress well. ", 128))
+	for _, codec := range surfstore.SUPPORTED_CODECS {
+		compressed, err := surfstore.CompressBlockData(data, codec)
+		if err != nil {
+			t.Fatalf("%s: could not compress: %s", codec, err.Error())
+		}
+		if codec != surfstore.Codec_NONE && len(compressed) >= len(data) {
+			t.Fatalf("%s: expected %d bytes to shrink, got %d", codec, len(data), len(compressed))
+		}
+
+		decompressed, err := surfstore.DecompressBlockData(compressed, codec)
+		if err != nil {
+			t.Fatalf("%s: could not decompress: %s", codec, err.Error())
+		}
+		if !bytes.Equal(data, decompressed) {
+			t.Fatalf("%s: round trip changed the data", codec)
+		}
+	}
+}
+
+func TestBlockStoreKeysCompressedBlocksByContent(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+
+	data := []byte(strings.Repeat("0123456789", 100))
+	hash := surfstore.GetBlockHashString(data)
+	block, err := surfstore.CompressBlock(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}, surfstore.Codec_ZSTD)
+	if err != nil {
+		t.Fatalf("Could not compress block: %s", err.Error())
+	}
+	blockStore.PutBlock(ctx, block)
+
+	stored, _ := blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if stored.Codec != surfstore.Codec_ZSTD || len(stored.BlockData) >= len(data) {
+		t.Fatalf("Expected the block to be stored compressed")
+	}
+
+	stats, _ := blockStore.GetCompressionStats(ctx, &emptypb.Empty{})
+	if stats.UncompressedBytes != int64(len(data)) || stats.StoredBytes != int64(len(block.BlockData)) {
+		t.Fatalf("Unexpected compression stats %d/%d", stats.UncompressedBytes, stats.StoredBytes)
+	}
+
+	if remaining := blockStore.Scrub(); len(remaining) != 0 {
+		t.Fatalf("Scrub should accept compressed blocks, quarantined %v", remaining)
+	}
+}
@@ -0,0 +1,140 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBlockCipherIsConvergent(t *testing.T) {
+	cipher, err := surfstore.NewBlockCipher([]byte("a key shared by all my clients"))
+	if err != nil {
+		t.Fatalf("Could not create cipher: %s", err.Error())
+	}
+
+	data := []byte("plaintext the block servers must never see")
+	hash := cipher.HashBlock(data)
+	if hash == surfstore.GetBlockHashString(data) {
+		t.Fatalf("Block hash should be keyed")
+	}
+
+	sealed := cipher.Seal(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}, hash)
+	if bytes.Contains(sealed.BlockData, data) {
+		t.Fatalf("Sealed block contains the plaintext")
+	}
+	again := cipher.Seal(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}, hash)
+	if !bytes.Equal(sealed.BlockData, again.BlockData) {
+		t.Fatalf("Equal blocks should encrypt to equal ciphertexts")
+	}
+
+	opened, err := cipher.Open(sealed, hash)
+	if err != nil || !bytes.Equal(opened, data) {
+		t.Fatalf("Could not open sealed block: %v", err)
+	}
+
+	otherCipher, _ := surfstore.NewBlockCipher([]byte("somebody else's key"))
+	if _, err := otherCipher.Open(sealed, hash); err == nil {
+		t.Fatalf("Opening with the wrong key should fail")
+	}
+}
+
+func TestBlockStoreScrubsEncryptedBlocks(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+	cipher, _ := surfstore.NewBlockCipher([]byte("key"))
+
+	data := []byte("an encrypted block")
+	hash := cipher.HashBlock(data)
+	blockStore.PutBlock(ctx, cipher.Seal(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}, hash))
+
+	if remaining := blockStore.Scrub(); len(remaining) != 0 {
+		t.Fatalf("Intact encrypted block was quarantined")
+	}
+
+	blockStore.Storage.(*surfstore.MemoryBlockStorage).BlockMap[hash].BlockData[0] ^= 0xff
+	if remaining := blockStore.Scrub(); !SameHashList(remaining, []string{hash}) {
+		t.Fatalf("Corrupt encrypted block was not quarantined, got %v", remaining)
+	}
+}
+
+func TestBlockStoreKeepsEncryptedBlockPutWithAnotherCodec(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+	cipher, _ := surfstore.NewBlockCipher([]byte("key"))
+
+	data := bytes.Repeat([]byte("a block two clients compress differently "), 100)
+	hash := cipher.HashBlock(data)
+	for _, codec := range []surfstore.Codec{surfstore.Codec_GZIP, surfstore.Codec_NONE, surfstore.Codec_GZIP} {
+		compressed, err := surfstore.CompressBlock(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}, codec)
+		if err != nil {
+			t.Fatalf("Could not compress block: %s", err.Error())
+		}
+		success, err := blockStore.PutBlock(ctx, cipher.Seal(compressed, hash))
+		if err != nil || !success.Flag {
+			t.Fatalf("Putting the block again with %v should succeed, got %v", codec, err)
+		}
+	}
+
+	block, _ := blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if block.Codec != surfstore.Codec_GZIP {
+		t.Fatalf("The first block stored should be kept, got %v", block.Codec)
+	}
+	opened, err := cipher.Open(block, hash)
+	if err != nil {
+		t.Fatalf("Could not open the stored block: %s", err.Error())
+	}
+	if decompressed, err := surfstore.DecompressBlockData(opened, block.Codec); err != nil || !bytes.Equal(decompressed, data) {
+		t.Fatalf("The stored block does not hold the data")
+	}
+	if remaining := blockStore.Scrub(); len(remaining) != 0 {
+		t.Fatalf("Intact encrypted block was quarantined")
+	}
+}
+
+func TestReopenedBlockStoreScrubsEncryptedBlocks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	cipher, _ := surfstore.NewBlockCipher([]byte("key"))
+
+	diskStorage, err := surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open block directory: %s", err.Error())
+	}
+	blockStore := surfstore.NewBlockStoreWithStorage(diskStorage)
+	intact := []byte("an encrypted block that stays intact")
+	rotting := []byte("an encrypted block that suffers bit rot")
+	intactHash := cipher.HashBlock(intact)
+	rottingHash := cipher.HashBlock(rotting)
+	rottingBlock := cipher.Seal(&surfstore.Block{BlockData: rotting, BlockSize: int32(len(rotting))}, rottingHash)
+	blockStore.PutBlock(ctx, cipher.Seal(&surfstore.Block{BlockData: intact, BlockSize: int32(len(intact))}, intactHash))
+	blockStore.PutBlock(ctx, rottingBlock)
+
+	// a block stored without a checksum cannot be checked and is kept
+	legacy := []byte("an encrypted block stored without a checksum")
+	legacyHash := cipher.HashBlock(legacy)
+	diskStorage.Put(legacyHash, cipher.Seal(&surfstore.Block{BlockData: legacy, BlockSize: int32(len(legacy))}, legacyHash))
+
+	diskStorage, _ = surfstore.NewDiskBlockStorage(dir)
+	if remaining := surfstore.NewBlockStoreWithStorage(diskStorage).Scrub(); len(remaining) != 0 {
+		t.Fatalf("Intact encrypted blocks were quarantined after a restart: %v", remaining)
+	}
+
+	path := filepath.Join(dir, rottingHash[:2], rottingHash)
+	contents, _ := os.ReadFile(path)
+	contents[bytes.Index(contents, rottingBlock.BlockData)] ^= 0xff
+	if err := os.WriteFile(path, contents, 0644); err != nil {
+		t.Fatalf("Could not corrupt the block: %s", err.Error())
+	}
+	diskStorage, _ = surfstore.NewDiskBlockStorage(dir)
+	restarted := surfstore.NewBlockStoreWithStorage(diskStorage)
+	if remaining := restarted.Scrub(); !SameHashList(remaining, []string{rottingHash}) {
+		t.Fatalf("Expected only the corrupt block to be quarantined, got %v", remaining)
+	}
+	stored, _ := restarted.HasBlocks(ctx, &surfstore.BlockHashes{Hashes: []string{intactHash, rottingHash, legacyHash}})
+	if !SameHashList(stored.Hashes, []string{intactHash, legacyHash}) {
+		t.Fatalf("Expected the intact and unchecked blocks to be kept, got %v", stored.Hashes)
+	}
+}
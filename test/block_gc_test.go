@@ -0,0 +1,110 @@
+package SurfTest
+
+import (
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestLiveBlockHashesIncludeHistory(t *testing.T) {
+	metaStore := surfstore.NewMetaStore([]string{}, surfstore.NewConsistentHashRing([]string{}))
+	metaStore.FileMetaMap["current.txt"] = NewFileMetaDataFromParams("current.txt", 2, []string{"b", "c"})
+	metaStore.FileMetaMap["deleted.txt"] = NewFileMetaDataFromParams("deleted.txt", 3, []string{TOMBSTONE_HASH})
+
+	history := []*surfstore.FileMetaData{NewFileMetaDataFromParams("current.txt", 1, []string{"a", "b"})}
+	liveHashes := metaStore.GetLiveBlockHashes(history)
+
+	if len(liveHashes) != 3 || !liveHashes["a"] || !liveHashes["b"] || !liveHashes["c"] {
+		t.Fatalf("Expected a, b and c to be live, got %v", liveHashes)
+	}
+}
+
+func TestDeleteBlocksRespectsGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+
+	data := []byte("an orphaned block")
+	hash := surfstore.GetBlockHashString(data)
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+
+	deleted, _ := blockStore.DeleteBlocks(ctx, &surfstore.BlockDeletion{Hashes: []string{hash}, GracePeriodSeconds: int64(time.Hour.Seconds())})
+	if len(deleted.Hashes) != 0 {
+		t.Fatalf("A freshly put block should survive the grace period")
+	}
+
+	deleted, _ = blockStore.DeleteBlocks(ctx, &surfstore.BlockDeletion{Hashes: []string{hash, "missing"}, GracePeriodSeconds: 0})
+	if !SameHashList(deleted.Hashes, []string{hash}) {
+		t.Fatalf("Expected only the stored block to be deleted, got %v", deleted.Hashes)
+	}
+
+	stored, _ := blockStore.HasBlocks(ctx, &surfstore.BlockHashes{Hashes: []string{hash}})
+	if len(stored.Hashes) != 0 {
+		t.Fatalf("Deleted block is still stored")
+	}
+}
+
+func TestReopenedBlockStoreKeepsGracePeriod(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+
+	diskStorage, err := surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open block directory: %s", err.Error())
+	}
+	data := []byte("a block put just before a restart")
+	hash := surfstore.GetBlockHashString(data)
+	surfstore.NewBlockStoreWithStorage(diskStorage).PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+
+	// the put time is lost with the server, the block is taken as just put
+	diskStorage, _ = surfstore.NewDiskBlockStorage(dir)
+	restarted := surfstore.NewBlockStoreWithStorage(diskStorage)
+	deleted, _ := restarted.DeleteBlocks(ctx, &surfstore.BlockDeletion{Hashes: []string{hash}, GracePeriodSeconds: int64(time.Hour.Seconds())})
+	if len(deleted.Hashes) != 0 {
+		t.Fatalf("A block put before the restart should survive the grace period")
+	}
+
+	deleted, _ = restarted.DeleteBlocks(ctx, &surfstore.BlockDeletion{Hashes: []string{hash}, GracePeriodSeconds: 0})
+	if !SameHashList(deleted.Hashes, []string{hash}) {
+		t.Fatalf("Expected the block to be deleted once the grace period is over, got %v", deleted.Hashes)
+	}
+}
+
+func TestCollectGarbageFromSlowBlockStore(t *testing.T) {
+	ctx := context.Background()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	// listing and deleting together take longer than a second
+	server := grpc.NewServer(grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		time.Sleep(700 * time.Millisecond)
+		return handler(ctx, req)
+	}))
+	blockStore := surfstore.NewBlockStore()
+	surfstore.RegisterBlockStoreServer(server, blockStore)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	live := []byte("a block of a file")
+	orphan := []byte("a block no file refers to")
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: live, BlockSize: int32(len(live))})
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: orphan, BlockSize: int32(len(orphan))})
+
+	raftServer, _ := surfstore.NewRaftServer(0, surfstore.RaftConfig{RaftAddrs: []string{"localhost:0"}, BlockAddrs: []string{listener.Addr().String()}})
+	raftServer.SetLeader(ctx, &emptypb.Empty{})
+	raftServer.SetGCGracePeriod(0)
+	raftServer.UpdateFile(ctx, NewFileMetaDataFromParams("file.txt", 1, []string{surfstore.GetBlockHashString(live)}))
+
+	deleted, err := raftServer.CollectGarbage(ctx, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not collect garbage: %s", err.Error())
+	}
+	if !SameHashList(deleted.Hashes, []string{surfstore.GetBlockHashString(orphan)}) {
+		t.Fatalf("Expected the orphaned block to be deleted, got %v", deleted.Hashes)
+	}
+}
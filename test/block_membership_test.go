@@ -0,0 +1,119 @@
+package SurfTest
+
+import (
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/protobuf/proto"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMembershipChangeMigratesThenSettles(t *testing.T) {
+	oldAddrs := []string{"localhost:8080", "localhost:8081"}
+	metaStore := surfstore.NewMetaStore(oldAddrs, surfstore.NewConsistentHashRing(oldAddrs))
+
+	// localhost:8081 leaves and localhost:8082 joins
+	servers := []*surfstore.BlockServer{{Addr: "localhost:8080", Weight: 1}, {Addr: "localhost:8082", Weight: 1}}
+	metaStore.ApplyBlockMembership(&surfstore.BlockMembership{Servers: servers, VirtualNodes: 1, Migrating: true})
+
+	if !SameHashList(metaStore.BlockStoreAddrs, oldAddrs) {
+		t.Fatalf("BlockStoreAddrs should not change until the migration settles, got %v", metaStore.BlockStoreAddrs)
+	}
+	if !SameHashList(metaStore.GetAllBlockStoreAddrs(), []string{"localhost:8080", "localhost:8081", "localhost:8082"}) {
+		t.Fatalf("Every old and new BlockStore should be readable while migrating, got %v", metaStore.GetAllBlockStoreAddrs())
+	}
+
+	newRing := surfstore.NewConsistentHashRing([]string{"localhost:8080", "localhost:8082"})
+	for i := 0; i < 100; i++ {
+		hash := surfstore.GetBlockHashString([]byte(strconv.Itoa(i)))
+		if metaStore.GetPlacement().GetResponsibleServer(hash) != newRing.GetResponsibleServer(hash) {
+			t.Fatalf("New blocks should be placed by the new ring while migrating")
+		}
+	}
+
+	metaStore.ApplyBlockMembership(&surfstore.BlockMembership{Servers: servers, VirtualNodes: 1, Migrating: false})
+	if !SameHashList(metaStore.BlockStoreAddrs, []string{"localhost:8080", "localhost:8082"}) || metaStore.NextMembership != nil {
+		t.Fatalf("Settling should cut over to the new BlockStores, got %v", metaStore.BlockStoreAddrs)
+	}
+	if !SameHashList(metaStore.GetAllBlockStoreAddrs(), metaStore.BlockStoreAddrs) {
+		t.Fatalf("The BlockStore that left should no longer be read from, got %v", metaStore.GetAllBlockStoreAddrs())
+	}
+}
+
+// Run with -race: the migration commits its settled membership while files are updated
+func TestMigrationDuringWrites(t *testing.T) {
+	ctx := context.Background()
+	blockStoreAddrs := make([]string, 0)
+	for i := 0; i < 2; i++ {
+		listener, err := net.Listen("tcp", "localhost:0")
+		if err != nil {
+			t.Fatalf("Could not listen: %s", err.Error())
+		}
+		server := grpc.NewServer()
+		surfstore.RegisterBlockStoreServer(server, surfstore.NewBlockStore())
+		go server.Serve(listener)
+		defer server.Stop()
+		blockStoreAddrs = append(blockStoreAddrs, listener.Addr().String())
+	}
+
+	raftServer, _ := surfstore.NewRaftServer(0, surfstore.RaftConfig{RaftAddrs: []string{"localhost:0"}, BlockAddrs: blockStoreAddrs[:1]})
+	raftServer.SetLeader(ctx, &emptypb.Empty{})
+	conn, err := grpc.Dial(blockStoreAddrs[0], grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	defer conn.Close()
+	blockStoreC := surfstore.NewBlockStoreClient(conn)
+	for i := 0; i < 50; i++ {
+		data := []byte("block " + strconv.Itoa(i))
+		blockStoreC.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+	}
+
+	servers := []*surfstore.BlockServer{{Addr: blockStoreAddrs[0], Weight: 1}, {Addr: blockStoreAddrs[1], Weight: 1}}
+	if _, err := raftServer.ChangeBlockMembership(ctx, &surfstore.BlockMembership{Servers: servers, VirtualNodes: 1}); err != nil {
+		t.Fatalf("Could not change the membership: %s", err.Error())
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func(writer int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				filename := "file" + strconv.Itoa(writer) + "_" + strconv.Itoa(j)
+				raftServer.UpdateFile(ctx, NewFileMetaDataFromParams(filename, 1, []string{"hash"}))
+				// marshalled after the handlers return, as gRPC sends them
+				fileInfoMap, _ := raftServer.GetFileInfoMap(ctx, &emptypb.Empty{})
+				proto.Marshal(fileInfoMap)
+				state, _ := raftServer.GetInternalState(ctx, &emptypb.Empty{})
+				proto.Marshal(state)
+				raftServer.GetBlockStoreMap(ctx, &surfstore.BlockHashes{Hashes: []string{"hash"}})
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		membership, _ := raftServer.GetBlockMembership(ctx, &emptypb.Empty{})
+		if !membership.Migrating {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The migration did not settle")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	fileInfoMap, _ := raftServer.GetFileInfoMap(ctx, &emptypb.Empty{})
+	if len(fileInfoMap.FileInfoMap) != 80 {
+		t.Fatalf("Expected every update to be applied, got %d files", len(fileInfoMap.FileInfoMap))
+	}
+	state, _ := raftServer.GetInternalState(ctx, &emptypb.Empty{})
+	if len(state.Log) != 82 {
+		t.Fatalf("Expected the updates and both membership entries in the log, got %d entries", len(state.Log))
+	}
+}
@@ -0,0 +1,154 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestBlockStoreScrubQuarantinesCorruptBlock(t *testing.T) {
+	ctx := context.Background()
+	blockStore := surfstore.NewBlockStore()
+
+	good := []byte("a block that stays intact")
+	bad := []byte("a block that suffers bit rot")
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: good, BlockSize: int32(len(good))})
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: bad, BlockSize: int32(len(bad))})
+
+	goodHash := surfstore.GetBlockHashString(good)
+	badHash := surfstore.GetBlockHashString(bad)
+	blockStore.Storage.(*surfstore.MemoryBlockStorage).BlockMap[badHash].BlockData = []byte("a block that suffers bit r0t")
+
+	remaining := blockStore.Scrub()
+	if !SameHashList(remaining, []string{badHash}) {
+		t.Fatalf("Expected only the corrupt block to remain quarantined, got %v", remaining)
+	}
+
+	quarantined, _ := blockStore.GetQuarantinedBlocks(ctx, &emptypb.Empty{})
+	if !SameHashList(quarantined.Hashes, []string{badHash}) {
+		t.Fatalf("Expected the corrupt block to be reported, got %v", quarantined.Hashes)
+	}
+
+	stored, _ := blockStore.HasBlocks(ctx, &surfstore.BlockHashes{Hashes: []string{goodHash, badHash}})
+	if !SameHashList(stored.Hashes, []string{goodHash}) {
+		t.Fatalf("Corrupt block should no longer be served, got %v", stored.Hashes)
+	}
+}
+
+func TestBlockStoreScrubRepairsFromPeer(t *testing.T) {
+	ctx := context.Background()
+
+	// peer holding a good copy
+	peer := surfstore.NewBlockStore()
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("Could not listen: %s", err.Error())
+	}
+	server := grpc.NewServer()
+	surfstore.RegisterBlockStoreServer(server, peer)
+	go server.Serve(listener)
+	defer server.Stop()
+
+	data := []byte("a block with a replica elsewhere")
+	hash := surfstore.GetBlockHashString(data)
+	peer.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+
+	blockStore := surfstore.NewBlockStore()
+	blockStore.PeerAddrs = []string{listener.Addr().String()}
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: []byte(string(data)), BlockSize: int32(len(data))})
+	blockStore.Storage.(*surfstore.MemoryBlockStorage).BlockMap[hash].BlockData[0] ^= 0xff
+
+	if remaining := blockStore.Scrub(); len(remaining) != 0 {
+		t.Fatalf("Expected the block to be repaired, still quarantined: %v", remaining)
+	}
+
+	block, _ := blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if surfstore.GetBlockHashString(block.BlockData) != hash {
+		t.Fatalf("Repaired block does not match its hash")
+	}
+}
+
+func TestBlockStoreScrubQuarantinesUnreadableBlock(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	diskStorage, err := surfstore.NewDiskBlockStorage(dir)
+	if err != nil {
+		t.Fatalf("Could not open block directory: %s", err.Error())
+	}
+	blockStore := surfstore.NewBlockStoreWithStorage(diskStorage)
+
+	data := []byte("a block whose file gets mangled")
+	hash := surfstore.GetBlockHashString(data)
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+	if err := os.WriteFile(filepath.Join(dir, hash[:2], hash), []byte{0xff, 0xff, 0xff}, 0644); err != nil {
+		t.Fatalf("Could not mangle the block: %s", err.Error())
+	}
+
+	if remaining := blockStore.Scrub(); !SameHashList(remaining, []string{hash}) {
+		t.Fatalf("Expected the unreadable block to remain quarantined, got %v", remaining)
+	}
+	if _, found := blockStore.QuarantineMap[hash]; found {
+		t.Fatalf("No block could be read, so none should be kept in quarantine")
+	}
+	quarantined, _ := blockStore.GetQuarantinedBlocks(ctx, &emptypb.Empty{})
+	if !SameHashList(quarantined.Hashes, []string{hash}) {
+		t.Fatalf("Expected the unreadable block to be reported, got %v", quarantined.Hashes)
+	}
+}
+
+// A storage whose reads of one block wait until the test lets them through
+type gatedStorage struct {
+	surfstore.BlockStorage
+	hash    string
+	reading chan struct{}
+	gate    chan struct{}
+}
+
+func (s gatedStorage) Get(hash string) (*surfstore.Block, bool, error) {
+	if hash == s.hash {
+		s.reading <- struct{}{}
+		<-s.gate
+	}
+	return s.BlockStorage.Get(hash)
+}
+
+func TestBlockStoreServesRequestsDuringScrub(t *testing.T) {
+	ctx := context.Background()
+	slow := []byte("a block the scrubber takes a long time to read")
+	other := []byte("a block requested during the scrub")
+	storage := gatedStorage{surfstore.NewMemoryBlockStorage(), surfstore.GetBlockHashString(slow), make(chan struct{}), make(chan struct{})}
+	storage.Put(storage.hash, &surfstore.Block{BlockData: slow, BlockSize: int32(len(slow))})
+	blockStore := surfstore.NewBlockStoreWithStorage(storage)
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: other, BlockSize: int32(len(other))})
+
+	scrubbed := make(chan []string)
+	go func() { scrubbed <- blockStore.Scrub() }()
+	<-storage.reading
+
+	served := make(chan bool)
+	go func() {
+		block, _ := blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: surfstore.GetBlockHashString(other)})
+		served <- bytes.Equal(block.BlockData, other)
+	}()
+	select {
+	case ok := <-served:
+		if !ok {
+			t.Fatalf("Expected the requested block to be served")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("A request waited for the scrubber to finish reading another block")
+	}
+
+	close(storage.gate)
+	if remaining := <-scrubbed; len(remaining) != 0 {
+		t.Fatalf("Intact blocks were quarantined: %v", remaining)
+	}
+}
@@ -0,0 +1,100 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+const OLD_AT_REST_KEY string = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+const NEW_AT_REST_KEY string = "202122232425262728292a2b2c2d2e2f303132333435363738393a3b3c3d3e3f"
+
+func TestBlockStoreEncryptsAtRestAndRotatesKeys(t *testing.T) {
+	ctx := context.Background()
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, keyFile, `{"CurrentKeyId": "old", "Keys": {"old": "`+OLD_AT_REST_KEY+`"}}`)
+
+	memory := surfstore.NewMemoryBlockStorage()
+	encrypted, err := surfstore.NewEncryptedBlockStorage(memory, keyFile)
+	if err != nil {
+		t.Fatalf("Could not load key file: %s", err.Error())
+	}
+	blockStore := surfstore.NewBlockStoreWithStorage(encrypted)
+
+	data := []byte("a block the disk must never see in the clear")
+	hash := surfstore.GetBlockHashString(data)
+	blockStore.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+
+	if bytes.Contains(memory.BlockMap[hash].BlockData, data) {
+		t.Fatalf("Stored block contains the plaintext")
+	}
+	stored, _ := blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if !bytes.Equal(stored.BlockData, data) {
+		t.Fatalf("Could not read back the encrypted block")
+	}
+
+	writeKeyFile(t, keyFile, `{"CurrentKeyId": "new", "Keys": {"old": "`+OLD_AT_REST_KEY+`", "new": "`+NEW_AT_REST_KEY+`"}}`)
+	rewritten, err := blockStore.ReencryptBlocks(ctx, &emptypb.Empty{})
+	if err != nil || !SameHashList(rewritten.Hashes, []string{hash}) {
+		t.Fatalf("Expected the block to be re-encrypted, got %v, %v", rewritten, err)
+	}
+	rewritten, _ = blockStore.ReencryptBlocks(ctx, &emptypb.Empty{})
+	if len(rewritten.Hashes) != 0 {
+		t.Fatalf("Blocks under the current key should not be rewritten again")
+	}
+
+	// the old key can now be retired
+	writeKeyFile(t, keyFile, `{"CurrentKeyId": "new", "Keys": {"new": "`+NEW_AT_REST_KEY+`"}}`)
+	if _, err := blockStore.ReencryptBlocks(ctx, &emptypb.Empty{}); err != nil {
+		t.Fatalf("Could not reload key file: %s", err.Error())
+	}
+	stored, _ = blockStore.GetBlock(ctx, &surfstore.BlockHash{Hash: hash})
+	if !bytes.Equal(stored.BlockData, data) {
+		t.Fatalf("Could not read the block after retiring the old key")
+	}
+
+	memory.BlockMap[hash].BlockData[len(memory.BlockMap[hash].BlockData)-1] ^= 0xff
+	if remaining := blockStore.Scrub(); !SameHashList(remaining, []string{hash}) {
+		t.Fatalf("Tampered block was not quarantined, got %v", remaining)
+	}
+}
+
+func writeKeyFile(t *testing.T, path string, contents string) {
+	if err := os.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatalf("Could not write key file: %s", err.Error())
+	}
+}
+
+func TestBlockStoreOpensWithoutDecryptingBlocks(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	keyFile := filepath.Join(t.TempDir(), "keys.json")
+	writeKeyFile(t, keyFile, `{"CurrentKeyId": "old", "Keys": {"old": "`+OLD_AT_REST_KEY+`"}}`)
+
+	diskStorage, _ := surfstore.NewDiskBlockStorage(dir)
+	encrypted, err := surfstore.NewEncryptedBlockStorage(diskStorage, keyFile)
+	if err != nil {
+		t.Fatalf("Could not load key file: %s", err.Error())
+	}
+	blockStore := surfstore.NewBlockStoreWithStorage(encrypted)
+	for _, data := range []string{"first block", "second block"} {
+		blockStore.PutBlock(ctx, &surfstore.Block{BlockData: []byte(data), BlockSize: int32(len(data))})
+	}
+	stats, _ := blockStore.GetStats(ctx, &emptypb.Empty{})
+
+	// the blocks are accounted for from their files, without the key to open them
+	writeKeyFile(t, keyFile, `{"CurrentKeyId": "new", "Keys": {"new": "`+NEW_AT_REST_KEY+`"}}`)
+	encrypted, err = surfstore.NewEncryptedBlockStorage(diskStorage, keyFile)
+	if err != nil {
+		t.Fatalf("Could not load key file: %s", err.Error())
+	}
+	restartedStats, _ := surfstore.NewBlockStoreWithStorage(encrypted).GetStats(ctx, &emptypb.Empty{})
+	if restartedStats.BlockCount != 2 || restartedStats.BytesUsed != stats.BytesUsed {
+		t.Fatalf("Expected %v after the restart, got %v", stats, restartedStats)
+	}
+}
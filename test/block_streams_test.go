@@ -0,0 +1,239 @@
+package SurfTest
+
+import (
+	"bytes"
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+// BlockStores served from the test process, with the Raft servers of the
+// config written by streamingTestConfig
+var STREAMING_BLOCK_ADDRS = []string{"localhost:8090", "localhost:8091"}
+
+func streamingTestConfig(t *testing.T) string {
+	cfgPath := filepath.Join(t.TempDir(), "streaming_blockstores.txt")
+	cfg := `{"RaftAddrs": ["localhost:9007", "localhost:9008", "localhost:9009"], "BlockAddrs": ["localhost:8090", "localhost:8091"]}`
+	if err := os.WriteFile(cfgPath, []byte(cfg), 0644); err != nil {
+		t.Fatalf("Could not write the config: %s", err.Error())
+	}
+	return cfgPath
+}
+
+// Serve a BlockStore at each address until the test ends
+func serveBlockStores(t *testing.T, addrs []string, newBlockStore func() surfstore.BlockStoreServer, opts ...grpc.ServerOption) {
+	for _, addr := range addrs {
+		listener, err := net.Listen("tcp", addr)
+		if err != nil {
+			t.Fatalf("Could not listen: %s", err.Error())
+		}
+		server := grpc.NewServer(opts...)
+		surfstore.RegisterBlockStoreServer(server, newBlockStore())
+		go server.Serve(listener)
+		t.Cleanup(server.Stop)
+	}
+}
+
+func newBlockStoreServer() surfstore.BlockStoreServer {
+	return surfstore.NewBlockStore()
+}
+
+// A BlockStore stream that takes a while for every block, so transfers take
+// longer than any single RPC deadline
+type slowServerStream struct {
+	grpc.ServerStream
+}
+
+func (s slowServerStream) SendMsg(m interface{}) error {
+	time.Sleep(3 * time.Millisecond)
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s slowServerStream) RecvMsg(m interface{}) error {
+	time.Sleep(3 * time.Millisecond)
+	return s.ServerStream.RecvMsg(m)
+}
+
+func slowStreams() grpc.ServerOption {
+	return grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		return handler(srv, slowServerStream{ss})
+	})
+}
+
+// A BlockStore storing every streamed block but answering that it did not
+type refusingBlockStore struct {
+	*surfstore.BlockStore
+}
+
+func (bs refusingBlockStore) PutBlocks(stream surfstore.BlockStore_PutBlocksServer) error {
+	for {
+		block, err := stream.Recv()
+		if err == io.EOF {
+			return stream.SendAndClose(&surfstore.Success{Flag: false})
+		}
+		if err != nil {
+			return err
+		}
+		bs.PutBlock(stream.Context(), block)
+	}
+}
+
+func dialBlockStore(t *testing.T, addr string) surfstore.BlockStoreClient {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatalf("Could not connect: %s", err.Error())
+	}
+	t.Cleanup(func() { conn.Close() })
+	return surfstore.NewBlockStoreClient(conn)
+}
+
+func TestGetBlocksStreamsBlocksInRequestOrder(t *testing.T) {
+	ctx := context.Background()
+	serveBlockStores(t, STREAMING_BLOCK_ADDRS[:1], newBlockStoreServer)
+	blockStoreC := dialBlockStore(t, STREAMING_BLOCK_ADDRS[0])
+
+	blocks := make(map[string][]byte)
+	hashes := make([]string, 0)
+	for i := 0; i < 20; i++ {
+		data := []byte("streamed block " + strconv.Itoa(i))
+		hash := surfstore.GetBlockHashString(data)
+		blocks[hash] = data
+		hashes = append([]string{hash}, hashes...)
+		blockStoreC.PutBlock(ctx, &surfstore.Block{BlockData: data, BlockSize: int32(len(data))})
+	}
+
+	// newest first, with a hash asked for twice and one that is not stored
+	hashes = append(hashes[:10], append([]string{"missing", hashes[3]}, hashes[10:]...)...)
+	stream, err := blockStoreC.GetBlocks(ctx, &surfstore.BlockHashes{Hashes: hashes})
+	if err != nil {
+		t.Fatalf("Could not open the stream: %s", err.Error())
+	}
+	for idx, hash := range hashes {
+		block, err := stream.Recv()
+		if err != nil {
+			t.Fatalf("Stream ended after %d of %d blocks: %s", idx, len(hashes), err.Error())
+		}
+		if !bytes.Equal(block.BlockData, blocks[hash]) {
+			t.Fatalf("Block %d is not the one asked for", idx)
+		}
+		if hash == "missing" && len(block.BlockData) != 0 {
+			t.Fatalf("Expected an empty block for a hash that is not stored")
+		}
+	}
+	if _, err := stream.Recv(); err != io.EOF {
+		t.Fatalf("Expected the stream to end after the blocks asked for, got %v", err)
+	}
+}
+
+func TestPutBlocksStopsAtBlockThatCannotBeStored(t *testing.T) {
+	ctx := context.Background()
+	serveBlockStores(t, STREAMING_BLOCK_ADDRS[:1], func() surfstore.BlockStoreServer {
+		blockStore := surfstore.NewBlockStore()
+		blockStore.Capacity = 50
+		return blockStore
+	})
+	blockStoreC := dialBlockStore(t, STREAMING_BLOCK_ADDRS[0])
+
+	stream, err := blockStoreC.PutBlocks(ctx)
+	if err != nil {
+		t.Fatalf("Could not open the stream: %s", err.Error())
+	}
+	hashes := make([]string, 0)
+	for i := 0; i < 5; i++ {
+		data := []byte("twenty byte block " + strconv.Itoa(i) + "!")
+		hashes = append(hashes, surfstore.GetBlockHashString(data))
+		if err := stream.Send(&surfstore.Block{BlockData: data, BlockSize: int32(len(data))}); err != nil {
+			break // the server already gave up on the stream
+		}
+	}
+	if _, err := stream.CloseAndRecv(); err == nil {
+		t.Fatalf("Expected the stream to fail once the BlockStore is full")
+	}
+
+	stored, _ := blockStoreC.HasBlocks(ctx, &surfstore.BlockHashes{Hashes: hashes})
+	if !SameHashList(stored.Hashes, hashes[:2]) {
+		t.Fatalf("Expected the blocks before the failed one to be stored, got %v", stored.Hashes)
+	}
+}
+
+func TestSyncFailsWhenBlockStoreDoesNotStoreStream(t *testing.T) {
+	for _, tc := range []struct {
+		name          string
+		newBlockStore func() surfstore.BlockStoreServer
+	}{
+		{"refused", func() surfstore.BlockStoreServer {
+			return refusingBlockStore{surfstore.NewBlockStore()}
+		}},
+		{"full", func() surfstore.BlockStoreServer {
+			blockStore := surfstore.NewBlockStore()
+			blockStore.Capacity = 3 * BLOCK_SIZE
+			return blockStore
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cfgPath := streamingTestConfig(t)
+			serveBlockStores(t, STREAMING_BLOCK_ADDRS, tc.newBlockStore)
+			test := InitTestWithoutBlockStores(cfgPath)
+			defer EndTest(test)
+			test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+			test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+			worker := InitDirectoryWorker("test0", SRC_PATH)
+			defer worker.CleanUp()
+			if err := os.WriteFile(filepath.Join(worker.DirectoryName, "large.bin"), randomData(64*BLOCK_SIZE, 11), 0644); err != nil {
+				t.Fatalf("Could not write large.bin: %s", err.Error())
+			}
+			if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err == nil {
+				t.Fatalf("Expected the sync to fail when the blocks were not stored")
+			}
+
+			fileInfoMap, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+			if _, found := fileInfoMap.FileInfoMap["large.bin"]; found {
+				t.Fatalf("A file whose blocks were not stored should not be in the metadata")
+			}
+		})
+	}
+}
+
+func TestSyncStreamsForLongerThanOneCallDeadline(t *testing.T) {
+	cfgPath := streamingTestConfig(t)
+	serveBlockStores(t, STREAMING_BLOCK_ADDRS, newBlockStoreServer, slowStreams())
+	test := InitTestWithoutBlockStores(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	// about 500 blocks, each taking a few milliseconds on the way up and down
+	data := randomData(2<<20, 13)
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "slow.bin"), data, 0644); err != nil {
+		t.Fatalf("Could not write slow.bin: %s", err.Error())
+	}
+	for _, dir := range []string{"test0", "test1"} {
+		start := time.Now()
+		if err := SyncClient("localhost:8080", dir, DEFAULT_BLOCK_SIZE, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		if took := time.Since(start); took < time.Second {
+			t.Fatalf("Expected the transfer to outlast a one second deadline, took %v", took)
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "slow.bin")); !bytes.Equal(got, data) {
+		t.Fatalf("Expected slow.bin to be downloaded whole, got %d bytes", len(got))
+	}
+}
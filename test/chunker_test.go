@@ -0,0 +1,139 @@
+package SurfTest
+
+import (
+	"bytes"
+	"cse224/proj5/pkg/surfstore"
+	"math/rand"
+	"testing"
+	"testing/iotest"
+)
+
+func randomData(size int, seed int64) []byte {
+	data := make([]byte, size)
+	rand.New(rand.NewSource(seed)).Read(data)
+	return data
+}
+
+func chunkHashes(chunks [][]byte) map[string]bool {
+	hashes := make(map[string]bool)
+	for _, chunk := range chunks {
+		hashes[surfstore.GetBlockHashString(chunk)] = true
+	}
+	return hashes
+}
+
+func TestFastCDCChunksStayWithinBounds(t *testing.T) {
+	chunker, err := surfstore.ParseChunker("fastcdc:1024:4096:16384", BLOCK_SIZE)
+	if err != nil {
+		t.Fatalf("Could not parse chunker: %s", err.Error())
+	}
+	data := randomData(1<<20, 1)
+	chunks := chunker.Split(data)
+
+	if !bytes.Equal(bytes.Join(chunks, nil), data) {
+		t.Fatalf("Chunks do not add up to the data")
+	}
+	for i, chunk := range chunks {
+		if len(chunk) > 16384 || (len(chunk) < 1024 && i != len(chunks)-1) {
+			t.Fatalf("Chunk %d has %d bytes, outside [1024, 16384]", i, len(chunk))
+		}
+	}
+	if average := len(data) / len(chunks); average < 2048 || average > 8192 {
+		t.Fatalf("Expected chunks to average about 4096 bytes, got %d", average)
+	}
+}
+
+func TestReadChunksSplitsLikeSplit(t *testing.T) {
+	fastCDC, _ := surfstore.ParseChunker("fastcdc:256:1024:4096", BLOCK_SIZE)
+	data := randomData(100*1024+17, 4)
+	for _, chunker := range []surfstore.Chunker{surfstore.FixedChunker{BlockSize: BLOCK_SIZE}, fastCDC} {
+		expected := chunker.Split(data)
+		chunks := make([][]byte, 0)
+		err := surfstore.ReadChunks(iotest.HalfReader(bytes.NewReader(data)), chunker, func(chunk []byte) error {
+			chunks = append(chunks, append([]byte{}, chunk...))
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Could not read the chunks: %s", err.Error())
+		}
+		if len(chunks) != len(expected) {
+			t.Fatalf("Expected %d chunks, got %d", len(expected), len(chunks))
+		}
+		for i := range chunks {
+			if !bytes.Equal(chunks[i], expected[i]) {
+				t.Fatalf("Chunk %d differs from Split", i)
+			}
+		}
+	}
+}
+
+func TestFastCDCInsertOnlyChangesNearbyChunks(t *testing.T) {
+	chunker, _ := surfstore.ParseChunker("fastcdc", 4096)
+	data := randomData(256*1024, 2)
+	edited := append([]byte{'x'}, data...)
+
+	before := chunker.Split(data)
+	after := chunkHashes(chunker.Split(edited))
+	unchanged := 0
+	for hash := range chunkHashes(before) {
+		if after[hash] {
+			unchanged++
+		}
+	}
+	if unchanged < len(before)-2 {
+		t.Fatalf("Inserting a byte at the start changed %d of %d chunks", len(before)-unchanged, len(before))
+	}
+
+	// fixed chunks all shift
+	fixed := surfstore.FixedChunker{BlockSize: 4096}
+	fixedAfter := chunkHashes(fixed.Split(edited))
+	for hash := range chunkHashes(fixed.Split(data)) {
+		if fixedAfter[hash] {
+			t.Fatalf("Expected every fixed chunk to change")
+		}
+	}
+}
+
+func TestParseChunker(t *testing.T) {
+	fixed, err := surfstore.ParseChunker("", BLOCK_SIZE)
+	if err != nil || fixed.Spec() != "" || len(fixed.Split(make([]byte, 2*BLOCK_SIZE))) != 2 {
+		t.Fatalf("The empty spec should be fixed BlockSize chunks")
+	}
+
+	cdc, err := surfstore.ParseChunker("fastcdc", 5000)
+	if err != nil || cdc.Spec() != "fastcdc:1024:4096:16384" {
+		t.Fatalf("Expected the default sizes around 4096, got %v", err)
+	}
+	reparsed, err := surfstore.ParseChunker(cdc.Spec(), 1)
+	if err != nil || reparsed != cdc {
+		t.Fatalf("A recorded spec should give the same chunker whatever the block size")
+	}
+
+	for _, spec := range []string{"rabin", "fastcdc:1:2", "fastcdc:4096:1024:8192", "fastcdc:1024:3000:8192", "fastcdc:a:b:c"} {
+		if _, err := surfstore.ParseChunker(spec, BLOCK_SIZE); err == nil {
+			t.Fatalf("Expected %q to be rejected", spec)
+		}
+	}
+}
+
+func TestIndexRecordsChunker(t *testing.T) {
+	baseDir := t.TempDir()
+	metas := map[string]*surfstore.FileMetaData{
+		"fixed.txt": NewFileMetaDataFromParams("fixed.txt", 1, []string{"a"}),
+		"cdc.txt":   {Filename: "cdc.txt", Version: 2, BlockHashList: []string{"b", "c"}, Chunker: "fastcdc:1024:4096:16384"},
+	}
+	if err := surfstore.WriteMetaFile(metas, baseDir); err != nil {
+		t.Fatalf("Could not write index: %s", err.Error())
+	}
+
+	loaded, err := surfstore.LoadMetaFromMetaFile(baseDir)
+	if err != nil {
+		t.Fatalf("Could not load index: %s", err.Error())
+	}
+	if loaded["fixed.txt"].Chunker != "" || loaded["cdc.txt"].Chunker != "fastcdc:1024:4096:16384" {
+		t.Fatalf("Chunkers not recorded, got %q and %q", loaded["fixed.txt"].Chunker, loaded["cdc.txt"].Chunker)
+	}
+	if !SameHashList(loaded["cdc.txt"].BlockHashList, []string{"b", "c"}) {
+		t.Fatalf("Unexpected hash list %v", loaded["cdc.txt"].BlockHashList)
+	}
+}
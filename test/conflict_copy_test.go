@@ -0,0 +1,153 @@
+package SurfTest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestConflictKeepsLocalEditsAsCopy(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	path1 := filepath.Join(worker1.DirectoryName, "dir/notes.txt")
+	path2 := filepath.Join(worker2.DirectoryName, "dir/notes.txt")
+	if err := os.MkdirAll(filepath.Dir(path1), 0755); err != nil {
+		t.Fatalf("Could not create dir: %s", err.Error())
+	}
+	if err := os.WriteFile(path1, []byte("original"), 0644); err != nil {
+		t.Fatalf("Could not write notes.txt: %s", err.Error())
+	}
+	for _, dir := range []string{"test0", "test1"} {
+		if err := SyncClient("localhost:8080", dir, BLOCK_SIZE, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+
+	// both clients edit the file, client2 syncs first
+	if err := os.WriteFile(path1, []byte("edited on client1"), 0644); err != nil {
+		t.Fatalf("Could not write notes.txt: %s", err.Error())
+	}
+	if err := os.WriteFile(path2, []byte("edited on client2"), 0644); err != nil {
+		t.Fatalf("Could not write notes.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-conflict", "copy"); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	if data, _ := os.ReadFile(path1); string(data) != "edited on client2" {
+		t.Fatalf("Expected client1 to take the server's version, got %q", string(data))
+	}
+	copies, _ := filepath.Glob(filepath.Join(worker1.DirectoryName, "dir/notes (conflicted copy from *).txt"))
+	if len(copies) != 1 {
+		t.Fatalf("Expected one conflicted copy, got %v", copies)
+	}
+	if data, _ := os.ReadFile(copies[0]); string(data) != "edited on client1" {
+		t.Fatalf("Expected the conflicted copy to hold client1's edits, got %q", string(data))
+	}
+	copyName := "dir/" + filepath.Base(copies[0])
+
+	// the copy is an ordinary file for the other client
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, copyName)); string(data) != "edited on client1" {
+		t.Fatalf("Expected client2 to download the conflicted copy, got %q", string(data))
+	}
+
+	fileMeta, err := LoadMetaFromDB(worker1.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load meta file for client1")
+	}
+	if fileMeta["dir/notes.txt"] == nil || fileMeta["dir/notes.txt"].Version != 2 || fileMeta[copyName] == nil || fileMeta[copyName].Version != 1 {
+		t.Fatalf("Expected notes.txt at version 2 and its copy at version 1, got %v", fileMeta)
+	}
+	for name := range fileMeta {
+		if strings.Contains(name, "conflicted copy") && name != copyName {
+			t.Fatalf("Unexpected second copy %s", name)
+		}
+	}
+}
+
+func TestFileCreatedOnBothClientsTakesServerVersion(t *testing.T) {
+	cfgPath := streamingTestConfig(t)
+	// the first upload waits until the other client has uploaded the same file
+	var gated int32
+	uploading := make(chan struct{})
+	release := make(chan struct{})
+	serveBlockStores(t, STREAMING_BLOCK_ADDRS, newBlockStoreServer, grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod == "/surfstore.BlockStore/PutBlocks" && atomic.CompareAndSwapInt32(&gated, 0, 1) {
+			close(uploading)
+			<-release
+		}
+		return handler(srv, ss)
+	}))
+	test := InitTestWithoutBlockStores(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "notes.txt"), []byte("created on client1"), 0644); err != nil {
+		t.Fatalf("Could not write notes.txt: %s", err.Error())
+	}
+	if err := os.WriteFile(filepath.Join(worker2.DirectoryName, "notes.txt"), []byte("created on client2"), 0644); err != nil {
+		t.Fatalf("Could not write notes.txt: %s", err.Error())
+	}
+
+	client2 := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "test1", strconv.Itoa(BLOCK_SIZE))
+	client2.Stdout = os.Stdout
+	client2.Stderr = os.Stderr
+	if err := client2.Start(); err != nil {
+		t.Fatalf("Could not start client2: %s", err.Error())
+	}
+	select {
+	case <-uploading:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("client2 did not start uploading")
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	close(release)
+	if err := client2.Wait(); err != nil {
+		t.Fatalf("Expected client2 to take the uploaded version, sync failed: %s", err.Error())
+	}
+
+	if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "notes.txt")); string(data) != "created on client1" {
+		t.Fatalf("Expected client2 to take the server's version, got %q", string(data))
+	}
+	fileInfoMap, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	fileMeta, err := LoadMetaFromDB(worker2.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load meta file for client2")
+	}
+	if fileMeta["notes.txt"] == nil || fileMeta["notes.txt"].Version != 1 || !SameHashList(fileMeta["notes.txt"].BlockHashList, fileInfoMap.FileInfoMap["notes.txt"].BlockHashList) {
+		t.Fatalf("Expected client2 to record the server's version 1, got %v", fileMeta["notes.txt"])
+	}
+}
@@ -0,0 +1,123 @@
+package SurfTest
+
+import (
+	"bytes"
+	"cse224/proj5/pkg/surfstore"
+	"database/sql"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestDryRunPrintsPlanWithoutChanges(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	write := func(dir string, name string, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+	}
+	for _, name := range []string{"changed.txt", "deleted.txt", "conflict.txt"} {
+		write(worker1.DirectoryName, name, "original "+name)
+	}
+	for _, dir := range []string{"test0", "test1"} {
+		if err := SyncClient("localhost:8080", dir, BLOCK_SIZE, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+
+	// client2 changes the server, client1 has local changes
+	write(worker2.DirectoryName, "conflict.txt", "client2's version")
+	write(worker2.DirectoryName, "remote.txt", "only on the server")
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	write(worker1.DirectoryName, "changed.txt", "changed locally")
+	write(worker1.DirectoryName, "conflict.txt", "client1's version")
+	write(worker1.DirectoryName, "new.txt", "new locally")
+	if err := os.Remove(filepath.Join(worker1.DirectoryName, "deleted.txt")); err != nil {
+		t.Fatalf("Could not remove deleted.txt: %s", err.Error())
+	}
+
+	// an index.db written before selections and chunkers were recorded
+	db, err := sql.Open("sqlite3", filepath.Join(worker1.DirectoryName, "index.db"))
+	if err != nil {
+		t.Fatalf("Could not open index.db: %s", err.Error())
+	}
+	for _, table := range []string{"selections", "unmaterialized", "chunkers"} {
+		if _, err := db.Exec("drop table " + table + ";"); err != nil {
+			t.Fatalf("Could not drop %s: %s", table, err.Error())
+		}
+	}
+	db.Close()
+
+	indexBefore, _ := os.ReadFile(filepath.Join(worker1.DirectoryName, "index.db"))
+	remoteBefore, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+
+	clientCmd := exec.Command("_bin/SurfstoreClientExec", "-f", cfgPath, "-dry-run", "-plan", "json", "test0", strconv.Itoa(BLOCK_SIZE))
+	clientCmd.Stderr = os.Stderr
+	output, err := clientCmd.Output()
+	if err != nil {
+		t.Fatalf("Dry run failed: %s", err.Error())
+	}
+	var plan surfstore.SyncPlan
+	if err := json.Unmarshal(output, &plan); err != nil {
+		t.Fatalf("Could not parse the plan %q: %s", string(output), err.Error())
+	}
+
+	expected := map[string]string{
+		"changed.txt":  surfstore.PLAN_UPLOAD,
+		"new.txt":      surfstore.PLAN_UPLOAD,
+		"deleted.txt":  surfstore.PLAN_DELETE_REMOTE,
+		"conflict.txt": surfstore.PLAN_CONFLICT,
+		"remote.txt":   surfstore.PLAN_DOWNLOAD,
+	}
+	if len(plan.Actions) != len(expected) {
+		t.Fatalf("Expected %d actions, got %v", len(expected), plan.Actions)
+	}
+	for _, action := range plan.Actions {
+		if expected[action.Filename] != action.Action {
+			t.Fatalf("Expected %s to %s, got %v", action.Filename, expected[action.Filename], action)
+		}
+	}
+	if plan.UploadBytes != int64(len("changed locally")+len("new locally")) {
+		t.Fatalf("Expected the uploads of changed.txt and new.txt, got %d bytes", plan.UploadBytes)
+	}
+
+	// nothing changed locally or on the server
+	indexAfter, _ := os.ReadFile(filepath.Join(worker1.DirectoryName, "index.db"))
+	if !bytes.Equal(indexBefore, indexAfter) {
+		t.Fatalf("Expected index.db to be left alone")
+	}
+	if _, err := os.Stat(filepath.Join(worker1.DirectoryName, "remote.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected remote.txt not to be downloaded, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(worker1.DirectoryName, "conflict.txt")); string(data) != "client1's version" {
+		t.Fatalf("Expected conflict.txt to be left alone, got %q", string(data))
+	}
+	remoteAfter, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	for name, before := range remoteBefore.FileInfoMap {
+		if after := remoteAfter.FileInfoMap[name]; after.Version != before.Version || !SameHashList(after.BlockHashList, before.BlockHashList) {
+			t.Fatalf("Expected %s to be unchanged on the server, got %v", name, after)
+		}
+	}
+	if len(remoteAfter.FileInfoMap) != len(remoteBefore.FileInfoMap) {
+		t.Fatalf("Expected no new files on the server, got %v", remoteAfter.FileInfoMap)
+	}
+}
@@ -0,0 +1,124 @@
+package SurfTest
+
+import (
+	context "context"
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func zonedServers() []surfstore.BlockServerConfig {
+	return []surfstore.BlockServerConfig{
+		{Addr: "localhost:8080", Weight: 1, Zone: "a", Rack: "r1"},
+		{Addr: "localhost:8081", Weight: 1, Zone: "a", Rack: "r2"},
+		{Addr: "localhost:8082", Weight: 1, Zone: "b", Rack: "r1"},
+		{Addr: "localhost:8083", Weight: 1, Zone: "b", Rack: "r2"},
+		{Addr: "localhost:8084", Weight: 1, Zone: "c", Rack: "r1"},
+	}
+}
+
+func zoneOf(addr string) string {
+	for _, server := range zonedServers() {
+		if server.Addr == addr {
+			return server.Zone
+		}
+	}
+	return ""
+}
+
+func TestReplicasSkipUsedFailureDomains(t *testing.T) {
+	strategies := map[string]surfstore.PlacementStrategy{
+		"ring":       surfstore.NewWeightedConsistentHashRing(zonedServers(), 16),
+		"rendezvous": surfstore.NewRendezvousHash(zonedServers()),
+	}
+	for name, placement := range strategies {
+		for _, hash := range benchmarkHashes(200) {
+			replicas := placement.GetReplicaServers(hash, 3)
+			if len(replicas) != 3 || replicas[0] != placement.GetResponsibleServer(hash) {
+				t.Fatalf("%s: expected 3 replicas starting with the responsible server for %s, got %v", name, hash, replicas)
+			}
+			zones := map[string]bool{zoneOf(replicas[0]): true, zoneOf(replicas[1]): true, zoneOf(replicas[2]): true}
+			if len(zones) != 3 {
+				t.Fatalf("%s: replicas of %s share a zone: %v", name, hash, replicas)
+			}
+
+			// with more copies than zones, the extra copies still avoid used racks
+			replicas = placement.GetReplicaServers(hash, 4)
+			if len(surfstore.FindSharedFailureDomains(replicas, zonedServers())) != 1 {
+				t.Fatalf("%s: expected only the fourth copy to share a zone for %s, got %v", name, hash, replicas)
+			}
+		}
+	}
+}
+
+func TestSharedFailureDomains(t *testing.T) {
+	servers := []surfstore.BlockServerConfig{
+		{Addr: "localhost:8080", Zone: "a", Rack: "r1"},
+		{Addr: "localhost:8081", Zone: "a", Rack: "r2"},
+		{Addr: "localhost:8082", Rack: "r1"},
+		{Addr: "localhost:8083", Rack: "r1"},
+		{Addr: "localhost:8084"},
+	}
+	expected := map[[2]string]bool{
+		{"localhost:8080", "localhost:8081"}: true, // same zone
+		{"localhost:8082", "localhost:8083"}: true, // same rack, no zone
+	}
+
+	holders := []string{"localhost:8080", "localhost:8081", "localhost:8082", "localhost:8083", "localhost:8084", "localhost:9999"}
+	shared := surfstore.FindSharedFailureDomains(holders, servers)
+	if len(shared) != len(expected) {
+		t.Fatalf("Expected %d shared pairs, got %v", len(expected), shared)
+	}
+	for _, pair := range shared {
+		if !expected[pair] {
+			t.Fatalf("Unexpected shared pair %v", pair)
+		}
+	}
+}
+
+func TestBlockStoreMapListsEveryReplica(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.txt")
+	contents := `{
+		"RaftAddrs": ["localhost:9007"],
+		"BlockAddrs": [
+			{"Addr": "localhost:8080", "Zone": "a"},
+			{"Addr": "localhost:8081", "Zone": "a"},
+			{"Addr": "localhost:8082", "Zone": "b", "Rack": "r1"}
+		],
+		"ReplicationFactor": 2
+	}`
+	if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Could not write config: %s", err.Error())
+	}
+	config := surfstore.LoadRaftConfigFile(configFile)
+	if config.ReplicationFactor != 2 || config.GetBlockServers()[2].Rack != "r1" {
+		t.Fatalf("Unexpected config %v", config)
+	}
+
+	metaStore := surfstore.NewMetaStore(config.BlockAddrs, nil)
+	metaStore.ApplyBlockMembership(config.GetBlockMembership())
+
+	hashes := benchmarkHashes(100)
+	blockStoreMap, _ := metaStore.GetBlockStoreMap(context.Background(), &surfstore.BlockHashes{Hashes: hashes})
+	holders := make(map[string][]string)
+	for addr, blockHashes := range blockStoreMap.BlockStoreMap {
+		for _, hash := range blockHashes.Hashes {
+			holders[hash] = append(holders[hash], addr)
+		}
+	}
+	for _, hash := range hashes {
+		if len(holders[hash]) != 2 || !containsAddr(holders[hash], "localhost:8082") {
+			t.Fatalf("Expected a copy of %s in each zone, got %v", hash, holders[hash])
+		}
+	}
+}
+
+func containsAddr(addrs []string, target string) bool {
+	for _, addr := range addrs {
+		if addr == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,131 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestIgnoreRules(t *testing.T) {
+	rules := surfstore.NewIgnoreRules()
+	rules.AddPatterns("", "# build output\n*.o\nbuild/\n/top.txt\ndocs/**/*.tmp\n!keep.o\nlogs/**\n")
+	rules.AddPatterns("sub/", "local.txt\n!/top.txt\n")
+
+	expected := map[string]bool{
+		"a.o":             true,
+		"src/deep/b.o":    true,
+		"keep.o":          false,
+		"src/keep.o":      false,
+		"build/":          true,
+		"build/out.txt":   true,
+		"src/build/x":     true,
+		"build":           false, // a file, not the directory
+		"top.txt":         true,
+		"src/top.txt":     false,
+		"docs/a.tmp":      true,
+		"docs/x/y/a.tmp":  true,
+		"docs/a.txt":      false,
+		"logs/":           false,
+		"logs/today.log":  true,
+		"sub/local.txt":   true,
+		"sub/x/local.txt": true,
+		"local.txt":       false,
+		"sub/top.txt":     false,
+		"main.go":         false,
+		"src/":            false,
+	}
+	for key, ignored := range expected {
+		if rules.Ignored(key) != ignored {
+			t.Fatalf("Expected Ignored(%q) to be %t", key, ignored)
+		}
+	}
+}
+
+func TestSyncSkipsIgnoredFiles(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	globalIgnore := filepath.Join(t.TempDir(), "global")
+	if err := os.WriteFile(globalIgnore, []byte("*.swp\n"), 0644); err != nil {
+		t.Fatalf("Could not write the global ignore file: %s", err.Error())
+	}
+
+	files := map[string]string{
+		".surfignore":     "*.log\ncache/\n",
+		"sub/.surfignore": "secret.txt\n",
+		"a.txt":           "synced",
+		"a.log":           "ignored",
+		"a.txt.swp":       "ignored globally",
+		"cache/x.bin":     "ignored",
+		"sub/b.txt":       "synced",
+		"sub/secret.txt":  "ignored",
+	}
+	for name, content := range files {
+		path := filepath.Join(worker1.DirectoryName, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create %s: %s", name, err.Error())
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+	}
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-ignore", globalIgnore); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	fileInfoMap, err := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get the file info map: %s", err.Error())
+	}
+	for _, key := range []string{".surfignore", "sub/", "sub/.surfignore", "a.txt", "sub/b.txt"} {
+		if fileInfoMap.FileInfoMap[key] == nil {
+			t.Fatalf("Expected %s to be uploaded", key)
+		}
+	}
+	for _, key := range []string{"a.log", "a.txt.swp", "cache/", "cache/x.bin", "sub/secret.txt"} {
+		if fileInfoMap.FileInfoMap[key] != nil {
+			t.Fatalf("Expected %s not to be uploaded", key)
+		}
+	}
+
+	// a file ignored on client2 is not downloaded, and ignoring it later does not delete it
+	if err := os.WriteFile(filepath.Join(worker2.DirectoryName, ".surfignore"), []byte("*.log\ncache/\nb.txt\n"), 0644); err != nil {
+		t.Fatalf("Could not write .surfignore: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "sub/b.txt")); !os.IsNotExist(err) {
+		t.Fatalf("Expected sub/b.txt not to be downloaded, got %v", err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "a.txt")); string(data) != "synced" {
+		t.Fatalf("Expected a.txt to be downloaded, got %q", string(data))
+	}
+
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, ".surfignore"), []byte("*.log\ncache/\na.txt\n"), 0644); err != nil {
+		t.Fatalf("Could not write .surfignore: %s", err.Error())
+	}
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-ignore", globalIgnore); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileInfoMap, _ = test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if fileInfoMap.FileInfoMap["a.txt"] == nil || fileInfoMap.FileInfoMap["a.txt"].Version != 1 {
+		t.Fatalf("Expected a.txt to stay at version 1 once ignored, got %v", fileInfoMap.FileInfoMap["a.txt"])
+	}
+	fileMeta, err := LoadMetaFromDB(worker1.DirectoryName)
+	if err != nil || fileMeta["a.txt"] == nil {
+		t.Fatalf("Expected index.db to keep the ignored a.txt")
+	}
+}
@@ -0,0 +1,135 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSyncResumesFromJournal(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	defer worker1.CleanUp()
+	filePath := filepath.Join(worker1.DirectoryName, "a.txt")
+	indexPath := filepath.Join(worker1.DirectoryName, surfstore.DEFAULT_META_FILENAME)
+
+	if err := os.WriteFile(filePath, []byte("one"), 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	oldIndex, err := os.ReadFile(indexPath)
+	if err != nil {
+		t.Fatalf("Could not read index.db: %s", err.Error())
+	}
+
+	// the sync of version 2 is cut short after the server accepted it, with a
+	// download still being written
+	if err := os.WriteFile(filePath, []byte("two"), 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileInfoMap, err := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if err != nil || fileInfoMap.FileInfoMap["a.txt"].Version != 2 {
+		t.Fatalf("Expected version 2 of a.txt on the server, got %v", fileInfoMap)
+	}
+	if err := os.WriteFile(indexPath, oldIndex, 0644); err != nil {
+		t.Fatalf("Could not restore index.db: %s", err.Error())
+	}
+	tempPath := filepath.Join(worker1.DirectoryName, surfstore.DOWNLOAD_TEMP_PREFIX+"123")
+	if err := os.WriteFile(tempPath, []byte("half a downl"), 0644); err != nil {
+		t.Fatalf("Could not write the temporary file: %s", err.Error())
+	}
+	journal, err := surfstore.OpenJournal(worker1.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not open the journal: %s", err.Error())
+	}
+	journal.Planned("a.txt", surfstore.PLAN_UPLOAD)
+	journal.Committed(fileInfoMap.FileInfoMap["a.txt"])
+	journal.Planned("b.txt", surfstore.PLAN_DOWNLOAD)
+	journal.Downloading("b.txt", tempPath)
+	journal.Close()
+	if entries, err := surfstore.LoadJournal(worker1.DirectoryName); err != nil || len(entries) != 4 {
+		t.Fatalf("Expected 4 journal entries, got %v (%v)", entries, err)
+	}
+
+	// an edit made before the restart is a new version, not a conflict
+	if err := os.WriteFile(filePath, []byte("three"), 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	if data, _ := os.ReadFile(filePath); string(data) != "three" {
+		t.Fatalf("Expected the edit of a.txt to be kept, got %q", string(data))
+	}
+	fileInfoMap, _ = test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if fileInfoMap.FileInfoMap["a.txt"].Version != 3 {
+		t.Fatalf("Expected the edit to be uploaded as version 3, got %v", fileInfoMap.FileInfoMap["a.txt"])
+	}
+	if len(fileInfoMap.FileInfoMap) != 1 {
+		t.Fatalf("Expected the temporary file not to be uploaded, got %v", fileInfoMap.FileInfoMap)
+	}
+	if _, err := os.Stat(tempPath); !os.IsNotExist(err) {
+		t.Fatalf("Expected the unfinished download to be removed, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worker1.DirectoryName, surfstore.DEFAULT_JOURNAL_FILENAME)); !os.IsNotExist(err) {
+		t.Fatalf("Expected the journal to be removed once the sync finished, got %v", err)
+	}
+	fileMeta, err := LoadMetaFromDB(worker1.DirectoryName)
+	if err != nil || fileMeta["a.txt"].Version != 3 {
+		t.Fatalf("Expected index.db to hold version 3 of a.txt, got %v", fileMeta)
+	}
+}
+
+func TestResumedSyncUploadsBlocksNoLongerStored(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	content := []byte("uploaded before the sync was cut short, then swept")
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "a.txt"), content, 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+
+	// the journal says the block was uploaded, but it is not stored anymore
+	journal, err := surfstore.OpenJournal(worker1.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not open the journal: %s", err.Error())
+	}
+	journal.Planned("a.txt", surfstore.PLAN_UPLOAD)
+	journal.Uploaded("a.txt", []string{surfstore.GetBlockHashString(content)})
+	journal.Close()
+
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "a.txt")); string(data) != string(content) {
+		t.Fatalf("Expected the block to be uploaded again, got %q", string(data))
+	}
+}
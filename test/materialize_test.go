@@ -0,0 +1,74 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestDownloadReplacesFileAtomically(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+	oldContent := strings.Repeat("old ", 3*BLOCK_SIZE)
+	newContent := strings.Repeat("new!", 2*BLOCK_SIZE)
+
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "a.txt"), []byte(oldContent), 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+	for _, dir := range []string{"test0", "test1"} {
+		if err := SyncClient("localhost:8080", dir, BLOCK_SIZE, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+	path := filepath.Join(worker2.DirectoryName, "a.txt")
+	if err := os.Chmod(path, 0600); err != nil {
+		t.Fatalf("Could not change the mode of a.txt: %s", err.Error())
+	}
+
+	// a reader of the old file keeps seeing all of it
+	reader, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Could not open a.txt: %s", err.Error())
+	}
+	defer reader.Close()
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "a.txt"), []byte(newContent), 0644); err != nil {
+		t.Fatalf("Could not write a.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	if data, _ := io.ReadAll(reader); string(data) != oldContent {
+		t.Fatalf("Expected the old file to be left whole, got %d bytes", len(data))
+	}
+	if data, _ := os.ReadFile(path); string(data) != newContent {
+		t.Fatalf("Expected a.txt to be downloaded, got %q", string(data))
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Fatalf("Expected a.txt to keep its mode, got %v (%v)", info.Mode(), err)
+	}
+	entries, _ := os.ReadDir(worker2.DirectoryName)
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), surfstore.DOWNLOAD_TEMP_PREFIX) {
+			t.Fatalf("Expected no temporary files to be left, found %s", entry.Name())
+		}
+	}
+}
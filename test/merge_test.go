@@ -0,0 +1,117 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestMergeText(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	cases := []struct {
+		name   string
+		local  string
+		remote string
+		merged string
+		ok     bool
+	}{
+		{"separate edits", "A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", true},
+		{"insert and delete", "a\nb\nc\nd\nnew\ne\n", "a\nc\nd\ne\n", "a\nc\nd\nnew\ne\n", true},
+		{"delete next to an edit", "a\nb\nnew\nc\nd\ne\n", "a\nc\nd\ne\n", "", false},
+		{"same edit", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", true},
+		{"append on both ends", "start\na\nb\nc\nd\ne\n", "a\nb\nc\nd\ne\nend\n", "start\na\nb\nc\nd\ne\nend\n", true},
+		{"same line differently", "a\nb\nlocal\nd\ne\n", "a\nb\nremote\nd\ne\n", "", false},
+		{"binary", "a\x00\n", "a\nb\nc\nd\ne\n", "", false},
+	}
+	for _, c := range cases {
+		merged, ok := surfstore.MergeText([]byte(base), []byte(c.local), []byte(c.remote))
+		if ok != c.ok || string(merged) != c.merged {
+			t.Fatalf("%s: expected %q (%t), got %q (%t)", c.name, c.merged, c.ok, string(merged), ok)
+		}
+	}
+}
+
+func TestConflictMergesTextEdits(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	path1 := filepath.Join(worker1.DirectoryName, "config.ini")
+	path2 := filepath.Join(worker2.DirectoryName, "config.ini")
+	if err := os.WriteFile(path1, []byte("[server]\nport = 80\n\n[client]\nretries = 1\n"), 0644); err != nil {
+		t.Fatalf("Could not write config.ini: %s", err.Error())
+	}
+	for _, dir := range []string{"test0", "test1"} {
+		if err := SyncClient("localhost:8080", dir, BLOCK_SIZE, cfgPath); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+
+	// the clients edit different sections, client2 syncs first
+	if err := os.WriteFile(path1, []byte("[server]\nport = 8080\n\n[client]\nretries = 1\n"), 0644); err != nil {
+		t.Fatalf("Could not write config.ini: %s", err.Error())
+	}
+	if err := os.WriteFile(path2, []byte("[server]\nport = 80\n\n[client]\nretries = 3\n"), 0644); err != nil {
+		t.Fatalf("Could not write config.ini: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-conflict", "merge"); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	expected := "[server]\nport = 8080\n\n[client]\nretries = 3\n"
+	for _, path := range []string{path1, path2} {
+		if data, _ := os.ReadFile(path); string(data) != expected {
+			t.Fatalf("Expected %s to hold the merged file, got %q", path, string(data))
+		}
+	}
+	if copies, _ := filepath.Glob(filepath.Join(worker1.DirectoryName, "*conflicted copy*")); len(copies) != 0 {
+		t.Fatalf("Expected no conflicted copy, got %v", copies)
+	}
+	fileMeta, err := LoadMetaFromDB(worker2.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load meta file for client2")
+	}
+	if fileMeta["config.ini"] == nil || fileMeta["config.ini"].Version != 3 {
+		t.Fatalf("Expected the merge to be version 3, got %v", fileMeta)
+	}
+
+	// edits to the same line cannot merge and get a conflicted copy
+	if err := os.WriteFile(path1, []byte("[server]\nport = 1\n\n[client]\nretries = 3\n"), 0644); err != nil {
+		t.Fatalf("Could not write config.ini: %s", err.Error())
+	}
+	if err := os.WriteFile(path2, []byte("[server]\nport = 2\n\n[client]\nretries = 3\n"), 0644); err != nil {
+		t.Fatalf("Could not write config.ini: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-conflict", "merge"); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if data, _ := os.ReadFile(path1); string(data) != "[server]\nport = 2\n\n[client]\nretries = 3\n" {
+		t.Fatalf("Expected client1 to take the server's version, got %q", string(data))
+	}
+	if copies, _ := filepath.Glob(filepath.Join(worker1.DirectoryName, "config (conflicted copy from *).ini")); len(copies) != 1 {
+		t.Fatalf("Expected one conflicted copy, got %v", copies)
+	}
+}
@@ -0,0 +1,96 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func rendezvousServers() []surfstore.BlockServerConfig {
+	return []surfstore.BlockServerConfig{
+		{Addr: "localhost:8080", Weight: 1},
+		{Addr: "localhost:8081", Weight: 1},
+		{Addr: "localhost:8082", Weight: 2},
+	}
+}
+
+func TestRendezvousSpreadsBlocksByWeight(t *testing.T) {
+	placement := surfstore.NewRendezvousHash(rendezvousServers())
+	expected := map[string]float64{"localhost:8080": 0.25, "localhost:8081": 0.25, "localhost:8082": 0.5}
+
+	hashes := benchmarkHashes(10000)
+	counts := make(map[string]int)
+	for _, owner := range placement.LookupMany(hashes) {
+		counts[owner]++
+	}
+	for addr, share := range expected {
+		if math.Abs(float64(counts[addr])/10000-share) > 0.03 {
+			t.Fatalf("Expected %s to get about %.2f of the blocks, got %d", addr, share, counts[addr])
+		}
+		if math.Abs(placement.GetOwnershipShares()[addr]-share) > 1e-9 {
+			t.Fatalf("Expected %s to own %.2f, got %.3f", addr, share, placement.GetOwnershipShares()[addr])
+		}
+	}
+}
+
+func TestRendezvousTopServers(t *testing.T) {
+	placement := surfstore.NewRendezvousHash(rendezvousServers())
+	for _, hash := range benchmarkHashes(100) {
+		top := placement.GetTopServers(hash, 2)
+		if len(top) != 2 || top[0] == top[1] || top[0] != placement.GetResponsibleServer(hash) {
+			t.Fatalf("Expected the responsible server and one other for %s, got %v", hash, top)
+		}
+		if all := placement.GetTopServers(hash, 0); len(all) != 3 || all[1] != top[1] {
+			t.Fatalf("Expected every server with the same preference order for %s, got %v", hash, all)
+		}
+	}
+}
+
+func TestRendezvousOnlyMovesBlocksOfRemovedServer(t *testing.T) {
+	before := surfstore.NewRendezvousHash(rendezvousServers())
+	after := surfstore.NewRendezvousHash(rendezvousServers()[1:])
+
+	for _, hash := range benchmarkHashes(1000) {
+		owner := before.GetResponsibleServer(hash)
+		if owner == "localhost:8080" {
+			// the block goes to the next server in its preference order
+			if after.GetResponsibleServer(hash) != before.GetTopServers(hash, 2)[1] {
+				t.Fatalf("Block %s should move to its second choice", hash)
+			}
+		} else if after.GetResponsibleServer(hash) != owner {
+			t.Fatalf("Block %s moved off %s although it stayed", hash, owner)
+		}
+	}
+}
+
+func TestConfigSelectsPlacementStrategy(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.txt")
+	contents := `{"RaftAddrs": ["localhost:9007"], "BlockAddrs": ["localhost:8080", "localhost:8081"], "Placement": "rendezvous"}`
+	if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Could not write config: %s", err.Error())
+	}
+
+	config := surfstore.LoadRaftConfigFile(configFile)
+	if _, ok := config.GetPlacementStrategy().(*surfstore.RendezvousHash); !ok {
+		t.Fatalf("Expected rendezvous placement, got %T", config.GetPlacementStrategy())
+	}
+	if config.GetBlockMembership().Placement != surfstore.PLACEMENT_RENDEZVOUS {
+		t.Fatalf("The membership should carry the placement strategy")
+	}
+
+	var unknown surfstore.RaftConfig
+	if err := unknown.UnmarshalJSON([]byte(`{"BlockAddrs": ["localhost:8080"], "Placement": "random"}`)); err == nil {
+		t.Fatalf("An unknown placement strategy should be rejected")
+	}
+
+	for i := 0; i < 10; i++ {
+		hash := surfstore.GetBlockHashString([]byte(strconv.Itoa(i)))
+		ring := surfstore.RaftConfig{BlockAddrs: []string{"localhost:8080", "localhost:8081"}}.GetPlacementStrategy()
+		if ring.GetResponsibleServer(hash) != surfstore.NewConsistentHashRing([]string{"localhost:8080", "localhost:8081"}).GetResponsibleServer(hash) {
+			t.Fatalf("The ring should stay the default placement strategy")
+		}
+	}
+}
@@ -26,9 +26,16 @@ type TestInfo struct {
 
 func InitTest(cfgPath string) TestInfo {
 	cfg := surfstore.LoadRaftConfigFile(cfgPath)
+	return initTest(cfgPath, cfg, InitBlockStores(cfg.BlockAddrs))
+}
+
+// Start only the RaftSurfstore servers, for tests that serve the BlockStores themselves
+func InitTestWithoutBlockStores(cfgPath string) TestInfo {
+	cfg := surfstore.LoadRaftConfigFile(cfgPath)
+	return initTest(cfgPath, cfg, make([]*exec.Cmd, 0))
+}
 
-	procs := make([]*exec.Cmd, 0)
-	procs = append(procs, InitBlockStores(cfg.BlockAddrs)...)
+func initTest(cfgPath string, cfg surfstore.RaftConfig, procs []*exec.Cmd) TestInfo {
 	procs = append(procs, InitRaftServers(cfgPath, cfg)...)
 
 	conns := make([]*grpc.ClientConn, 0)
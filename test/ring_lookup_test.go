@@ -0,0 +1,99 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// The lookup the ring used before it kept a sorted token table: collect and
+// sort the tokens on every call, then scan for the first one after the block
+func legacyResponsibleServer(serverMap map[string]string, blockId string) string {
+	serverHashes := make([]string, 0)
+	for k := range serverMap {
+		serverHashes = append(serverHashes, k)
+	}
+	sort.Strings(serverHashes)
+
+	responsibleServerHash := ""
+	for _, serverHash := range serverHashes {
+		if serverHash > blockId {
+			responsibleServerHash = serverHash
+			break
+		}
+	}
+	if responsibleServerHash == "" && len(serverHashes) > 0 {
+		responsibleServerHash = serverHashes[0]
+	}
+	return serverMap[responsibleServerHash]
+}
+
+func benchmarkRing() *surfstore.ConsistentHashRing {
+	return surfstore.NewWeightedConsistentHashRing([]surfstore.BlockServerConfig{
+		{Addr: "localhost:8080", Weight: 1},
+		{Addr: "localhost:8081", Weight: 1},
+		{Addr: "localhost:8082", Weight: 2},
+	}, 64)
+}
+
+func benchmarkHashes(count int) []string {
+	hashes := make([]string, count)
+	for i := range hashes {
+		hashes[i] = surfstore.GetBlockHashString([]byte(strconv.Itoa(i)))
+	}
+	return hashes
+}
+
+func TestRingLookupsMatchLegacyLookup(t *testing.T) {
+	ring := benchmarkRing()
+	hashes := benchmarkHashes(2000)
+	// tokens themselves are the boundary case: a block equal to a token belongs to the next one
+	for token := range ring.ServerMap {
+		hashes = append(hashes, token)
+	}
+
+	bulk := ring.LookupMany(hashes)
+	for idx, hash := range hashes {
+		expected := legacyResponsibleServer(ring.ServerMap, hash)
+		if ring.GetResponsibleServer(hash) != expected || bulk[idx] != expected {
+			t.Fatalf("Lookups disagree on %s: expected %s, got %s and %s", hash, expected, ring.GetResponsibleServer(hash), bulk[idx])
+		}
+	}
+
+	empty := surfstore.NewConsistentHashRing([]string{})
+	if empty.GetResponsibleServer(hashes[0]) != "" || empty.LookupMany(hashes[:1])[0] != "" {
+		t.Fatalf("An empty ring should have no responsible server")
+	}
+}
+
+func BenchmarkLegacyLookup(b *testing.B) {
+	ring := benchmarkRing()
+	hashes := benchmarkHashes(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, hash := range hashes {
+			legacyResponsibleServer(ring.ServerMap, hash)
+		}
+	}
+}
+
+func BenchmarkGetResponsibleServer(b *testing.B) {
+	ring := benchmarkRing()
+	hashes := benchmarkHashes(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for _, hash := range hashes {
+			ring.GetResponsibleServer(hash)
+		}
+	}
+}
+
+func BenchmarkLookupMany(b *testing.B) {
+	ring := benchmarkRing()
+	hashes := benchmarkHashes(1000)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ring.LookupMany(hashes)
+	}
+}
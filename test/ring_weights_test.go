@@ -0,0 +1,73 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestConfigAcceptsWeightedBlockAddrs(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.txt")
+	contents := `{
+		"RaftAddrs": ["localhost:9007"],
+		"BlockAddrs": ["localhost:8080", {"Addr": "localhost:8081", "Weight": 3}],
+		"VirtualNodes": 64
+	}`
+	if err := os.WriteFile(configFile, []byte(contents), 0644); err != nil {
+		t.Fatalf("Could not write config: %s", err.Error())
+	}
+
+	config := surfstore.LoadRaftConfigFile(configFile)
+	if !SameHashList(config.BlockAddrs, []string{"localhost:8080", "localhost:8081"}) {
+		t.Fatalf("Unexpected BlockAddrs %v", config.BlockAddrs)
+	}
+	servers := config.GetBlockServers()
+	if len(servers) != 2 || servers[0].Weight != 1 || servers[1].Weight != 3 || config.VirtualNodes != 64 {
+		t.Fatalf("Unexpected BlockServers %v, VirtualNodes %d", servers, config.VirtualNodes)
+	}
+}
+
+func TestWeightedRingSpreadsBlocksByWeight(t *testing.T) {
+	servers := []surfstore.BlockServerConfig{
+		{Addr: "localhost:8080", Weight: 1},
+		{Addr: "localhost:8081", Weight: 1},
+		{Addr: "localhost:8082", Weight: 2},
+	}
+	ring := surfstore.NewWeightedConsistentHashRing(servers, 128)
+
+	expected := map[string]float64{"localhost:8080": 0.25, "localhost:8081": 0.25, "localhost:8082": 0.5}
+	shares := ring.GetOwnershipShares()
+	for addr, share := range expected {
+		if math.Abs(shares[addr]-share) > 0.05 {
+			t.Fatalf("Expected %s to own about %.2f of the ring, got %.3f", addr, share, shares[addr])
+		}
+	}
+
+	counts := make(map[string]int)
+	for i := 0; i < 10000; i++ {
+		counts[ring.GetResponsibleServer(surfstore.GetBlockHashString([]byte(strconv.Itoa(i))))]++
+	}
+	for addr, share := range expected {
+		if math.Abs(float64(counts[addr])/10000-share) > 0.05 {
+			t.Fatalf("Expected %s to get about %.2f of the blocks, got %d", addr, share, counts[addr])
+		}
+	}
+}
+
+func TestSingleVirtualNodeMatchesUnweightedRing(t *testing.T) {
+	addrs := []string{"localhost:8080", "localhost:8081", "localhost:8082"}
+	unweighted := surfstore.NewConsistentHashRing(addrs)
+	weighted := surfstore.NewWeightedConsistentHashRing([]surfstore.BlockServerConfig{
+		{Addr: addrs[0], Weight: 1}, {Addr: addrs[1], Weight: 1}, {Addr: addrs[2], Weight: 1},
+	}, 1)
+
+	for i := 0; i < 100; i++ {
+		hash := surfstore.GetBlockHashString([]byte(strconv.Itoa(i)))
+		if unweighted.GetResponsibleServer(hash) != weighted.GetResponsibleServer(hash) {
+			t.Fatalf("Rings disagree on %s", hash)
+		}
+	}
+}
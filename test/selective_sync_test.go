@@ -0,0 +1,130 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSelectiveSyncSelected(t *testing.T) {
+	prefixes, err := surfstore.ParseSelection([]string{"/apps/web/", "libs"})
+	if err != nil || !SameHashList(prefixes, []string{"apps/web", "libs"}) {
+		t.Fatalf("Unexpected prefixes %v (%v)", prefixes, err)
+	}
+	selection := surfstore.SelectiveSync{Prefixes: prefixes}
+	expected := map[string]bool{
+		"apps/":              true, // holds a selected directory
+		"apps/web/":          true,
+		"apps/web/a/b.txt":   true,
+		"apps/website/":      false,
+		"apps/api/":          false,
+		"apps/readme.txt":    false,
+		"libs/util.go":       true,
+		"README.md":          false,
+		"apps":               false, // a file, not the directory
+		"libs/deep/nested/x": true,
+	}
+	for key, selected := range expected {
+		if selection.Selected(key) != selected {
+			t.Fatalf("Expected Selected(%q) to be %t", key, selected)
+		}
+	}
+
+	if everything, err := surfstore.ParseSelection([]string{"apps", "/"}); err != nil || len(everything) != 0 {
+		t.Fatalf("Expected the base directory to select everything, got %v", everything)
+	}
+	if _, err := surfstore.ParseSelection([]string{"../outside"}); err == nil {
+		t.Fatalf("Expected a path outside the base directory to be rejected")
+	}
+}
+
+func TestSelectiveSyncTracksUnselectedFiles(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	files := map[string]string{"apps/web/index.html": "web", "apps/api/main.go": "api", "libs/util.go": "util", "README.md": "readme"}
+	for name, content := range files {
+		path := filepath.Join(worker1.DirectoryName, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create %s: %s", name, err.Error())
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	// only apps/web is downloaded, and later syncs keep the selection
+	if err := SyncClientWithArgs("test1", BLOCK_SIZE, cfgPath, "-select", "apps/web"); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "apps/web/index.html")); string(data) != "web" {
+		t.Fatalf("Expected apps/web/index.html to be downloaded, got %q", string(data))
+	}
+	for _, name := range []string{"apps/api", "libs", "README.md"} {
+		if _, err := os.Stat(filepath.Join(worker2.DirectoryName, name)); !os.IsNotExist(err) {
+			t.Fatalf("Expected %s not to be downloaded, got %v", name, err)
+		}
+	}
+
+	// the unselected files are tracked, not deleted
+	fileInfoMap, err := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if err != nil {
+		t.Fatalf("Could not get the file info map: %s", err.Error())
+	}
+	fileMeta, err := LoadMetaFromDB(worker2.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load meta file for client2")
+	}
+	for name := range files {
+		if fileInfoMap.FileInfoMap[name].Version != 1 || IsTombHashList(fileInfoMap.FileInfoMap[name].BlockHashList) {
+			t.Fatalf("Expected %s to stay at version 1 on the server, got %v", name, fileInfoMap.FileInfoMap[name])
+		}
+		if fileMeta[name] == nil || fileMeta[name].Version != 1 {
+			t.Fatalf("Expected client2 to track %s, got %v", name, fileMeta)
+		}
+	}
+
+	// newer versions of unselected files are tracked, and downloaded once selected
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "libs/util.go"), []byte("util v2"), 0644); err != nil {
+		t.Fatalf("Could not write util.go: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if fileMeta, _ = LoadMetaFromDB(worker2.DirectoryName); fileMeta["libs/util.go"].Version != 2 {
+		t.Fatalf("Expected client2 to track version 2 of libs/util.go, got %v", fileMeta["libs/util.go"])
+	}
+	if err := SyncClientWithArgs("test1", BLOCK_SIZE, cfgPath, "-select", ""); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	files["libs/util.go"] = "util v2"
+	for name, content := range files {
+		if data, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, name)); string(data) != content {
+			t.Fatalf("Expected %s to hold %q once selected, got %q", name, content, string(data))
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package SurfTest
+
+import (
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSyncSkipsFilesWithUnchangedStat(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	defer worker1.CleanUp()
+	oldPath := filepath.Join(worker1.DirectoryName, "old.txt")
+	newPath := filepath.Join(worker1.DirectoryName, "new.txt")
+	lastWeek := time.Now().Add(-7 * 24 * time.Hour)
+
+	// a file changed just before the sync is not cached, it may change again unnoticed
+	if err := os.WriteFile(oldPath, []byte("first"), 0644); err != nil {
+		t.Fatalf("Could not write old.txt: %s", err.Error())
+	}
+	if err := os.Chtimes(oldPath, lastWeek, lastWeek); err != nil {
+		t.Fatalf("Could not set the modification time of old.txt: %s", err.Error())
+	}
+	if err := os.WriteFile(newPath, []byte("first"), 0644); err != nil {
+		t.Fatalf("Could not write new.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileStats, err := surfstore.LoadFileStats(worker1.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load the file stats: %s", err.Error())
+	}
+	info, _ := os.Stat(oldPath)
+	if stat, ok := fileStats["old.txt"]; !ok || stat.Size != 5 || stat.ModTime != info.ModTime().UnixNano() {
+		t.Fatalf("Expected the stat of old.txt to be recorded, got %v", fileStats)
+	}
+	if _, ok := fileStats["new.txt"]; ok {
+		t.Fatalf("Expected the stat of the just written new.txt not to be recorded")
+	}
+
+	// an edit that keeps the size and modification time goes unnoticed, but
+	// for the files not cached and with a full rescan
+	for _, path := range []string{oldPath, newPath} {
+		if err := os.WriteFile(path, []byte("other"), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", path, err.Error())
+		}
+	}
+	if err := os.Chtimes(oldPath, lastWeek, lastWeek); err != nil {
+		t.Fatalf("Could not set the modification time of old.txt: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileInfoMap, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if fileInfoMap.FileInfoMap["old.txt"].Version != 1 || fileInfoMap.FileInfoMap["new.txt"].Version != 2 {
+		t.Fatalf("Expected only new.txt to be rehashed and uploaded, got %v", fileInfoMap.FileInfoMap)
+	}
+
+	if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-full-rescan"); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	fileInfoMap, _ = test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	if fileInfoMap.FileInfoMap["old.txt"].Version != 2 {
+		t.Fatalf("Expected the full rescan to upload old.txt, got %v", fileInfoMap.FileInfoMap["old.txt"])
+	}
+}
@@ -0,0 +1,101 @@
+package SurfTest
+
+import (
+	"bytes"
+	"cse224/proj5/pkg/surfstore"
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSyncPatchesEditedLargeFile(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	data := randomData(2<<20, 5)
+	for _, chunker := range []string{"fixed", "fastcdc"} {
+		name := chunker + ".bin"
+		if err := os.WriteFile(filepath.Join(worker1.DirectoryName, name), data, 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+		if err := SyncClientWithArgs("test0", BLOCK_SIZE, cfgPath, "-chunker", chunker); err != nil {
+			t.Fatalf("Sync failed")
+		}
+		test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	}
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	// bytes inserted and overwritten in the middle, and a block duplicated
+	edited := append([]byte{}, data[:1<<20]...)
+	edited = append(edited, []byte("inserted in the middle")...)
+	edited = append(edited, data[1<<20:]...)
+	copy(edited[3*BLOCK_SIZE:], bytes.Repeat([]byte{'x'}, BLOCK_SIZE))
+	edited = append(edited, data[:BLOCK_SIZE]...)
+	for _, name := range []string{"fixed.bin", "fastcdc.bin"} {
+		if err := os.WriteFile(filepath.Join(worker1.DirectoryName, name), edited, 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	fileInfoMap, _ := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+	for _, name := range []string{"fixed.bin", "fastcdc.bin"} {
+		if got, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, name)); !bytes.Equal(got, edited) {
+			t.Fatalf("Expected %s to be brought up to date, got %d bytes", name, len(got))
+		}
+		if fileInfoMap.FileInfoMap[name].Version != 2 {
+			t.Fatalf("Expected version 2 of %s, got %v", name, fileInfoMap.FileInfoMap[name].Version)
+		}
+	}
+}
+
+func TestSyncStreamsFileLargerThanMemoryBudget(t *testing.T) {
+	cfgPath := streamingTestConfig(t)
+	serveBlockStores(t, STREAMING_BLOCK_ADDRS, newBlockStoreServer, slowStreams())
+	test := InitTestWithoutBlockStores(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	// moved over streams that take seconds, and downloaded in several windows
+	blockSize := 64 * 1024
+	data := randomData(5*surfstore.STREAM_MEMORY_BUDGET/2, 7)
+	if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "large.bin"), data, 0644); err != nil {
+		t.Fatalf("Could not write large.bin: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", blockSize, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	if got, _ := os.ReadFile(filepath.Join(worker2.DirectoryName, "large.bin")); !bytes.Equal(got, data) {
+		t.Fatalf("Expected large.bin to be downloaded whole, got %d bytes", len(got))
+	}
+}
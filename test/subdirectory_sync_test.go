@@ -0,0 +1,86 @@
+package SurfTest
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func TestSyncSubdirectories(t *testing.T) {
+	cfgPath := "./config_files/3nodes.txt"
+	test := InitTest(cfgPath)
+	defer EndTest(test)
+	test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+	worker1 := InitDirectoryWorker("test0", SRC_PATH)
+	worker2 := InitDirectoryWorker("test1", SRC_PATH)
+	defer worker1.CleanUp()
+	defer worker2.CleanUp()
+
+	// the same name in two directories, and an empty directory
+	files := map[string]string{"a/x.txt": "in a", "b/x.txt": "in b", "b/c/y.txt": "deeper"}
+	for name, content := range files {
+		path := filepath.Join(worker1.DirectoryName, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("Could not create %s: %s", name, err.Error())
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatalf("Could not write %s: %s", name, err.Error())
+		}
+	}
+	if err := os.Mkdir(filepath.Join(worker1.DirectoryName, "empty"), 0755); err != nil {
+		t.Fatalf("Could not create empty directory: %s", err.Error())
+	}
+
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+
+	for name, content := range files {
+		data, err := os.ReadFile(filepath.Join(worker2.DirectoryName, name))
+		if err != nil || string(data) != content {
+			t.Fatalf("Expected %s to hold %q, got %q (%v)", name, content, string(data), err)
+		}
+	}
+	if info, err := os.Stat(filepath.Join(worker2.DirectoryName, "empty")); err != nil || !info.IsDir() {
+		t.Fatalf("Expected the empty directory to be synced")
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "x.txt")); err == nil {
+		t.Fatalf("Files should not land in the base directory")
+	}
+
+	fileMeta, err := LoadMetaFromDB(worker2.DirectoryName)
+	if err != nil {
+		t.Fatalf("Could not load meta file for client2")
+	}
+	for _, key := range []string{"a/", "a/x.txt", "b/", "b/x.txt", "b/c/", "b/c/y.txt", "empty/"} {
+		if fileMeta[key] == nil || fileMeta[key].Version != 1 {
+			t.Fatalf("Expected %s at version 1 in client2 metadata, got %v", key, fileMeta)
+		}
+	}
+
+	// removing a directory tree removes it on the other client
+	if err := os.RemoveAll(filepath.Join(worker1.DirectoryName, "b")); err != nil {
+		t.Fatalf("Could not remove b: %s", err.Error())
+	}
+	if err := SyncClient("localhost:8080", "test0", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+	if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+		t.Fatalf("Sync failed")
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "b")); !os.IsNotExist(err) {
+		t.Fatalf("Expected b to be removed on client2, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(worker2.DirectoryName, "a/x.txt")); err != nil {
+		t.Fatalf("a/x.txt should be left alone")
+	}
+}
@@ -314,6 +314,16 @@ func SyncClient(metaAddr, baseDir string, blockSize int, cfgPath string) error {
 	return clientCmd.Run()
 }
 
+// Sync with extra client flags, given before the positional arguments
+func SyncClientWithArgs(baseDir string, blockSize int, cfgPath string, extraArgs ...string) error {
+	args := append(append([]string{"-f", cfgPath}, extraArgs...), baseDir, strconv.Itoa(blockSize))
+	clientCmd := exec.Command("_bin/SurfstoreClientExec", args...)
+	clientCmd.Stderr = os.Stderr
+	clientCmd.Stdout = os.Stdout
+
+	return clientCmd.Run()
+}
+
 func assert(cond bool) {
 	if !cond {
 		debug.PrintStack()
@@ -0,0 +1,105 @@
+package SurfTest
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	emptypb "google.golang.org/protobuf/types/known/emptypb"
+)
+
+func WatchClient(baseDir string, blockSize int, cfgPath string, extraArgs ...string) *exec.Cmd {
+	args := append([]string{"-f", cfgPath, "-watch"}, extraArgs...)
+	clientCmd := exec.Command("_bin/SurfstoreClientExec", append(args, baseDir, strconv.Itoa(blockSize))...)
+	clientCmd.Stderr = os.Stderr
+	clientCmd.Stdout = os.Stdout
+	return clientCmd
+}
+
+// Wait up to timeout for cond to hold
+func eventually(timeout time.Duration, cond func() bool) bool {
+	for deadline := time.Now().Add(timeout); time.Now().Before(deadline); time.Sleep(100 * time.Millisecond) {
+		if cond() {
+			return true
+		}
+	}
+	return cond()
+}
+
+func TestWatchSyncsBothWays(t *testing.T) {
+	modes := map[string][]string{"inotify": {}, "polling": {"-poll", "200ms"}}
+	for mode, extraArgs := range modes {
+		t.Run(mode, func(t *testing.T) {
+			cfgPath := "./config_files/3nodes.txt"
+			test := InitTest(cfgPath)
+			defer EndTest(test)
+			test.Clients[0].SetLeader(test.Context, &emptypb.Empty{})
+			test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+
+			worker1 := InitDirectoryWorker("test0", SRC_PATH)
+			worker2 := InitDirectoryWorker("test1", SRC_PATH)
+			defer worker1.CleanUp()
+			defer worker2.CleanUp()
+
+			watcher := WatchClient("test0", BLOCK_SIZE, cfgPath, extraArgs...)
+			if err := watcher.Start(); err != nil {
+				t.Fatalf("Could not start the watch client: %s", err.Error())
+			}
+			defer watcher.Process.Kill()
+
+			// a local change is uploaded without running a sync
+			time.Sleep(time.Second)
+			if err := os.MkdirAll(filepath.Join(worker1.DirectoryName, "dir"), 0755); err != nil {
+				t.Fatalf("Could not create dir: %s", err.Error())
+			}
+			if err := os.WriteFile(filepath.Join(worker1.DirectoryName, "dir/a.txt"), []byte("from the watcher"), 0644); err != nil {
+				t.Fatalf("Could not write a.txt: %s", err.Error())
+			}
+			uploaded := eventually(10*time.Second, func() bool {
+				fileInfoMap, err := test.Clients[0].GetFileInfoMap(test.Context, &emptypb.Empty{})
+				return err == nil && fileInfoMap.FileInfoMap["dir/a.txt"] != nil
+			})
+			if !uploaded {
+				t.Fatalf("Expected the watch client to upload dir/a.txt")
+			}
+
+			// a remote change is downloaded
+			test.Clients[0].SendHeartbeat(test.Context, &emptypb.Empty{})
+			if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+				t.Fatalf("Sync failed")
+			}
+			if err := os.WriteFile(filepath.Join(worker2.DirectoryName, "dir/a.txt"), []byte("from the other client"), 0644); err != nil {
+				t.Fatalf("Could not write a.txt: %s", err.Error())
+			}
+			if err := SyncClient("localhost:8080", "test1", BLOCK_SIZE, cfgPath); err != nil {
+				t.Fatalf("Sync failed")
+			}
+			downloaded := eventually(10*time.Second, func() bool {
+				data, err := os.ReadFile(filepath.Join(worker1.DirectoryName, "dir/a.txt"))
+				return err == nil && string(data) == "from the other client"
+			})
+			if !downloaded {
+				t.Fatalf("Expected the watch client to download the new dir/a.txt")
+			}
+
+			// SIGTERM stops the client with index.db up to date
+			if err := watcher.Process.Signal(syscall.SIGTERM); err != nil {
+				t.Fatalf("Could not stop the watch client: %s", err.Error())
+			}
+			if err := watcher.Wait(); err != nil {
+				t.Fatalf("Expected a clean shutdown, got %s", err.Error())
+			}
+			fileMeta, err := LoadMetaFromDB(worker1.DirectoryName)
+			if err != nil {
+				t.Fatalf("Could not load meta file for client1")
+			}
+			if fileMeta["dir/a.txt"] == nil || fileMeta["dir/a.txt"].Version != 2 {
+				t.Fatalf("Expected dir/a.txt at version 2 in client1 metadata, got %v", fileMeta)
+			}
+		})
+	}
+}